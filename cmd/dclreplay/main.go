@@ -0,0 +1,165 @@
+// Command dclreplay replays a trace recorded with Manager.StartTrace against
+// one or more candidate policies and prints how each would route the traffic.
+//
+//	dclreplay -trace jobs.trace [-cost cost.json] default loadbalanced small=policy.json
+//
+// Policies are either built in (default, loadbalanced, latency) or NAME=FILE,
+// where FILE holds a JSON policy configuration. The cost model file maps
+// strategy names to their cost, for example
+//
+//	{"QAT": {"setup": "30us", "ns_per_byte": 0.25, "capacity": 8, "algorithms": ["gzip", "zstd"]}}
+//
+// Strategies missing from the file are treated as not installed.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"dcl"
+)
+
+type strategyCost struct {
+	Setup      string   `json:"setup"`
+	NsPerByte  float64  `json:"ns_per_byte"`
+	Capacity   int      `json:"capacity"`
+	Algorithms []string `json:"algorithms"`
+}
+
+var builtins = map[string]dcl.PolicyFunc{
+	"default":      dcl.GetDefaultPolicy(),
+	"loadbalanced": dcl.LoadBalancedPolicy(dcl.MAX_QAT_BINDINGS),
+	"latency":      dcl.LatencyPolicy(0.99, dcl.GetDefaultPolicy()),
+}
+
+func main() {
+	tracePath := flag.String("trace", "", "trace file recorded with Manager.StartTrace")
+	costPath := flag.String("cost", "", "JSON cost model (default: built-in estimates)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -trace FILE [-cost FILE] [POLICY | NAME=FILE]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *tracePath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(os.Stdout, *tracePath, *costPath, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "dclreplay:", err)
+		os.Exit(1)
+	}
+}
+
+func run(out io.Writer, tracePath, costPath string, policies []string) error {
+	f, err := os.Open(tracePath)
+	if err != nil {
+		return err
+	}
+	records, err := dcl.ReadTrace(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	cost := dcl.DefaultCostModel()
+	if costPath != "" {
+		if cost, err = readCostModel(costPath); err != nil {
+			return err
+		}
+	}
+
+	if len(policies) == 0 {
+		policies = []string{"default"}
+	}
+	reports := []dcl.ReplayReport{dcl.SummarizeTrace(records)}
+	for _, arg := range policies {
+		name, policy, err := loadPolicy(arg)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, dcl.Replay(records, name, policy, cost))
+	}
+	return writeReports(out, reports)
+}
+
+func loadPolicy(arg string) (string, dcl.PolicyFunc, error) {
+	name, path, isFile := strings.Cut(arg, "=")
+	if !isFile {
+		policy, ok := builtins[name]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown policy %q", name)
+		}
+		return name, policy, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	cfg, err := dcl.ReadPolicyConfig(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", path, err)
+	}
+	policy, err := cfg.Compile()
+	return name, policy, err
+}
+
+func readCostModel(path string) (dcl.CostModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]strategyCost
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	cost := make(dcl.CostModel)
+	for name, c := range raw {
+		s, err := dcl.ParseStrategyType(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		sc := dcl.StrategyCost{NsPerByte: c.NsPerByte, Capacity: c.Capacity}
+		if c.Setup != "" {
+			if sc.Setup, err = time.ParseDuration(c.Setup); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+			}
+		}
+		for _, a := range c.Algorithms {
+			alg, err := dcl.ParseAlgorithm(a)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+			}
+			sc.Algorithms = append(sc.Algorithms, alg)
+		}
+		cost[s] = sc
+	}
+	return cost, nil
+}
+
+func writeReports(out io.Writer, reports []dcl.ReplayReport) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "POLICY\tREQUESTS\tJOBS")
+	for _, s := range dcl.GetStrategies() {
+		fmt.Fprintf(tw, "\t%s", s)
+	}
+	fmt.Fprintln(tw, "\tFALLBACKS\tUNSERVED\tMEAN\tP50\tP99")
+
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%d\t%d", r.Policy, r.Requests, r.Jobs)
+		for _, s := range dcl.GetStrategies() {
+			fmt.Fprintf(tw, "\t%d", r.Strategies[s])
+		}
+		fmt.Fprintf(tw, "\t%d\t%d\t%v\t%v\t%v\n", r.Fallbacks, r.Unserved, r.MeanLatency(), r.P50, r.P99)
+	}
+	return tw.Flush()
+}
@@ -14,11 +14,12 @@ const (
 )
 
 type Writer struct {
-	w      io.Writer
 	closed bool
+	err    error // first error of a stream, see StreamOption
 	m      *Manager
 	policy PolicyFunc
 	p      JobParams
+	stats  stats
 }
 
 func NewWriter(w io.Writer) *Writer {
@@ -36,30 +37,87 @@ func (z *Writer) Write(p []byte) (n int, err error) {
 	if z.closed {
 		return 0, errClosed
 	}
-	if z.policy == nil {
-		n, z.p.id, err = z.m.SubmitJob(p, z.p)
-		return n, err
-	} else {
-		n, z.p.id, err = z.m.SubmitWithPolicy(p, z.p, z.policy)
-		return n, err
+	if z.err != nil {
+		return 0, z.err
 	}
+	res := z.m.request(p, z.p, z.policy)
+	z.p.id = res.id
+	z.stats.add(&res)
+	if z.p.stream && res.err != nil {
+		// The job is gone, later writes cannot continue its stream.
+		z.err = res.err
+	}
+	return res.n, res.err
+}
+
+// Flush writes out the data compressed so far by a Writer in stream mode, so
+// that a reader can decode everything written before the call. It returns
+// ErrUnsupported if the handler running the stream cannot flush. Without
+// StreamOption every Write is already complete and Flush does nothing.
+func (z *Writer) Flush() error {
+	if z.closed {
+		return errClosed
+	}
+	if !z.p.stream || z.err != nil {
+		return z.err
+	}
+	n, err := z.m.flush(z.p.id)
+	z.stats.output(n)
+	return err
 }
 
 func (z *Writer) SetPolicy(p PolicyFunc) {
 	z.policy = p
 }
 
+// Stats reports the requests made by the Writer since it was created or reset.
+func (z *Writer) Stats() Stats {
+	return z.stats.get(z.p.JobType)
+}
+
+// LastStrategy returns the strategy that served the latest successful request,
+// or false if none has been served yet.
+func (z *Writer) LastStrategy() (StrategyType, bool) {
+	return z.stats.last, z.stats.served
+}
+
+// JobParams returns the parameters the Writer submits its jobs with.
+func (z *Writer) JobParams() JobParams {
+	return z.p
+}
+
+// Close completes the stream of a Writer in stream mode, writing an empty
+// stream if nothing was written. It does not close the underlying writer.
 func (z *Writer) Close() (err error) {
 	if z.closed {
 		return errClosed
 	}
+	if z.p.stream && z.err == nil {
+		if z.p.id == 0 {
+			if _, err = z.Write(nil); err != nil {
+				z.closed = true
+				return err
+			}
+		}
+		var n int64
+		n, err = z.m.end(z.p.id)
+		z.stats.output(n)
+	}
 	z.closed = true
-	return nil
+	return err
 }
 
+// Reset discards the state of the Writer and makes it write to w. A stream
+// left open is completed on the previous writer.
 func (z *Writer) Reset(w io.Writer) {
-	z.w = w
+	if z.p.stream && !z.closed {
+		z.m.end(z.p.id)
+	}
+	z.p.w = w
+	z.p.id = 0
 	z.closed = false
+	z.err = nil
+	z.stats = stats{}
 }
 
 // Apply options to Writer
@@ -0,0 +1,131 @@
+package dcl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"math"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+const (
+	COMPRESSIBILITY_SAMPLE_SZ   = 4096
+	COMPRESSIBILITY_CHUNK_SZ    = 256
+	COMPRESSIBILITY_MIN_SZ      = 512
+	INCOMPRESSIBLE_ENTROPY      = 7.5
+	LOW_COMPRESSIBILITY_ENTROPY = 6.5
+)
+
+// Compressibility is a cheap estimate of how well a buffer will compress.
+type Compressibility struct {
+	Entropy float64 // Shannon entropy of the sample in bits per byte (0-8)
+	Format  string  // Compressed or media format recognized from its magic bytes
+	Sampled int     // Number of bytes inspected
+}
+
+// Incompressible reports whether compressing the buffer is likely a waste of
+// time: it is already in a compressed format or looks random.
+func (c Compressibility) Incompressible() bool {
+	return c.Format != "" || c.Entropy >= INCOMPRESSIBLE_ENTROPY
+}
+
+var magicNumbers = []struct {
+	format string
+	offset int
+	magic  []byte
+}{
+	{"gzip", 0, []byte{0x1f, 0x8b}},
+	{"zstd", 0, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"lz4", 0, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{"bzip2", 0, []byte("BZh")},
+	{"xz", 0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"7z", 0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+	{"zip", 0, []byte{'P', 'K', 0x03, 0x04}},
+	{"snappy", 0, []byte{0xff, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p', 'Y'}},
+	{"jpeg", 0, []byte{0xff, 0xd8, 0xff}},
+	{"png", 0, []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}},
+	{"gif", 0, []byte("GIF8")},
+	{"webp", 8, []byte("WEBP")},
+	{"mp4", 4, []byte("ftyp")},
+}
+
+// DetectFormat returns the name of the compressed or media format p starts
+// with, or an empty string if none is recognized.
+func DetectFormat(p []byte) string {
+	for _, m := range magicNumbers {
+		if len(p) >= m.offset+len(m.magic) && bytes.Equal(p[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.format
+		}
+	}
+	return ""
+}
+
+// EstimateCompressibility samples up to COMPRESSIBILITY_SAMPLE_SZ bytes spread
+// across p and estimates their entropy. Buffers shorter than
+// COMPRESSIBILITY_MIN_SZ are only checked for magic bytes, since the entropy
+// of so few bytes says little about the result.
+func EstimateCompressibility(p []byte) (c Compressibility) {
+	c.Format = DetectFormat(p)
+	if len(p) < COMPRESSIBILITY_MIN_SZ {
+		return c
+	}
+
+	var counts [256]int
+	if len(p) <= COMPRESSIBILITY_SAMPLE_SZ {
+		for _, b := range p {
+			counts[b]++
+		}
+		c.Sampled = len(p)
+	} else {
+		chunks := COMPRESSIBILITY_SAMPLE_SZ / COMPRESSIBILITY_CHUNK_SZ
+		stride := (len(p) - COMPRESSIBILITY_CHUNK_SZ) / (chunks - 1)
+		for i := 0; i < chunks; i++ {
+			for _, b := range p[i*stride : i*stride+COMPRESSIBILITY_CHUNK_SZ] {
+				counts[b]++
+			}
+		}
+		c.Sampled = chunks * COMPRESSIBILITY_CHUNK_SZ
+	}
+
+	total := float64(c.Sampled)
+	for _, n := range counts {
+		if n > 0 {
+			f := float64(n) / total
+			c.Entropy -= f * math.Log2(f)
+		}
+	}
+	return c
+}
+
+// CompressibilityPolicy wraps next so that compress jobs which are unlikely to
+// shrink do not use an accelerator slot or an expensive level. Incompressible
+// buffers are sent to the software path at its cheapest level (stored blocks
+// for gzip), and buffers with low compressibility are capped at level 1.
+// Everything else, including all decompress jobs, is routed by next.
+func CompressibilityPolicy(next PolicyFunc) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		if params.JobParams.JobType != COMPRESS {
+			return next(params)
+		}
+
+		c := params.Compressibility()
+		if c.Incompressible() {
+			params.OverrideLevel(fastestLevel(params.JobParams.a))
+			return []StrategyType{DEFAULT}
+		}
+		if c.Entropy >= LOW_COMPRESSIBILITY_ENTROPY && params.JobParams.level > 1 {
+			params.OverrideLevel(1)
+		}
+		return next(params)
+	}
+}
+
+func fastestLevel(a Algorithm) int {
+	switch a {
+	case DEFLATE, GZIP:
+		return gzip.NoCompression
+	case LZ4:
+		return int(lz4.Fast)
+	}
+	return 1
+}
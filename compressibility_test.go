@@ -0,0 +1,84 @@
+package dcl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestManager() *Manager {
+	return newManager()
+}
+
+// newSimulatedManager returns a Manager with simulated handlers, of which the
+// ones for notInstalled report that they are missing.
+func newSimulatedManager(t *testing.T, notInstalled ...StrategyType) *Manager {
+	m, err := NewManager(SimulatedHandlersOption())
+	if err != nil {
+		t.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	for _, s := range notInstalled {
+		m.getHandler(s).(*SimulatedHandler).SetInstalled(false)
+	}
+	return m
+}
+
+func randomBytes(n int) []byte {
+	p := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(p)
+	return p
+}
+
+func TestEstimateCompressibility(t *testing.T) {
+	text := []byte(strings.Repeat("Hello World\n", 10000))
+	gz := new(bytes.Buffer)
+	w := gzip.NewWriter(gz)
+	w.Write(randomBytes(1000))
+	w.Close()
+
+	if c := EstimateCompressibility(text); c.Incompressible() || c.Entropy > 4 {
+		t.Errorf("TestFail: text estimated as incompressible: %+v", c)
+	}
+	if c := EstimateCompressibility(randomBytes(100000)); !c.Incompressible() || c.Sampled != COMPRESSIBILITY_SAMPLE_SZ {
+		t.Errorf("TestFail: random data estimated as compressible: %+v", c)
+	}
+	if c := EstimateCompressibility(gz.Bytes()); c.Format != "gzip" {
+		t.Errorf("TestFail: gzip data not recognized: %+v", c)
+	}
+	if c := EstimateCompressibility([]byte{0xff, 0xd8, 0xff, 0xe0}); c.Format != "jpeg" || c.Sampled != 0 {
+		t.Errorf("TestFail: short jpeg header not recognized: %+v", c)
+	}
+}
+
+func TestCompressibilityPolicy(t *testing.T) {
+	policy := CompressibilityPolicy(fixed(QAT, DEFAULT))
+
+	random := PolicyParameters{BufferSize: 8192, JobParams: JobParams{a: GZIP, level: 6}, buf: randomBytes(8192)}
+	if got := policy(&random); !reflect.DeepEqual(got, []StrategyType{DEFAULT}) || !random.levelOverridden || random.level != gzip.NoCompression {
+		t.Errorf("TestFail: random data routed to %v at level %d", got, random.level)
+	}
+
+	text := []byte(strings.Repeat("Hello World\n", 1000))
+	plain := PolicyParameters{BufferSize: len(text), JobParams: JobParams{a: GZIP, level: 6}, buf: text}
+	if got := policy(&plain); !reflect.DeepEqual(got, []StrategyType{QAT, DEFAULT}) || plain.levelOverridden {
+		t.Errorf("TestFail: text routed to %v, level overridden %v", got, plain.levelOverridden)
+	}
+
+	m := newTestManager()
+	input := randomBytes(70000)
+	b := new(bytes.Buffer)
+	z := NewWriter(b)
+	z.m = m
+	z.Apply(CompressionLevelOption(9))
+	z.SetPolicy(CompressibilityPolicy(fixed(QAT)))
+	if _, err := z.Write(input); err != nil {
+		t.Fatalf("TestFail: write failed with '%v'", err)
+	}
+	if b.Len() < len(input) {
+		t.Errorf("TestFail: expected stored blocks, output is %d bytes for %d input bytes", b.Len(), len(input))
+	}
+	v[GZIP].Validate(string(input), b.Bytes(), t)
+}
@@ -82,8 +82,7 @@ type LZ4Validator struct{}
 
 func (v *LZ4Validator) Validate(input string, output []byte, t *testing.T) {
 	r := lz4.NewReader(bytes.NewReader(output))
-	decompressed := make([]byte, len(input)*2)
-	_, err := r.Read(decompressed)
+	decompressed, err := io.ReadAll(r)
 	if err != nil {
 		t.Fatalf("error decompressing lz4 data: %v", err)
 		return
@@ -164,7 +163,7 @@ func TestHandlers(t *testing.T) {
 		{
 			algorithm:     ZSTD,
 			strategies:    []StrategyType{ISAL},
-			expectedError: errNoWorkingStrategies,
+			expectedError: ErrNoWorkingStrategies,
 			validate:      nil,
 		},
 
@@ -0,0 +1,235 @@
+package dclarchive
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dcl"
+)
+
+var (
+	ErrExtension = errors.New("archive extension unknown")
+	ErrEntryPath = errors.New("archive entry path outside the directory")
+	ErrEntryType = errors.New("archive entry type unsupported")
+)
+
+// extensions maps the extensions of compressed tar files onto algorithms.
+var extensions = []struct {
+	ext string
+	alg dcl.Algorithm
+}{
+	{".tar.gz", dcl.GZIP},
+	{".tgz", dcl.GZIP},
+	{".tar.zst", dcl.ZSTD},
+	{".tar.zstd", dcl.ZSTD},
+	{".tzst", dcl.ZSTD},
+	{".tar.lz4", dcl.LZ4},
+}
+
+// AlgorithmFor returns the algorithm of a compressed tar file from the
+// extension of its name, such as .tar.gz, .tar.zst or .tar.lz4.
+func AlgorithmFor(name string) (dcl.Algorithm, error) {
+	name = strings.ToLower(name)
+	for _, e := range extensions {
+		if strings.HasSuffix(name, e.ext) {
+			return e.alg, nil
+		}
+	}
+	return 0, ErrExtension
+}
+
+// Writer is a tar.Writer whose stream is compressed by a dcl Writer.
+type Writer struct {
+	*tar.Writer
+	z *dcl.Writer
+}
+
+// NewWriter returns a Writer compressing a tar stream to w, with the
+// algorithm of the file name. options are applied to the dcl Writer.
+func NewWriter(w io.Writer, name string, options ...dcl.Option) (*Writer, error) {
+	alg, err := AlgorithmFor(name)
+	if err != nil {
+		return nil, err
+	}
+	z := dcl.NewWriter(w)
+	if err := z.Apply(writerOptions(alg, options)...); err != nil {
+		return nil, err
+	}
+	return &Writer{Writer: tar.NewWriter(z), z: z}, nil
+}
+
+// Close writes the end of the tar stream and completes the compressed
+// stream. It does not close the underlying writer.
+func (tw *Writer) Close() error {
+	err := tw.Writer.Close()
+	if zerr := tw.z.Close(); err == nil {
+		err = zerr
+	}
+	return err
+}
+
+// Reader is a tar.Reader whose stream is decompressed by a dcl Reader.
+type Reader struct {
+	*tar.Reader
+	z *dcl.Reader
+}
+
+// NewReader returns a Reader of the compressed tar stream r, with the
+// algorithm of the file name. options are applied to the dcl Reader.
+func NewReader(r io.Reader, name string, options ...dcl.Option) (*Reader, error) {
+	alg, err := AlgorithmFor(name)
+	if err != nil {
+		return nil, err
+	}
+	z := dcl.NewReader(r)
+	if err := z.Apply(readerOptions(alg, options)...); err != nil {
+		return nil, err
+	}
+	return &Reader{Reader: tar.NewReader(z), z: z}, nil
+}
+
+// Close releases the decompression job of a stream that was not read to the
+// end. It does not close the underlying reader.
+func (tr *Reader) Close() error {
+	return tr.z.Close()
+}
+
+// Pack writes the directories and regular files of fsys to w as a compressed
+// tar stream, with the algorithm of the file name.
+func Pack(w io.Writer, name string, fsys fs.FS, options ...dcl.Option) error {
+	tw, err := NewWriter(w, name, options...)
+	if err != nil {
+		return err
+	}
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return ErrEntryType
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := fsys.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if cerr := tw.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Unpack extracts the compressed tar stream r into dir, with the algorithm of
+// the file name. Directories, regular files and symbolic links are extracted;
+// entries and links leading outside dir return ErrEntryPath.
+func Unpack(r io.Reader, name, dir string, options ...dcl.Option) error {
+	tr, err := NewReader(r, name, options...)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if !filepath.IsLocal(path) {
+			return ErrEntryPath
+		}
+		if err := checkLinks(dir, path); err != nil {
+			return err
+		}
+		target := filepath.Join(dir, path)
+		mode := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0o700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			link := filepath.FromSlash(hdr.Linkname)
+			if filepath.IsAbs(link) || !filepath.IsLocal(filepath.Join(filepath.Dir(path), link)) {
+				return ErrEntryPath
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			return ErrEntryType
+		}
+	}
+}
+
+// checkLinks returns ErrEntryPath if path, or a directory on the way to it,
+// is a symbolic link below dir. Links are only checked by their text when
+// they are created, so entries must not be written through them.
+func checkLinks(dir, path string) error {
+	p := dir
+	for _, part := range strings.Split(path, string(filepath.Separator)) {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return ErrEntryPath
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, r io.Reader, mode fs.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package dclarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"dcl"
+	"dcl/dcltest"
+)
+
+func TestTar(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":       {Data: []byte(testData), Mode: 0o644},
+		"dir/b.txt":   {Data: []byte(testData[:100]), Mode: 0o600},
+		"dir/c/empty": {Mode: 0o644},
+	}
+	options := []dcl.Option{dcl.ManagerOption(dcltest.NewManager(t))}
+	for _, name := range []string{"out.tar.gz", "out.tgz", "out.tar.zst", "out.tar.lz4", "OUT.TAR.GZ"} {
+		b := new(bytes.Buffer)
+		if err := Pack(b, name, fsys, options...); err != nil {
+			t.Fatalf("TestFail: %s pack failed with '%v'", name, err)
+		}
+		dir := t.TempDir()
+		if err := Unpack(bytes.NewReader(b.Bytes()), name, dir, options...); err != nil {
+			t.Fatalf("TestFail: %s unpack failed with '%v'", name, err)
+		}
+		for path, f := range fsys {
+			got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+			if err != nil || !bytes.Equal(got, f.Data) {
+				t.Errorf("TestFail: %s entry %s extracted as %d bytes, '%v'", name, path, len(got), err)
+			}
+			if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path))); err == nil && info.Mode().Perm() != f.Mode {
+				t.Errorf("TestFail: %s entry %s extracted with mode %v", name, path, info.Mode())
+			}
+		}
+	}
+
+	if err := Pack(io.Discard, "out.zip", fsys); err != ErrExtension {
+		t.Errorf("TestFail: unknown extension returned '%v'", err)
+	}
+}
+
+// tarGz returns a tar.gz stream of the given headers, written by the Go
+// packages.
+func tarGz(headers ...*tar.Header) []byte {
+	b := new(bytes.Buffer)
+	zw := gzip.NewWriter(b)
+	tw := tar.NewWriter(zw)
+	for _, hdr := range headers {
+		tw.WriteHeader(hdr)
+		tw.Write(make([]byte, hdr.Size))
+	}
+	tw.Close()
+	zw.Close()
+	return b.Bytes()
+}
+
+func TestUnpack(t *testing.T) {
+	options := []dcl.Option{dcl.ManagerOption(dcltest.NewManager(t))}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Size: 10, Mode: 0o644}
+	}
+	link := func(name, target string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}
+	}
+	for name, tc := range map[string]struct {
+		hdrs []*tar.Header
+		err  error
+	}{
+		"file":          {[]*tar.Header{file("a/b.txt")}, nil},
+		"link":          {[]*tar.Header{link("a/link", "../c")}, nil},
+		"parent":        {[]*tar.Header{file("../evil")}, ErrEntryPath},
+		"absolute":      {[]*tar.Header{file("/evil")}, ErrEntryPath},
+		"escaping link": {[]*tar.Header{link("a/link", "../../evil")}, ErrEntryPath},
+		"absolute link": {[]*tar.Header{link("link", "/etc/passwd")}, ErrEntryPath},
+		"device":        {[]*tar.Header{{Name: "dev", Typeflag: tar.TypeChar}}, ErrEntryType},
+		"through link":  {[]*tar.Header{link("a/dir", "."), file("a/dir/b.txt")}, ErrEntryPath},
+		"over link":     {[]*tar.Header{link("a/b.txt", "c.txt"), file("a/b.txt")}, ErrEntryPath},
+		"chained links": {[]*tar.Header{
+			link("a/b/deep", "."),
+			link("a/b/deep/deep/esc", "../../../.."),
+			file("a/b/deep/deep/esc/pwned"),
+		}, ErrEntryPath},
+	} {
+		root := t.TempDir()
+		dir := filepath.Join(root, "x", "out")
+		if err := Unpack(bytes.NewReader(tarGz(tc.hdrs...)), "in.tar.gz", dir, options...); err != tc.err {
+			t.Errorf("TestFail: %s entry returned '%v', expected '%v'", name, err, tc.err)
+		}
+		if _, err := os.Lstat(filepath.Join(root, "pwned")); err == nil {
+			t.Errorf("TestFail: %s entry escaped the directory", name)
+		}
+	}
+}
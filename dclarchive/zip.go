@@ -0,0 +1,77 @@
+// Package dclarchive builds and extracts archives with dcl codecs. It
+// registers dcl with archive/zip, and packs and unpacks compressed tar
+// streams whose algorithm follows the file extension.
+package dclarchive
+
+import (
+	"archive/zip"
+	"io"
+
+	"dcl"
+)
+
+// ZSTD_METHOD is the zip compression method of zstd, as assigned by the
+// APPNOTE. archive/zip does not know it, so entries written with it are only
+// readable by readers that register a decompressor for it.
+const ZSTD_METHOD uint16 = 93
+
+// RegisterCompressors makes w compress Deflate and ZSTD_METHOD entries with
+// dcl. options, such as dcl.CompressionLevelOption or dcl.ManagerOption, are
+// applied to every entry.
+func RegisterCompressors(w *zip.Writer, options ...dcl.Option) {
+	w.RegisterCompressor(zip.Deflate, compressor(dcl.DEFLATE, options))
+	w.RegisterCompressor(ZSTD_METHOD, compressor(dcl.ZSTD, options))
+}
+
+// RegisterDecompressors makes r decompress Deflate and ZSTD_METHOD entries
+// with dcl. Pass &rc.Reader for a zip.ReadCloser.
+func RegisterDecompressors(r *zip.Reader, options ...dcl.Option) {
+	r.RegisterDecompressor(zip.Deflate, decompressor(dcl.DEFLATE, options))
+	r.RegisterDecompressor(ZSTD_METHOD, decompressor(dcl.ZSTD, options))
+}
+
+// compressor returns a zip.Compressor writing the entry as one dcl stream.
+func compressor(alg dcl.Algorithm, options []dcl.Option) zip.Compressor {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		z := dcl.NewWriter(w)
+		if err := z.Apply(writerOptions(alg, options)...); err != nil {
+			return nil, err
+		}
+		return z, nil
+	}
+}
+
+// decompressor returns a zip.Decompressor reading the entry through a dcl
+// Reader. An invalid option is returned by the first Read, as a
+// zip.Decompressor cannot fail.
+func decompressor(alg dcl.Algorithm, options []dcl.Option) zip.Decompressor {
+	return func(r io.Reader) io.ReadCloser {
+		z := dcl.NewReader(r)
+		if err := z.Apply(readerOptions(alg, options)...); err != nil {
+			return errReader{err}
+		}
+		return z
+	}
+}
+
+// writerOptions returns the options of a dcl Writer compressing an entry or
+// an archive. The caller's options come last, so they can set the level.
+func writerOptions(alg dcl.Algorithm, options []dcl.Option) []dcl.Option {
+	return append([]dcl.Option{dcl.AlgorithmOption(alg), dcl.StreamOption(true)}, options...)
+}
+
+func readerOptions(alg dcl.Algorithm, options []dcl.Option) []dcl.Option {
+	return append([]dcl.Option{dcl.AlgorithmOption(alg)}, options...)
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func (r errReader) Close() error {
+	return nil
+}
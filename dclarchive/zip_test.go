@@ -0,0 +1,80 @@
+package dclarchive
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"dcl"
+	"dcl/dcltest"
+)
+
+var testData = strings.Repeat("Hello World\n", 1000)
+
+func readEntry(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	return string(b), err
+}
+
+func TestZip(t *testing.T) {
+	var requests int
+	options := []dcl.Option{
+		dcl.ManagerOption(dcltest.NewManager(t)),
+		dcl.PolicyOption(func(*dcl.PolicyParameters) []dcl.StrategyType {
+			requests++
+			return []dcl.StrategyType{dcl.QAT, dcl.DEFAULT}
+		}),
+	}
+	b := new(bytes.Buffer)
+	w := zip.NewWriter(b)
+	RegisterCompressors(w, options...)
+	for _, e := range []struct {
+		name   string
+		method uint16
+	}{{"deflate.txt", zip.Deflate}, {"zstd.txt", ZSTD_METHOD}, {"empty.txt", zip.Deflate}} {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatalf("TestFail: could not create %s: '%v'", e.name, err)
+		}
+		if e.name != "empty.txt" {
+			io.WriteString(f, testData)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("TestFail: could not close archive: '%v'", err)
+	}
+	if requests != 3 {
+		t.Errorf("TestFail: %d entries compressed with dcl, expected 3", requests)
+	}
+
+	// archive/zip reads the Deflate entries but not the zstd one.
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("TestFail: archive rejected with '%v'", err)
+	}
+	if got, err := readEntry(r.File[0]); err != nil || got != testData {
+		t.Errorf("TestFail: Deflate entry read back as %d bytes, '%v'", len(got), err)
+	}
+	if _, err := readEntry(r.File[1]); err != zip.ErrAlgorithm {
+		t.Errorf("TestFail: zstd entry without a decompressor returned '%v'", err)
+	}
+
+	RegisterDecompressors(r, options...)
+	for i, want := range []string{testData, testData, ""} {
+		if got, err := readEntry(r.File[i]); err != nil || got != want {
+			t.Errorf("TestFail: %s read back as %d bytes, '%v'", r.File[i].Name, len(got), err)
+		}
+	}
+
+	RegisterDecompressors(r, dcl.WindowSizeOption(-1))
+	if _, err := readEntry(r.File[0]); err != dcl.ErrParamWindowSize {
+		t.Errorf("TestFail: invalid option returned '%v'", err)
+	}
+}
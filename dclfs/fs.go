@@ -0,0 +1,340 @@
+// Package dclfs reads pre-compressed files through dcl. FS wraps another
+// fs.FS and exposes foo.json.zst, foo.json.gz or foo.json.lz4 as foo.json,
+// decompressed by a dcl Reader. Files without a compressed variant are
+// served as they are.
+package dclfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dcl"
+)
+
+// variants are the extensions of compressed files, in order of preference
+// when a file has several.
+var variants = []struct {
+	ext string
+	alg dcl.Algorithm
+}{
+	{".zst", dcl.ZSTD},
+	{".gz", dcl.GZIP},
+	{".lz4", dcl.LZ4},
+}
+
+// FS is an fs.FS that decompresses the compressed variants of its files. It
+// implements fs.StatFS and fs.ReadDirFS, and directories list the variants
+// under their uncompressed names.
+type FS struct {
+	fsys    fs.FS
+	options []dcl.Option
+}
+
+// New returns an FS reading fsys. options, such as dcl.ManagerOption, are
+// applied to every dcl Reader.
+func New(fsys fs.FS, options ...dcl.Option) *FS {
+	return &FS{fsys: fsys, options: options}
+}
+
+// Open opens the first compressed variant of name, or name itself if it has
+// none. A variant is read through a dcl Reader and its Stat reports the
+// uncompressed name and size.
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		for _, v := range variants {
+			raw, err := f.fsys.Open(name + v.ext)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			info, err := raw.Stat()
+			if err != nil {
+				raw.Close()
+				return nil, err
+			}
+			if info.IsDir() {
+				raw.Close()
+				continue
+			}
+			return f.newFile(name, name+v.ext, v.alg, raw, info)
+		}
+	}
+
+	raw, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := raw.(fs.ReadDirFile); ok {
+		if info, err := raw.Stat(); err == nil && info.IsDir() {
+			return &dir{ReadDirFile: d, f: f, name: name}, nil
+		}
+	}
+	return raw, nil
+}
+
+// Stat returns the FileInfo of the file Open returns for name.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+// ReadDir lists the directory name, with compressed variants under their
+// uncompressed names.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.entries(name, entries), nil
+}
+
+// entries maps the entries of the directory name onto the files Open returns.
+func (f *FS) entries(name string, entries []fs.DirEntry) []fs.DirEntry {
+	present := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		present[e.Name()] = true
+	}
+	hasVariant := func(base string) bool {
+		for _, v := range variants {
+			if present[base+v.ext] {
+				return true
+			}
+		}
+		return false
+	}
+
+	seen := make(map[string]bool, len(entries))
+	out := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		base := e.Name()
+		if !e.IsDir() {
+			base = trimVariant(base)
+		}
+		if seen[base] {
+			continue
+		}
+		if base == e.Name() && (e.IsDir() || !hasVariant(base)) {
+			seen[base] = true
+			out = append(out, e)
+			continue
+		}
+		if base == e.Name() {
+			// The compressed variant is listed instead.
+			continue
+		}
+		seen[base] = true
+		out = append(out, &dirEntry{f: f, name: base, path: path.Join(name, base), typ: e.Type()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out
+}
+
+// trimVariant removes the extension of a compressed variant from name.
+func trimVariant(name string) string {
+	for _, v := range variants {
+		if base := strings.TrimSuffix(name, v.ext); base != name && base != "" {
+			return base
+		}
+	}
+	return name
+}
+
+type dirEntry struct {
+	f    *FS
+	name string
+	path string
+	typ  fs.FileMode
+}
+
+func (e *dirEntry) Name() string               { return e.name }
+func (e *dirEntry) IsDir() bool                { return false }
+func (e *dirEntry) Type() fs.FileMode          { return e.typ }
+func (e *dirEntry) Info() (fs.FileInfo, error) { return e.f.Stat(e.path) }
+
+// dir is a directory of the FS, listing compressed variants like ReadDir.
+type dir struct {
+	fs.ReadDirFile
+	f       *FS
+	name    string
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.ReadDirFile.ReadDir(-1)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		d.entries = d.f.entries(d.name, entries)
+		d.read = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// fileInfo describes a compressed variant by its uncompressed name and size.
+type fileInfo struct {
+	raw  fs.FileInfo
+	name string
+	once sync.Once
+	size int64
+	err  error
+	stat func() (int64, error)
+}
+
+func (i *fileInfo) uncompressedSize() (int64, error) {
+	i.once.Do(func() { i.size, i.err = i.stat() })
+	return i.size, i.err
+}
+
+func (i *fileInfo) Name() string { return i.name }
+
+// Size returns the uncompressed size, or -1 if the file cannot be read.
+func (i *fileInfo) Size() int64 {
+	size, err := i.uncompressedSize()
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func (i *fileInfo) Mode() fs.FileMode  { return i.raw.Mode() }
+func (i *fileInfo) ModTime() time.Time { return i.raw.ModTime() }
+func (i *fileInfo) IsDir() bool        { return false }
+func (i *fileInfo) Sys() any           { return i.raw.Sys() }
+
+// file is a compressed variant read through a dcl Reader. It seeks by
+// decompressing again from the start when going back, and only when read,
+// so that finding the size with Seek costs nothing.
+type file struct {
+	f       *FS
+	name    string
+	rawName string
+	raw     fs.File
+	z       *dcl.Reader
+	info    *fileInfo
+	pos     int64 // of the dcl Reader
+	off     int64 // of the next Read
+	eof     bool
+}
+
+func (f *FS) newFile(name, rawName string, alg dcl.Algorithm, raw fs.File, info fs.FileInfo) (*file, error) {
+	z := dcl.NewReader(raw)
+	if err := z.Apply(append([]dcl.Option{dcl.AlgorithmOption(alg)}, f.options...)...); err != nil {
+		raw.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	fi := &fileInfo{raw: info, name: path.Base(name)}
+	fi.stat = func() (int64, error) { return f.size(rawName, alg, info.Size()) }
+	return &file{f: f, name: name, rawName: rawName, raw: raw, z: z, info: fi}, nil
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.off != f.pos {
+		if err := f.seek(); err != nil {
+			return 0, err
+		}
+	}
+	return f.read(p)
+}
+
+func (f *file) read(p []byte) (int, error) {
+	if f.eof {
+		return 0, io.EOF
+	}
+	n, err := f.z.Read(p)
+	f.pos += int64(n)
+	f.off = f.pos
+	if err == io.EOF {
+		f.eof = true
+	}
+	return n, err
+}
+
+// seek moves the dcl Reader to the offset of the next Read.
+func (f *file) seek() error {
+	off := f.off
+	if off < f.pos {
+		f.z.Close()
+		f.raw.Close()
+		raw, err := f.f.fsys.Open(f.rawName)
+		if err != nil {
+			return err
+		}
+		f.raw = raw
+		f.z.Reset(raw)
+		f.pos, f.eof = 0, false
+	}
+	_, err := io.CopyN(io.Discard, readerFunc(f.read), off-f.pos)
+	if err == io.EOF {
+		err = nil
+	}
+	f.off = off
+	return err
+}
+
+// Seek sets the offset of the next Read. Offsets past the end read io.EOF.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		size, err := f.info.uncompressedSize()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	f.z.Close()
+	return f.raw.Close()
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (r readerFunc) Read(p []byte) (int, error) {
+	return r(p)
+}
@@ -0,0 +1,166 @@
+package dclfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"dcl"
+	"dcl/dcltest"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+var testData = strings.Repeat("Hello World\n", 1000)
+
+// largeData compresses to less than the size of a short member, so the
+// trailer of a short member after it looks plausible.
+var largeData = strings.Repeat("Hello World\n", 1<<20/12)
+
+func gzipped(s string) []byte {
+	b := new(bytes.Buffer)
+	w := gzip.NewWriter(b)
+	io.WriteString(w, s)
+	w.Close()
+	return b.Bytes()
+}
+
+func zstded(s string) []byte {
+	e, _ := zstd.NewWriter(nil)
+	return e.EncodeAll([]byte(s), nil)
+}
+
+func lz4ed(s string, options ...lz4.Option) []byte {
+	b := new(bytes.Buffer)
+	w := lz4.NewWriter(b)
+	w.Apply(options...)
+	io.WriteString(w, s)
+	w.Close()
+	return b.Bytes()
+}
+
+func testFS(t *testing.T) (*FS, map[string]string) {
+	m := fstest.MapFS{
+		"data/a.json.gz":  {Data: gzipped(testData)},
+		"data/b.json.zst": {Data: zstded(testData[:500])},
+		"data/c.txt.lz4":  {Data: lz4ed(testData[:700])},
+		"data/d.txt":      {Data: []byte("raw")},
+		"data/e.txt":      {Data: []byte("stale")},
+		"data/e.txt.gz":   {Data: gzipped("fresh")},
+		"f.bin.lz4":       {Data: lz4ed(testData, lz4.SizeOption(uint64(len(testData))))},
+		"g.txt.gz":        {Data: gzipped("")},
+		"h.txt.gz":        {Data: append(gzipped(testData), gzipped("x")...)},
+		"i.txt.zst":       {Data: append(zstded(testData), zstded("x")...)},
+		"j.txt.gz":        {Data: append(gzipped(largeData), gzipped(testData[:10000])...)},
+	}
+	expected := map[string]string{
+		"data/a.json": testData,
+		"data/b.json": testData[:500],
+		"data/c.txt":  testData[:700],
+		"data/d.txt":  "raw",
+		"data/e.txt":  "fresh",
+		"f.bin":       testData,
+		"g.txt":       "",
+		"h.txt":       testData + "x",
+		"i.txt":       testData + "x",
+		"j.txt":       largeData + testData[:10000],
+	}
+	return New(m, dcl.ManagerOption(dcltest.NewManager(t))), expected
+}
+
+func TestFS(t *testing.T) {
+	fsys, expected := testFS(t)
+	var names []string
+	for name, want := range expected {
+		names = append(names, name)
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil || string(got) != want {
+			t.Errorf("TestFail: %s read as %d bytes, '%v'", name, len(got), err)
+		}
+		info, err := fs.Stat(fsys, name)
+		if err != nil || info.Size() != int64(len(want)) || info.Name() != name[strings.LastIndex(name, "/")+1:] {
+			t.Errorf("TestFail: %s stat returned %v, '%v'", name, info, err)
+		}
+	}
+	if err := fstest.TestFS(fsys, names...); err != nil {
+		t.Errorf("TestFail: %v", err)
+	}
+
+	// The compressed files themselves can still be opened.
+	if b, err := fs.ReadFile(fsys, "data/a.json.gz"); err != nil || !bytes.Equal(b, gzipped(testData)) {
+		t.Errorf("TestFail: compressed file read as %d bytes, '%v'", len(b), err)
+	}
+	if _, err := fsys.Open("data/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("TestFail: missing file returned '%v'", err)
+	}
+}
+
+func TestRecordedSize(t *testing.T) {
+	fsys, _ := testFS(t)
+	for _, tc := range []struct {
+		name string
+		alg  dcl.Algorithm
+		ok   bool
+	}{
+		{"data/a.json.gz", dcl.GZIP, false},
+		{"data/b.json.zst", dcl.ZSTD, true},
+		{"data/c.txt.lz4", dcl.LZ4, false},
+		{"f.bin.lz4", dcl.LZ4, true},
+		{"i.txt.zst", dcl.ZSTD, false},
+	} {
+		info, _ := fs.Stat(fsys.fsys, tc.name)
+		if _, ok := fsys.recordedSize(tc.name, tc.alg, info.Size()); ok != tc.ok {
+			t.Errorf("TestFail: %s size recorded %v, expected %v", tc.name, ok, tc.ok)
+		}
+	}
+}
+
+func TestRecordedSizeMismatch(t *testing.T) {
+	sized := lz4ed(testData, lz4.SizeOption(uint64(len(testData))))
+	fsys := New(fstest.MapFS{
+		"c.lz4": {Data: append(sized, sized...)},
+		"d.zst": {Data: zstded(testData)[:20]},
+	})
+	for name, alg := range map[string]dcl.Algorithm{"c.lz4": dcl.LZ4, "d.zst": dcl.ZSTD} {
+		info, _ := fs.Stat(fsys.fsys, name)
+		if size, ok := fsys.recordedSize(name, alg, info.Size()); ok {
+			t.Errorf("TestFail: %s size %d trusted", name, size)
+		}
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	fsys, _ := testFS(t)
+	srv := httptest.NewServer(http.FileServer(http.FS(fsys)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/data/a.json")
+	if err != nil {
+		t.Fatalf("TestFail: request failed with '%v'", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != testData || resp.ContentLength != int64(len(testData)) {
+		t.Errorf("TestFail: served %d bytes with Content-Length %d", len(body), resp.ContentLength)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/data/a.json", nil)
+	req.Header.Set("Range", "bytes=100-199")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("TestFail: range request failed with '%v'", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != testData[100:200] {
+		t.Errorf("TestFail: range served as %q", body)
+	}
+}
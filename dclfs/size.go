@@ -0,0 +1,156 @@
+package dclfs
+
+import (
+	"encoding/binary"
+	"io"
+
+	"dcl"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	lz4Magic           = 0x184D2204
+	lz4DictID          = 1 << 0
+	lz4ContentChecksum = 1 << 2
+	lz4ContentSize     = 1 << 3
+	lz4BlockChecksum   = 1 << 4
+)
+
+// size returns the uncompressed size of a compressed variant. The size
+// recorded by the format is used when it can be trusted: the content size of
+// a zstd or lz4 frame that makes up the whole file. Otherwise the file is
+// decompressed to count its bytes. This includes gzip files, as the trailer
+// only holds the size of the last member, and finding where the members
+// start takes decoding them.
+func (f *FS) size(rawName string, alg dcl.Algorithm, compressed int64) (int64, error) {
+	if size, ok := f.recordedSize(rawName, alg, compressed); ok {
+		return size, nil
+	}
+	raw, err := f.fsys.Open(rawName)
+	if err != nil {
+		return 0, err
+	}
+	defer raw.Close()
+	z := dcl.NewReader(raw)
+	defer z.Close()
+	if err := z.Apply(append([]dcl.Option{dcl.AlgorithmOption(alg)}, f.options...)...); err != nil {
+		return 0, err
+	}
+	return io.Copy(io.Discard, z)
+}
+
+// recordedSize reads the uncompressed size from the header or trailer of a
+// compressed variant, or returns false if it has none that can be trusted.
+func (f *FS) recordedSize(rawName string, alg dcl.Algorithm, compressed int64) (int64, bool) {
+	raw, err := f.fsys.Open(rawName)
+	if err != nil {
+		return 0, false
+	}
+	defer raw.Close()
+
+	switch alg {
+	case dcl.ZSTD:
+		return zstdSize(raw, compressed)
+	case dcl.LZ4:
+		return lz4Size(raw, compressed)
+	}
+	return 0, false
+}
+
+// zstdSize returns the content size of the first zstd frame, if the frame
+// ends at the end of the file.
+func zstdSize(raw io.Reader, compressed int64) (int64, bool) {
+	b := make([]byte, zstd.HeaderMaxSize)
+	n, _ := io.ReadFull(raw, b)
+	var h zstd.Header
+	if h.Decode(b[:n]) != nil || h.Skippable || !h.HasFCS {
+		return 0, false
+	}
+	// Each block starts with 3 bytes: the last block flag, the type and
+	// the size, which is 1 for RLE blocks.
+	off := int64(h.HeaderSize)
+	for last := false; !last; {
+		var bh [4]byte
+		if off+3 > compressed || !readAt(raw, bh[:3], off) {
+			return 0, false
+		}
+		v := binary.LittleEndian.Uint32(bh[:])
+		last = v&1 != 0
+		size := int64(v >> 3)
+		switch (v >> 1) & 3 {
+		case 1:
+			size = 1
+		case 3:
+			return 0, false
+		}
+		off += 3 + size
+	}
+	if h.HasCheckSum {
+		off += 4
+	}
+	if off != compressed {
+		return 0, false
+	}
+	return int64(h.FrameContentSize), true
+}
+
+// lz4Size returns the content size of the first lz4 frame, if the frame
+// ends at the end of the file.
+func lz4Size(raw io.Reader, compressed int64) (int64, bool) {
+	// Magic, FLG and BD, then the content size if FLG has bit 3 set.
+	var b [14]byte
+	if _, err := io.ReadFull(raw, b[:]); err != nil {
+		return 0, false
+	}
+	flags := b[4]
+	if binary.LittleEndian.Uint32(b[:4]) != lz4Magic || flags&lz4ContentSize == 0 {
+		return 0, false
+	}
+	// The descriptor ends with the dictionary ID, if any, and a checksum
+	// byte. Each block starts with its size, the high bit marking
+	// uncompressed blocks, and a size of 0 ends the frame.
+	off := int64(len(b)) + 1
+	if flags&lz4DictID != 0 {
+		off += 4
+	}
+	for {
+		var bs [4]byte
+		if off+4 > compressed || !readAt(raw, bs[:], off) {
+			return 0, false
+		}
+		off += 4
+		size := int64(binary.LittleEndian.Uint32(bs[:]) &^ (1 << 31))
+		if size == 0 {
+			break
+		}
+		off += size
+		if flags&lz4BlockChecksum != 0 {
+			off += 4
+		}
+	}
+	if flags&lz4ContentChecksum != 0 {
+		off += 4
+	}
+	if off != compressed {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint64(b[6:])), true
+}
+
+// readAt fills b from offset off of a file that supports io.ReaderAt or
+// io.Seeker, and reports whether it could.
+func readAt(r io.Reader, b []byte, off int64) bool {
+	if ra, ok := r.(io.ReaderAt); ok {
+		_, err := ra.ReadAt(b, off)
+		return err == nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		if _, err := s.Seek(off, io.SeekStart); err != nil {
+			return false
+		}
+		_, err := io.ReadFull(r, b)
+		return err == nil
+	}
+	return false
+}
@@ -0,0 +1,428 @@
+// Package dclhttp compresses HTTP bodies with dcl, so that accelerators serve
+// the web tier. NewHandler wraps an http.Handler and compresses its responses
+// with the Content-Encoding negotiated from Accept-Encoding.
+package dclhttp
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dcl"
+)
+
+var (
+	ErrEncoding = errors.New("unknown content encoding")
+	ErrMinSize  = errors.New("minimum size invalid")
+)
+
+// DEFAULT_MIN_SIZE is the smallest response compressed when Config.MinSize is
+// zero. Smaller bodies gain little and cost a job.
+const DEFAULT_MIN_SIZE = 1024
+
+var (
+	// DEFAULT_ENCODINGS are the encodings offered when Config.Encodings is
+	// empty, in order of preference. lz4 is not a registered HTTP coding, so
+	// it is only used when both sides list it.
+	DEFAULT_ENCODINGS = []string{"zstd", "gzip", "deflate"}
+
+	// DEFAULT_SKIP_TYPES are media types that are already compressed. A type
+	// ending in "/" matches every subtype.
+	DEFAULT_SKIP_TYPES = []string{
+		"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+		"video/", "audio/", "font/woff", "font/woff2",
+		"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+	}
+)
+
+// codings maps the HTTP content codings onto dcl algorithms. deflate is the
+// zlib format.
+var codings = map[string]dcl.Algorithm{
+	"gzip":    dcl.GZIP,
+	"deflate": dcl.DEFLATE,
+	"zstd":    dcl.ZSTD,
+	"lz4":     dcl.LZ4,
+}
+
+// Config describes how a handler compresses its responses. The zero value
+// offers DEFAULT_ENCODINGS at dcl.DEFAULT_LEVEL.
+type Config struct {
+	// Encodings offered, in order of preference when the client gives them
+	// the same q-value. Any of gzip, deflate, zstd and lz4.
+	Encodings []string
+	// Responses below MinSize bytes are sent as is, DEFAULT_MIN_SIZE if zero.
+	MinSize int
+	// Compression level, dcl.DEFAULT_LEVEL if zero.
+	Level int
+	// Media types sent as is, DEFAULT_SKIP_TYPES if nil.
+	SkipTypes []string
+	// Policy of the route, the policy of the Manager if nil.
+	Policy dcl.PolicyFunc
+	// Options applied to every dcl Writer, such as dcl.ManagerOption.
+	Options []dcl.Option
+}
+
+type handler struct {
+	next      http.Handler
+	encodings []string
+	minSize   int
+	skipTypes []string
+	options   map[string][]dcl.Option // of the Writer of each encoding
+}
+
+// NewHandler returns a handler that serves next and compresses its responses.
+// Wrap each route with its own Config to give it its own policy.
+func NewHandler(next http.Handler, cfg Config) (http.Handler, error) {
+	h := &handler{
+		next:      next,
+		encodings: cfg.Encodings,
+		minSize:   cfg.MinSize,
+		skipTypes: cfg.SkipTypes,
+	}
+	if len(h.encodings) == 0 {
+		h.encodings = DEFAULT_ENCODINGS
+	}
+	for _, e := range h.encodings {
+		if _, ok := codings[e]; !ok {
+			return nil, ErrEncoding
+		}
+	}
+	if h.minSize < 0 {
+		return nil, ErrMinSize
+	}
+	if h.minSize == 0 {
+		h.minSize = DEFAULT_MIN_SIZE
+	}
+	if h.skipTypes == nil {
+		h.skipTypes = DEFAULT_SKIP_TYPES
+	}
+	level := cfg.Level
+	if level == 0 {
+		level = dcl.DEFAULT_LEVEL
+	}
+	h.options = make(map[string][]dcl.Option)
+	for _, e := range h.encodings {
+		options := []dcl.Option{
+			dcl.AlgorithmOption(codings[e]),
+			dcl.CompressionLevelOption(level),
+			dcl.StreamOption(true),
+		}
+		if cfg.Policy != nil {
+			options = append(options, dcl.PolicyOption(cfg.Policy))
+		}
+		options = append(options, cfg.Options...)
+
+		// Report invalid options now rather than on the first response.
+		if err := dcl.NewWriter(io.Discard).Apply(options...); err != nil {
+			return nil, err
+		}
+		h.options[e] = options
+	}
+	return h, nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{
+		ResponseWriter: w,
+		h:              h,
+		encoding:       h.negotiate(r.Header.Get("Accept-Encoding")),
+		head:           r.Method == http.MethodHead,
+	}
+	rw.body.rw = rw
+	defer rw.close()
+	h.next.ServeHTTP(rw, r)
+}
+
+// negotiate returns the offered encoding with the highest q-value in an
+// Accept-Encoding header, or "" if none is acceptable (RFC 9110, 12.5.3).
+func (h *handler) negotiate(header string) string {
+	accepted := make(map[string]float64)
+	star := -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(param, "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || v < 0 || v > 1 {
+				v = 0
+			}
+			q = v
+		}
+		if name == "*" {
+			star = q
+		} else {
+			accepted[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, e := range h.encodings {
+		q, ok := accepted[e]
+		if !ok {
+			if star < 0 || e == "lz4" {
+				// lz4 must be listed, a wildcard does not agree to it.
+				continue
+			}
+			q = star
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// skipType reports whether a Content-Type is already compressed.
+func (h *handler) skipType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range h.skipTypes {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// encoder is the compressor of a body, a dcl Writer or its zlib framing.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// newEncoder returns the compressor of a content coding writing to w. options
+// hold the algorithm of the coding. flush restricts it to handlers that can
+// flush.
+func newEncoder(w io.Writer, encoding string, options []dcl.Option, flush bool) (encoder, error) {
+	var zw *zlibWriter
+	if encoding == "deflate" {
+		zw, w = newZlibWriter(w)
+	}
+	z := dcl.NewWriter(w)
+	if err := z.Apply(append(options[:len(options):len(options)], dcl.FlushOption(flush))...); err != nil {
+		return nil, err
+	}
+	if zw != nil {
+		zw.z = z
+		return zw, nil
+	}
+	return z, nil
+}
+
+// responseWriter buffers the start of a response until it knows whether to
+// compress it: once MinSize bytes are written, on Flush or at the end.
+type responseWriter struct {
+	http.ResponseWriter
+	h        *handler
+	encoding string
+	head     bool
+	status   int
+	buf      []byte
+	decided  bool
+	enc      encoder
+	body     bodyWriter
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if status >= 100 && status < 200 {
+		// Informational responses go out as is.
+		rw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if rw.status == 0 {
+		rw.status = status
+	}
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if !rw.decided {
+		rw.buf = append(rw.buf, p...)
+		if len(rw.buf) < rw.h.minSize {
+			return len(p), nil
+		}
+		if err := rw.decide(false, false); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if rw.enc != nil {
+		return rw.enc.Write(p)
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Flush sends the response written so far to the client.
+func (rw *responseWriter) Flush() {
+	rw.FlushError()
+}
+
+// FlushError is Flush for http.ResponseController. Responses flushed before
+// MinSize bytes, and streaming types, are compressed by handlers that can
+// flush. Otherwise it may return dcl.ErrUnsupported, and the compressed data
+// reaches the client with the rest of the response.
+func (rw *responseWriter) FlushError() error {
+	if !rw.decided {
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		if err := rw.decide(false, true); err != nil {
+			return err
+		}
+	}
+	var err error
+	if rw.enc != nil {
+		err = rw.enc.Flush()
+	}
+	rw.body.writeHeader()
+	if ferr := http.NewResponseController(rw.ResponseWriter).Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// decide writes the header, compressed or not, and then the buffered start
+// of the body. At the end of the response, final is set and bodies below
+// MinSize are sent as is. flush is set when the handler flushes the response.
+func (rw *responseWriter) decide(final, flush bool) error {
+	rw.decided = true
+	header := rw.Header()
+	compress := rw.encoding != "" && !rw.head
+	if header.Get("Content-Encoding") != "" {
+		compress = false
+	} else {
+		contentType := header.Get("Content-Type")
+		if contentType == "" && len(rw.buf) > 0 {
+			contentType = http.DetectContentType(rw.buf)
+			header.Set("Content-Type", contentType)
+		}
+		if rw.h.skipType(contentType) {
+			compress = false
+		} else {
+			addVary(header)
+		}
+		flush = flush || streamType(contentType)
+	}
+	switch rw.status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		compress = false
+	}
+	if final && len(rw.buf) < rw.h.minSize {
+		compress = false
+	}
+	if compress && header.Get("Content-Type") == "" && len(rw.buf) == 0 {
+		// The type cannot be sniffed from compressed data.
+		compress = false
+	}
+
+	if compress {
+		enc, err := newEncoder(&rw.body, rw.encoding, rw.h.options[rw.encoding], flush)
+		if err != nil {
+			compress = false
+		}
+		rw.enc = enc
+	}
+	if !compress {
+		rw.body.writeHeader()
+		_, err := rw.ResponseWriter.Write(rw.buf)
+		rw.buf = nil
+		return err
+	}
+
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", rw.encoding)
+	buf := rw.buf
+	rw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := rw.enc.Write(buf)
+	if err != nil && !rw.body.wroteHeader {
+		// The strategy failed before any output, send the body as is.
+		rw.enc = nil
+		header.Del("Content-Encoding")
+		rw.body.writeHeader()
+		_, err = rw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// bodyWriter is the destination of the compressor. It sends the header with
+// the first compressed bytes, so that a compressor failing before any output
+// can fall back to an uncompressed body.
+type bodyWriter struct {
+	rw          *responseWriter
+	wroteHeader bool
+}
+
+func (b *bodyWriter) writeHeader() {
+	if !b.wroteHeader {
+		b.wroteHeader = true
+		b.rw.ResponseWriter.WriteHeader(b.rw.status)
+	}
+}
+
+func (b *bodyWriter) Write(p []byte) (int, error) {
+	b.writeHeader()
+	return b.rw.ResponseWriter.Write(p)
+}
+
+// close completes the response after the wrapped handler returns.
+func (rw *responseWriter) close() {
+	if !rw.decided {
+		if rw.status == 0 && len(rw.buf) == 0 {
+			// Nothing was written, let net/http send its default response.
+			return
+		}
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		rw.decide(true, false)
+	}
+	if rw.enc != nil {
+		rw.enc.Close()
+	}
+	rw.body.writeHeader()
+}
+
+// streamType reports whether a Content-Type is flushed as it is written,
+// such as server-sent events.
+func streamType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/event-stream"
+}
+
+// addVary adds Accept-Encoding to the Vary header, as the response depends
+// on it.
+func addVary(header http.Header) {
+	for _, v := range header.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "*" || strings.EqualFold(f, "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	header.Add("Vary", "Accept-Encoding")
+}
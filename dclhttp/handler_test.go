@@ -0,0 +1,272 @@
+package dclhttp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dcl"
+	"dcl/dcltest"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+var testBody = strings.Repeat("Hello World\n", 1000)
+
+// decode reads a body in the given content coding with the Go codecs.
+func decode(t *testing.T, encoding string, body []byte) string {
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "":
+		r = bytes.NewReader(body)
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	case "zstd":
+		r, err = zstd.NewReader(bytes.NewReader(body))
+	case "lz4":
+		r = lz4.NewReader(bytes.NewReader(body))
+	default:
+		t.Fatalf("TestFail: unexpected Content-Encoding %q", encoding)
+	}
+	if err != nil {
+		t.Fatalf("TestFail: %s body rejected with '%v'", encoding, err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("TestFail: %s body read failed with '%v'", encoding, err)
+	}
+	return string(out)
+}
+
+func serve(t *testing.T, cfg Config, next http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	h, err := NewHandler(next, cfg)
+	if err != nil {
+		t.Fatalf("TestInit: could not create handler: '%v'", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func writeBody(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "12000")
+		for i := 0; i < len(body); i += 500 {
+			io.WriteString(w, body[i:i+500])
+		}
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	m := dcltest.NewManager(t)
+	cfg := Config{Options: []dcl.Option{dcl.ManagerOption(m)}}
+	lz4cfg := Config{Encodings: []string{"lz4", "gzip"}, Options: cfg.Options}
+	for _, tc := range []struct {
+		cfg    Config
+		accept string
+		want   string
+	}{
+		{cfg, "gzip", "gzip"},
+		{cfg, "gzip, deflate, zstd", "zstd"},
+		{cfg, "gzip;q=1.0, zstd;q=0.5", "gzip"},
+		{cfg, "deflate, gzip;q=0.8", "deflate"},
+		{cfg, "zstd;q=0, gzip;q=0", ""},
+		{cfg, "*", "zstd"},
+		{cfg, "*;q=0.5, gzip", "gzip"},
+		{cfg, "br", ""},
+		{cfg, "", ""},
+		{cfg, "GZIP; Q=0.9", "gzip"},
+		{cfg, "gzip;q=bogus", ""},
+		{lz4cfg, "*", "gzip"},
+		{lz4cfg, "lz4, gzip", "lz4"},
+	} {
+		rec := serve(t, tc.cfg, writeBody(testBody), tc.accept)
+		got := rec.Header().Get("Content-Encoding")
+		if got != tc.want {
+			t.Errorf("TestFail: Accept-Encoding %q selected %q, expected %q", tc.accept, got, tc.want)
+			continue
+		}
+		if out := decode(t, got, rec.Body.Bytes()); out != testBody {
+			t.Errorf("TestFail: %q body read back as %d bytes", got, len(out))
+		}
+		if got != "" && rec.Header().Get("Content-Length") != "" {
+			t.Errorf("TestFail: Content-Length kept on a %s body", got)
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("TestFail: Accept-Encoding %q gave Vary %q", tc.accept, rec.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	m := dcltest.NewManager(t)
+	cfg := Config{Options: []dcl.Option{dcl.ManagerOption(m)}}
+	for name, next := range map[string]http.HandlerFunc{
+		"small": writeBody(testBody[:1000]),
+		"image": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, testBody)
+		},
+		"encoded": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, testBody)
+		},
+		"not modified": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		},
+	} {
+		rec := serve(t, cfg, next, "gzip")
+		if ce := rec.Header().Get("Content-Encoding"); ce == "gzip" {
+			t.Errorf("TestFail: %s response compressed", name)
+		}
+	}
+
+	// Without a Content-Type the body is sniffed before compressing.
+	rec := serve(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html>"+testBody)
+	}, "gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("TestFail: sniffed response sent with headers %v", rec.Header())
+	}
+	if out := decode(t, "gzip", rec.Body.Bytes()); out != "<html>"+testBody {
+		t.Errorf("TestFail: sniffed body read back as %d bytes", len(out))
+	}
+
+	if _, err := NewHandler(http.NotFoundHandler(), Config{Encodings: []string{"br"}}); err != ErrEncoding {
+		t.Errorf("TestFail: unknown encoding returned '%v'", err)
+	}
+	if _, err := NewHandler(http.NotFoundHandler(), Config{MinSize: -1}); err != ErrMinSize {
+		t.Errorf("TestFail: negative minimum size returned '%v'", err)
+	}
+	if _, err := NewHandler(http.NotFoundHandler(), Config{Level: -5}); err != dcl.ErrParamCompressionLevel {
+		t.Errorf("TestFail: invalid level returned '%v'", err)
+	}
+}
+
+// noFlush hides Flush from the handler of a strategy.
+func noFlush(next dcl.Handler) dcl.Handler {
+	return dcl.HandlerFuncs{RequestFunc: next.Request, ReleaseFunc: next.Release}
+}
+
+func TestFlush(t *testing.T) {
+	m := dcltest.NewManager(t)
+	// QAT comes first but cannot flush.
+	m.Use(dcl.QAT, noFlush)
+	flushed := make(chan struct{})
+	flushErr, timedOut := make(chan error, 1), make(chan bool, 1)
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		flushErr <- http.NewResponseController(w).Flush()
+		select {
+		case <-flushed:
+			timedOut <- false
+		case <-time.After(5 * time.Second):
+			timedOut <- true
+		}
+		io.WriteString(w, "data: second\n\n")
+	}
+	h, err := NewHandler(http.HandlerFunc(next), Config{
+		Encodings: []string{"gzip"},
+		Options:   []dcl.Option{dcl.ManagerOption(m)},
+	})
+	if err != nil {
+		t.Fatalf("TestInit: could not create handler: '%v'", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("TestFail: request failed with '%v'", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("TestFail: streamed response not compressed: %v", resp.Header)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("TestFail: gzip header not flushed: '%v'", err)
+	}
+	line, err := bufio.NewReader(zr).ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Errorf("TestFail: first event read as %q, '%v'", line, err)
+	}
+	close(flushed)
+	if err := <-flushErr; err != nil || <-timedOut {
+		t.Errorf("TestFail: first event not flushed, flush returned '%v'", err)
+	}
+
+	// A response that started compressing before the first flush may run on
+	// a handler that cannot flush. The error is reported and the data is
+	// sent at the end.
+	rec := serve(t, Config{Options: []dcl.Option{dcl.ManagerOption(m)}}, func(w http.ResponseWriter, r *http.Request) {
+		writeBody(testBody)(w, r)
+		if err := http.NewResponseController(w).Flush(); err != dcl.ErrUnsupported {
+			t.Errorf("TestFail: flush on QAT returned '%v'", err)
+		}
+	}, "gzip")
+	if got := decode(t, rec.Header().Get("Content-Encoding"), rec.Body.Bytes()); got != testBody {
+		t.Errorf("TestFail: body read back as %d bytes", len(got))
+	}
+}
+
+func TestRoutePolicy(t *testing.T) {
+	m := dcltest.NewManager(t)
+	var calls int
+	policy := func(*dcl.PolicyParameters) []dcl.StrategyType {
+		calls++
+		return []dcl.StrategyType{dcl.DEFAULT}
+	}
+	api, err := NewHandler(writeBody(testBody), Config{Policy: policy, Options: []dcl.Option{dcl.ManagerOption(m)}})
+	if err != nil {
+		t.Fatalf("TestInit: could not create handler: '%v'", err)
+	}
+	static, err := NewHandler(writeBody(testBody), Config{Options: []dcl.Option{dcl.ManagerOption(m)}})
+	if err != nil {
+		t.Fatalf("TestInit: could not create handler: '%v'", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api", api)
+	mux.Handle("/static", static)
+	for _, path := range []string{"/api", "/static"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if calls != 1 {
+		t.Errorf("TestFail: route policy evaluated %d times, expected once", calls)
+	}
+}
+
+func TestFailedCompression(t *testing.T) {
+	cfg := dcl.SimulatedConfigFor(dcl.QAT)
+	cfg.Fault = dcl.FailJobs(errors.New("device failure"), -1)
+	m := dcltest.NewManager(t)
+	m.Apply(dcl.HandlerOption(dcl.QAT, dcl.NewSimulatedHandler(cfg)))
+
+	// The device fails before any output, so the body is sent as is.
+	for _, encoding := range []string{"gzip", "deflate"} {
+		rec := serve(t, Config{Options: []dcl.Option{dcl.ManagerOption(m)}}, writeBody(testBody), encoding)
+		if ce := rec.Header().Get("Content-Encoding"); ce != "" || rec.Body.String() != testBody {
+			t.Errorf("TestFail: failed %s compression sent %d bytes as %q", encoding, rec.Body.Len(), ce)
+		}
+	}
+}
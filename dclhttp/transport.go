@@ -0,0 +1,231 @@
+package dclhttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"dcl"
+)
+
+// DEFAULT_REQUEST_ENCODING compresses request bodies when
+// Transport.RequestEncoding is empty, as it is the coding servers most
+// often accept.
+const DEFAULT_REQUEST_ENCODING = "gzip"
+
+// Transport is an http.RoundTripper that advertises the encodings dcl
+// supports and decompresses response bodies through a dcl Reader. Request
+// bodies of at least MinRequestSize bytes are compressed as well.
+//
+// Requests that already set Accept-Encoding are left to the caller, like
+// http.Transport does for gzip.
+type Transport struct {
+	// Base sends the requests, http.DefaultTransport if nil.
+	Base http.RoundTripper
+	// Encodings advertised in Accept-Encoding, DEFAULT_ENCODINGS if empty.
+	Encodings []string
+	// Request bodies of at least MinRequestSize bytes are compressed; zero
+	// sends them as is.
+	MinRequestSize int
+	// Coding of compressed request bodies, DEFAULT_REQUEST_ENCODING if empty.
+	RequestEncoding string
+	// Compression level of request bodies, dcl.DEFAULT_LEVEL if zero.
+	Level int
+	// Policy of the Readers and Writers, the policy of the Manager if nil.
+	Policy dcl.PolicyFunc
+	// Options applied to every dcl Reader and Writer, such as
+	// dcl.ManagerOption.
+	Options []dcl.Option
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) encodings() []string {
+	if len(t.Encodings) != 0 {
+		return t.Encodings
+	}
+	return DEFAULT_ENCODINGS
+}
+
+// options returns the options of a dcl Reader or Writer for a content coding.
+func (t *Transport) options(encoding string, level int) []dcl.Option {
+	options := []dcl.Option{dcl.AlgorithmOption(codings[encoding])}
+	if level != 0 {
+		options = append(options, dcl.CompressionLevelOption(level), dcl.StreamOption(true))
+	}
+	if t.Policy != nil {
+		options = append(options, dcl.PolicyOption(t.Policy))
+	}
+	return append(options, t.Options...)
+}
+
+// RoundTrip implements http.RoundTripper. It does not modify req.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, e := range t.encodings() {
+		if _, ok := codings[e]; !ok {
+			closeBody(req)
+			return nil, ErrEncoding
+		}
+	}
+	out := req.Clone(req.Context())
+	decode := out.Header.Get("Accept-Encoding") == "" && out.Header.Get("Range") == ""
+	if decode {
+		out.Header.Set("Accept-Encoding", strings.Join(t.encodings(), ", "))
+	}
+	if err := t.compressRequest(out); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+
+	resp, err := t.base().RoundTrip(out)
+	if err != nil || !decode {
+		return resp, err
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if !contains(t.encodings(), encoding) || resp.Body == nil || resp.Body == http.NoBody {
+		return resp, nil
+	}
+	resp.Body = &decodedBody{body: resp.Body, encoding: encoding, options: t.options(encoding, 0)}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// compressRequest replaces the body of req with its compressed form when it
+// has at least MinRequestSize bytes. Bodies of unknown length, which
+// http.NewRequest reports as zero, are read up to MinRequestSize to decide.
+func (t *Transport) compressRequest(req *http.Request) error {
+	if t.MinRequestSize <= 0 || req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	encoding := t.RequestEncoding
+	if encoding == "" {
+		encoding = DEFAULT_REQUEST_ENCODING
+	}
+	if _, ok := codings[encoding]; !ok {
+		return ErrEncoding
+	}
+	level := t.Level
+	if level == 0 {
+		level = dcl.DEFAULT_LEVEL
+	}
+	options := t.options(encoding, level)
+	if err := dcl.NewWriter(io.Discard).Apply(options...); err != nil {
+		return err
+	}
+
+	var prefix []byte
+	if req.ContentLength <= 0 {
+		var err error
+		prefix, err = io.ReadAll(io.LimitReader(req.Body, int64(t.MinRequestSize)))
+		if err != nil {
+			return err
+		}
+		if len(prefix) < t.MinRequestSize {
+			// The whole body is small, send it as is.
+			req.Body.Close()
+			req.Body = io.NopCloser(bytes.NewReader(prefix))
+			req.ContentLength = int64(len(prefix))
+			return nil
+		}
+	} else if req.ContentLength < int64(t.MinRequestSize) {
+		return nil
+	}
+
+	req.Body = compressBody(req.Body, prefix, encoding, options)
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return compressBody(body, nil, encoding, options), nil
+		}
+	}
+	req.ContentLength = -1
+	req.Header.Del("Content-Length")
+	req.Header.Set("Content-Encoding", encoding)
+	return nil
+}
+
+// compressBody returns the compressed form of prefix followed by body. It is
+// compressed while the request is sent.
+func compressBody(body io.ReadCloser, prefix []byte, encoding string, options []dcl.Option) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		enc, err := newEncoder(pw, encoding, options, false)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if len(prefix) > 0 {
+			_, err = enc.Write(prefix)
+		}
+		if err == nil {
+			_, err = io.Copy(enc, body)
+		}
+		if cerr := enc.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// decodedBody decompresses a response body. The dcl Reader is created on the
+// first Read, so that empty bodies are not decoded.
+type decodedBody struct {
+	body     io.ReadCloser
+	encoding string
+	options  []dcl.Option
+	z        *dcl.Reader
+	r        io.Reader
+	err      error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		b.z = dcl.NewReader(b.body)
+		if b.err = b.z.Apply(b.options...); b.err == nil {
+			b.r = b.z
+			if b.encoding == "deflate" {
+				b.r, b.err = newZlibReader(b.body, b.z)
+			}
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.r.Read(p)
+}
+
+func (b *decodedBody) Close() error {
+	if b.z != nil {
+		b.z.Close()
+	}
+	return b.body.Close()
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
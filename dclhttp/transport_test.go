@@ -0,0 +1,182 @@
+package dclhttp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dcl"
+	"dcl/dcltest"
+)
+
+func newTestClient(t *testing.T, tr *Transport) *http.Client {
+	tr.Options = append(tr.Options, dcl.ManagerOption(dcltest.NewManager(t)))
+	return &http.Client{Transport: tr}
+}
+
+func TestTransportResponses(t *testing.T) {
+	var accepted string
+	next := func(w http.ResponseWriter, r *http.Request) {
+		accepted = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, testBody)
+	}
+	m := dcltest.NewManager(t)
+	for _, encoding := range []string{"zstd", "gzip", "deflate", "lz4"} {
+		h, err := NewHandler(http.HandlerFunc(next), Config{
+			Encodings: []string{encoding},
+			Options:   []dcl.Option{dcl.ManagerOption(m)},
+		})
+		if err != nil {
+			t.Fatalf("TestInit: could not create handler: '%v'", err)
+		}
+		srv := httptest.NewServer(h)
+		client := newTestClient(t, &Transport{Encodings: []string{"zstd", "gzip", "deflate", "lz4"}})
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("TestFail: %s request failed with '%v'", encoding, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		srv.Close()
+		if err != nil || string(body) != testBody {
+			t.Errorf("TestFail: %s body read as %d bytes, '%v'", encoding, len(body), err)
+		}
+		if !resp.Uncompressed || resp.Header.Get("Content-Encoding") != "" || resp.ContentLength != -1 {
+			t.Errorf("TestFail: %s response not marked as decoded: %v", encoding, resp.Header)
+		}
+		if accepted != "zstd, gzip, deflate, lz4" {
+			t.Errorf("TestFail: advertised Accept-Encoding %q", accepted)
+		}
+	}
+}
+
+func TestTransportDeflate(t *testing.T) {
+	zb, fb := new(bytes.Buffer), new(bytes.Buffer)
+	zw := zlib.NewWriter(zb)
+	zw.Write([]byte(testBody))
+	zw.Close()
+	fw, _ := flate.NewWriter(fb, 5)
+	fw.Write([]byte(testBody))
+	fw.Close()
+	corrupt := append([]byte{}, zb.Bytes()...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	for name, tc := range map[string]struct {
+		body []byte
+		ok   bool
+	}{
+		"zlib":     {zb.Bytes(), true},
+		"raw":      {fb.Bytes(), true},
+		"checksum": {corrupt, false},
+		"empty":    {nil, true},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "deflate")
+			w.Write(tc.body)
+		}))
+		resp, err := newTestClient(t, &Transport{}).Get(srv.URL)
+		if err != nil {
+			t.Fatalf("TestFail: %s request failed with '%v'", name, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		srv.Close()
+		want := testBody
+		if tc.body == nil {
+			want = ""
+		}
+		if tc.ok && (err != nil || string(body) != want) {
+			t.Errorf("TestFail: %s body read as %d bytes, '%v'", name, len(body), err)
+		}
+		if !tc.ok && err != zlib.ErrChecksum {
+			t.Errorf("TestFail: %s body returned '%v'", name, err)
+		}
+	}
+}
+
+func TestTransportPassThrough(t *testing.T) {
+	gz := new(bytes.Buffer)
+	w := gzip.NewWriter(gz)
+	w.Write([]byte(testBody))
+	w.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gz.Bytes())
+	}))
+	defer srv.Close()
+
+	// A caller that asks for an encoding reads it itself.
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := newTestClient(t, &Transport{}).Do(req)
+	if err != nil {
+		t.Fatalf("TestFail: request failed with '%v'", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(body, gz.Bytes()) || resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("TestFail: caller encoding decoded, %d bytes with headers %v", len(body), resp.Header)
+	}
+
+	if _, err := newTestClient(t, &Transport{Encodings: []string{"br"}}).Get(srv.URL); err == nil {
+		t.Errorf("TestFail: unknown encoding accepted")
+	}
+}
+
+func TestTransportRequests(t *testing.T) {
+	type received struct {
+		encoding string
+		body     string
+	}
+	got := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+		var body io.Reader = r.Body
+		if e := r.Header.Get("Content-Encoding"); e == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("TestFail: request body rejected with '%v'", err)
+				return
+			}
+			body = zr
+		}
+		b, _ := io.ReadAll(body)
+		got <- received{r.Header.Get("Content-Encoding"), string(b)}
+	}))
+	defer srv.Close()
+	client := newTestClient(t, &Transport{MinRequestSize: 1024})
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		body     io.Reader
+		expected string
+		encoding string
+	}{
+		{"large", "/", strings.NewReader(testBody), testBody, "gzip"},
+		{"small", "/", strings.NewReader(testBody[:100]), testBody[:100], ""},
+		{"unknown length", "/", io.MultiReader(strings.NewReader(testBody)), testBody, "gzip"},
+		{"small unknown length", "/", io.MultiReader(strings.NewReader(testBody[:100])), testBody[:100], ""},
+		{"redirect", "/redirect", strings.NewReader(testBody), testBody, "gzip"},
+	} {
+		resp, err := client.Post(srv.URL+tc.path, "text/plain", tc.body)
+		if err != nil {
+			t.Fatalf("TestFail: %s request failed with '%v'", tc.name, err)
+		}
+		resp.Body.Close()
+		r := <-got
+		if r.encoding != tc.encoding || r.body != tc.expected {
+			t.Errorf("TestFail: %s body received as %d bytes of %q", tc.name, len(r.body), r.encoding)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package dclhttp
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"hash"
+	"hash/adler32"
+	"io"
+
+	"dcl"
+)
+
+// The HTTP "deflate" coding is the zlib format (RFC 1950): DEFLATE data
+// between a two byte header and an Adler-32 trailer. dcl handles raw DEFLATE,
+// so the framing is done here.
+
+// zlibHeader is a header for a 32K window and the default level.
+var zlibHeader = []byte{0x78, 0x9c}
+
+// zlibWriter frames the raw DEFLATE stream of a dcl Writer as zlib. The
+// header goes out with the first DEFLATE bytes, so that w is left untouched
+// when the strategy fails before any output.
+type zlibWriter struct {
+	w           io.Writer
+	z           *dcl.Writer
+	digest      hash.Hash32
+	wroteHeader bool
+}
+
+// newZlibWriter returns a zlibWriter writing to w, and the writer its dcl
+// Writer compresses into.
+func newZlibWriter(w io.Writer) (*zlibWriter, io.Writer) {
+	zw := &zlibWriter{w: w, digest: adler32.New()}
+	return zw, (*zlibBody)(zw)
+}
+
+func (zw *zlibWriter) writeHeader() error {
+	if zw.wroteHeader {
+		return nil
+	}
+	zw.wroteHeader = true
+	_, err := zw.w.Write(zlibHeader)
+	return err
+}
+
+func (zw *zlibWriter) Write(p []byte) (int, error) {
+	zw.digest.Write(p)
+	return zw.z.Write(p)
+}
+
+func (zw *zlibWriter) Flush() error {
+	return zw.z.Flush()
+}
+
+func (zw *zlibWriter) Close() error {
+	if err := zw.z.Close(); err != nil {
+		return err
+	}
+	if err := zw.writeHeader(); err != nil {
+		return err
+	}
+	var trailer [4]byte
+	binary.BigEndian.PutUint32(trailer[:], zw.digest.Sum32())
+	_, err := zw.w.Write(trailer[:])
+	return err
+}
+
+// zlibBody is the destination of the DEFLATE stream of a zlibWriter.
+type zlibBody zlibWriter
+
+func (b *zlibBody) Write(p []byte) (int, error) {
+	zw := (*zlibWriter)(b)
+	if err := zw.writeHeader(); err != nil {
+		return 0, err
+	}
+	return zw.w.Write(p)
+}
+
+// software is the policy of the DEFLATE data inside a zlib stream. The Go
+// decoder stops at the end of that data, while accelerators read their input
+// in blocks and would consume the trailer.
+func software(*dcl.PolicyParameters) []dcl.StrategyType {
+	return []dcl.StrategyType{dcl.DEFAULT}
+}
+
+// zlibReader checks the zlib framing around the raw DEFLATE stream of a dcl
+// Reader, which is decoded in software. Some servers send raw DEFLATE as
+// "deflate"; without a valid zlib header the body is read as raw DEFLATE by
+// any strategy.
+type zlibReader struct {
+	r      *bufio.Reader
+	z      *dcl.Reader
+	digest hash.Hash32
+	err    error
+}
+
+// newZlibReader reads the header from r and returns the decompressed stream.
+// The dcl Reader z is reset to read the DEFLATE data.
+func newZlibReader(r io.Reader, z *dcl.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == io.EOF && len(header) == 0 {
+		return eofReader{}, nil
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(header) < 2 || header[0]&0x0f != 8 || header[0]>>4 > 7 || (uint(header[0])<<8|uint(header[1]))%31 != 0 {
+		z.Reset(br)
+		return z, nil
+	}
+	if header[1]&0x20 != 0 {
+		return nil, zlib.ErrDictionary
+	}
+	br.Discard(2)
+	z.Reset(br)
+	z.Apply(dcl.PolicyOption(software))
+	return &zlibReader{r: br, z: z, digest: adler32.New()}, nil
+}
+
+func (zr *zlibReader) Read(p []byte) (int, error) {
+	if zr.err != nil {
+		return 0, zr.err
+	}
+	n, err := zr.z.Read(p)
+	zr.digest.Write(p[:n])
+	if err != io.EOF {
+		zr.err = err
+		return n, err
+	}
+	var trailer [4]byte
+	if _, err := io.ReadFull(zr.r, trailer[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		zr.err = err
+		return n, err
+	}
+	if binary.BigEndian.Uint32(trailer[:]) != zr.digest.Sum32() {
+		zr.err = zlib.ErrChecksum
+		return n, zr.err
+	}
+	zr.err = io.EOF
+	return n, io.EOF
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
@@ -0,0 +1,507 @@
+// Package dclnet compresses the traffic of a net.Conn with dcl. Client and
+// Server wrap the two ends of a connection; a handshake agrees on the
+// algorithm, and each direction is then one long-lived stream.
+//
+// Every flush of the stream is sent as a frame that also carries the size of
+// its data, so a Read only asks the decompressor for data that has arrived.
+// A read deadline that expires is then returned by the connection itself and
+// the stream stays usable.
+package dclnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"dcl"
+)
+
+var (
+	ErrHandshake         = errors.New("dclnet handshake invalid")
+	ErrNoCommonAlgorithm = errors.New("no algorithm supported by both ends")
+	ErrFrame             = errors.New("dclnet frame invalid")
+)
+
+var (
+	// DEFAULT_ALGORITHMS are offered when Config.Algorithms is empty, in order
+	// of preference.
+	DEFAULT_ALGORITHMS = []dcl.Algorithm{dcl.ZSTD, dcl.LZ4, dcl.DEFLATE, dcl.GZIP}
+)
+
+const (
+	// FRAME_CHUNK is the most uncompressed data written between two frames.
+	// Longer writes are split, so a frame is bounded however little is flushed.
+	FRAME_CHUNK = 256 << 10
+	// MAX_FRAME_SIZE is the largest frame accepted from the peer.
+	MAX_FRAME_SIZE = 4 << 20
+
+	handshakeVersion = 1
+	noAlgorithm      = 0xff
+)
+
+var handshakeMagic = [3]byte{'D', 'C', 'L'}
+
+// Config describes the compression of a connection. The zero value offers
+// DEFAULT_ALGORITHMS at dcl.DEFAULT_LEVEL and flushes every Write.
+type Config struct {
+	// Algorithms offered, in order of preference. The client's order wins.
+	Algorithms []dcl.Algorithm
+	// Compression level, dcl.DEFAULT_LEVEL if zero.
+	Level int
+	// ManualFlush sends data only on Flush, or once FRAME_CHUNK bytes are
+	// written, so that several writes make up one message. Otherwise every
+	// Write is flushed before it returns.
+	ManualFlush bool
+	// Policy of the Reader and Writer, the policy of the Manager if nil.
+	Policy dcl.PolicyFunc
+	// Options applied to the dcl Reader and Writer, such as dcl.ManagerOption.
+	Options []dcl.Option
+}
+
+// Conn is a net.Conn whose data is compressed. Deadlines and addresses are
+// those of the underlying connection. Read and Write may be called
+// concurrently, as on any net.Conn.
+type Conn struct {
+	net.Conn
+	cfg    Config
+	client bool
+
+	hsMu   sync.Mutex
+	hsDone bool
+	hsErr  error
+	alg    dcl.Algorithm
+
+	wMu     sync.Mutex
+	z       *dcl.Writer
+	pending bytes.Buffer // compressed data of the frame being written
+	plain   int          // uncompressed bytes of the frame being written
+	wErr    error
+	closed  bool
+
+	rMu   sync.Mutex
+	r     *dcl.Reader
+	in    input  // compressed data of the received frames
+	raw   []byte // received bytes not yet parsed into a frame
+	avail int    // uncompressed bytes the received frames hold
+	eof   bool   // the peer ended its stream
+	rErr  error
+}
+
+// Client returns the compressed form of conn for the end that dialed it. The
+// client offers its algorithms in the handshake.
+func Client(conn net.Conn, cfg Config) *Conn {
+	return newConn(conn, cfg, true)
+}
+
+// Server returns the compressed form of conn for the end that accepted it.
+// The server picks the first algorithm the client offers that it supports.
+func Server(conn net.Conn, cfg Config) *Conn {
+	return newConn(conn, cfg, false)
+}
+
+func newConn(conn net.Conn, cfg Config, client bool) *Conn {
+	c := &Conn{Conn: conn, cfg: cfg, client: client}
+	c.in.c = c
+	return c
+}
+
+func (c *Conn) algorithms() []dcl.Algorithm {
+	if len(c.cfg.Algorithms) != 0 {
+		return c.cfg.Algorithms
+	}
+	return DEFAULT_ALGORITHMS
+}
+
+// Handshake agrees on the algorithm with the peer. It runs on the first Read
+// or Write if not called before, and its error is returned by every later
+// call.
+func (c *Conn) Handshake() error {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	if c.hsDone {
+		return c.hsErr
+	}
+	c.hsDone = true
+	c.hsErr = c.handshake()
+	return c.hsErr
+}
+
+// Algorithm returns the algorithm agreed in the handshake.
+func (c *Conn) Algorithm() (dcl.Algorithm, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.alg, nil
+}
+
+// handshake sends the offer of the client and the choice of the server, each
+// after the magic and version:
+//
+//	client: "DCL" version count algorithm...
+//	server: "DCL" version algorithm
+func (c *Conn) handshake() error {
+	offered := c.algorithms()
+	if len(offered) >= noAlgorithm {
+		return dcl.ErrParamAlgorithm
+	}
+	for _, a := range offered {
+		if err := dcl.NewWriter(io.Discard).Apply(dcl.AlgorithmOption(a)); err != nil {
+			return err
+		}
+	}
+
+	var header [5]byte
+	copy(header[:], handshakeMagic[:])
+	header[3] = handshakeVersion
+	if c.client {
+		msg := append(header[:4:4], byte(len(offered)))
+		for _, a := range offered {
+			msg = append(msg, byte(a))
+		}
+		if _, err := c.Conn.Write(msg); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			return err
+		}
+		if err := checkHeader(header[:]); err != nil {
+			return err
+		}
+		if header[4] == noAlgorithm {
+			return ErrNoCommonAlgorithm
+		}
+		c.alg = dcl.Algorithm(header[4])
+		if !contains(offered, c.alg) {
+			return ErrHandshake
+		}
+	} else {
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			return err
+		}
+		if err := checkHeader(header[:]); err != nil {
+			return err
+		}
+		peer := make([]byte, header[4])
+		if _, err := io.ReadFull(c.Conn, peer); err != nil {
+			return err
+		}
+		choice := byte(noAlgorithm)
+		for _, a := range peer {
+			if contains(offered, dcl.Algorithm(a)) {
+				choice = a
+				break
+			}
+		}
+		header[4] = choice
+		if _, err := c.Conn.Write(header[:]); err != nil {
+			return err
+		}
+		if choice == noAlgorithm {
+			return ErrNoCommonAlgorithm
+		}
+		c.alg = dcl.Algorithm(choice)
+	}
+
+	level := c.cfg.Level
+	if level == 0 {
+		level = dcl.DEFAULT_LEVEL
+	}
+	options := []dcl.Option{dcl.AlgorithmOption(c.alg)}
+	if c.cfg.Policy != nil {
+		options = append(options, dcl.PolicyOption(c.cfg.Policy))
+	}
+	options = append(options, c.cfg.Options...)
+
+	z := dcl.NewWriter(&c.pending)
+	if err := z.Apply(append(options[:len(options):len(options)],
+		dcl.CompressionLevelOption(level), dcl.StreamOption(true), dcl.FlushOption(true))...); err != nil {
+		return err
+	}
+	r := dcl.NewReader(&c.in)
+	if err := r.Apply(options...); err != nil {
+		return err
+	}
+	// Close reads them without waiting for the handshake.
+	c.wMu.Lock()
+	c.z = z
+	c.wMu.Unlock()
+	c.rMu.Lock()
+	c.r = r
+	c.rMu.Unlock()
+	return nil
+}
+
+func checkHeader(header []byte) error {
+	if !bytes.Equal(header[:3], handshakeMagic[:]) || header[3] != handshakeVersion {
+		return ErrHandshake
+	}
+	return nil
+}
+
+// Write compresses p. Unless Config.ManualFlush is set, the data is sent to
+// the peer before Write returns.
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	if c.wErr != nil {
+		return 0, c.wErr
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if room := FRAME_CHUNK - c.plain; len(chunk) > room {
+			chunk = chunk[:room]
+		}
+		n, err := c.z.Write(chunk)
+		c.plain += n
+		written += n
+		if err != nil {
+			c.wErr = err
+			return written, err
+		}
+		if c.plain >= FRAME_CHUNK {
+			if err := c.flush(); err != nil {
+				return written, err
+			}
+		}
+		p = p[n:]
+	}
+	if !c.cfg.ManualFlush {
+		if err := c.flush(); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Flush sends the data written so far to the peer as one message.
+func (c *Conn) Flush() error {
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if c.wErr != nil {
+		return c.wErr
+	}
+	return c.flush()
+}
+
+// flush makes the data written since the last frame decodable and sends it.
+func (c *Conn) flush() error {
+	if c.plain == 0 {
+		return nil
+	}
+	if err := c.z.Flush(); err != nil {
+		c.wErr = err
+		return err
+	}
+	return c.writeFrame()
+}
+
+// writeFrame sends the pending compressed data after its uncompressed and
+// compressed sizes. A size of zero ends the stream. A frame that is only
+// partly sent breaks the stream, so any error is kept.
+func (c *Conn) writeFrame() error {
+	frame := make([]byte, 0, 2*binary.MaxVarintLen64+c.pending.Len())
+	frame = binary.AppendUvarint(frame, uint64(c.plain))
+	frame = binary.AppendUvarint(frame, uint64(c.pending.Len()))
+	frame = append(frame, c.pending.Bytes()...)
+	c.pending.Reset()
+	c.plain = 0
+	if _, err := c.Conn.Write(frame); err != nil {
+		c.wErr = err
+		return err
+	}
+	return nil
+}
+
+// Read decompresses data from the peer. It only waits on the connection, so
+// a read deadline that expires leaves the stream intact, and Read can be
+// called again.
+func (c *Conn) Read(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.rMu.Lock()
+	defer c.rMu.Unlock()
+	if c.rErr != nil {
+		return 0, c.rErr
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for c.avail == 0 && !c.eof {
+		if err := c.readFrame(); err != nil {
+			if !isTimeout(err) {
+				c.rErr = err
+			}
+			return 0, err
+		}
+	}
+	if c.avail == 0 {
+		// Read the end of the stream, which verifies its checksum.
+		var b [1]byte
+		n, err := c.r.Read(b[:])
+		if n != 0 || err == nil {
+			err = ErrFrame
+		}
+		c.rErr = err
+		return 0, err
+	}
+
+	if len(p) > c.avail {
+		p = p[:c.avail]
+	}
+	n, err := c.r.Read(p)
+	c.avail -= n
+	if err == io.EOF {
+		// The stream ended before the data its frames announced.
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.rErr = err
+	}
+	return n, err
+}
+
+// readFrame adds the next frame from the connection to the input of the
+// Reader. Bytes of an incomplete frame are kept for the next call.
+func (c *Conn) readFrame() error {
+	for {
+		plain, n1 := binary.Uvarint(c.raw)
+		size, n2 := binary.Uvarint(c.raw[max(n1, 0):])
+		if n1 < 0 || n2 < 0 || plain > FRAME_CHUNK || size > MAX_FRAME_SIZE {
+			return ErrFrame
+		}
+		if n1 > 0 && n2 > 0 && len(c.raw) >= n1+n2+int(size) {
+			c.in.buf.Write(c.raw[n1+n2 : n1+n2+int(size)])
+			c.raw = c.raw[n1+n2+int(size):]
+			if plain == 0 {
+				c.eof = true
+			} else {
+				c.avail += int(plain)
+			}
+			return nil
+		}
+
+		var buf [32 << 10]byte
+		n, err := c.Conn.Read(buf[:])
+		c.raw = append(c.raw, buf[:n]...)
+		if err == io.EOF && len(c.raw) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close ends the stream written to the peer and closes the connection. A
+// Write in progress is ended by closing the connection first, and the stream
+// is then left without its end.
+func (c *Conn) Close() error {
+	var err error
+	idle := c.wMu.TryLock()
+	if !idle {
+		err = c.Conn.Close()
+		c.wMu.Lock()
+	}
+	if c.closed {
+		c.wMu.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	if c.z != nil {
+		if idle && c.wErr == nil {
+			err = c.flush()
+		}
+		// The compress job is released even if the stream cannot be ended.
+		if zerr := c.z.Close(); idle && err == nil && c.wErr == nil {
+			if err = zerr; err == nil {
+				err = c.writeFrame()
+			}
+		}
+	}
+	c.wMu.Unlock()
+
+	if idle {
+		if cerr := c.Conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	// Closing the connection ends a blocked Read, after which the
+	// decompress job can be released.
+	c.rMu.Lock()
+	if c.r != nil {
+		c.r.Close()
+	}
+	c.rMu.Unlock()
+	return err
+}
+
+// input is the compressed data the Reader decodes. Read only asks for data
+// that has arrived, so reading from the connection here is a fallback.
+type input struct {
+	c   *Conn
+	buf bytes.Buffer
+}
+
+func (in *input) fill() error {
+	for in.buf.Len() == 0 {
+		if in.c.eof {
+			return io.EOF
+		}
+		if err := in.c.readFrame(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *input) Read(p []byte) (int, error) {
+	if err := in.fill(); err != nil {
+		return 0, err
+	}
+	return in.buf.Read(p)
+}
+
+func (in *input) ReadByte() (byte, error) {
+	if err := in.fill(); err != nil {
+		return 0, err
+	}
+	return in.buf.ReadByte()
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func contains(list []dcl.Algorithm, a dcl.Algorithm) bool {
+	for _, v := range list {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,243 @@
+package dclnet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"dcl"
+	"dcl/dcltest"
+)
+
+var testMessage = strings.Repeat("Hello World\n", 100)
+
+// pair returns the two ends of a loopback TCP connection, before the
+// handshake.
+func pair(t *testing.T, client, server Config) (*Conn, *Conn) {
+	return pairWith(t, dcltest.NewManager(t), client, server)
+}
+
+// pairWith is pair with both ends submitting their jobs to m.
+func pairWith(t *testing.T, m *dcl.Manager, client, server Config) (*Conn, *Conn) {
+	client.Options = append(client.Options, dcl.ManagerOption(m))
+	server.Options = append(server.Options, dcl.ManagerOption(m))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestInit: could not listen: '%v'", err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("TestInit: could not dial: '%v'", err)
+	}
+	sconn := <-accepted
+	if sconn == nil {
+		t.Fatalf("TestInit: could not accept")
+	}
+	c, s := Client(conn, client), Server(sconn, server)
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	return c, s
+}
+
+func readMessage(t *testing.T, c *Conn, size int) string {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatalf("TestFail: message read failed with '%v'", err)
+	}
+	return string(buf)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, alg := range DEFAULT_ALGORITHMS {
+		c, s := pair(t, Config{Algorithms: []dcl.Algorithm{alg}}, Config{})
+		go s.Handshake()
+		// Each message is readable before the next is written.
+		for i := 0; i < 3; i++ {
+			if _, err := c.Write([]byte(testMessage)); err != nil {
+				t.Fatalf("TestFail: %v write failed with '%v'", alg, err)
+			}
+			if got := readMessage(t, s, len(testMessage)); got != testMessage {
+				t.Errorf("TestFail: %v message %d read back as %q", alg, i, got[:20])
+			}
+			if _, err := s.Write([]byte(testMessage[:i+1])); err != nil {
+				t.Fatalf("TestFail: %v reply failed with '%v'", alg, err)
+			}
+			if got := readMessage(t, c, i+1); got != testMessage[:i+1] {
+				t.Errorf("TestFail: %v reply %d read back as %q", alg, i, got)
+			}
+		}
+		if a, _ := s.Algorithm(); a != alg {
+			t.Errorf("TestFail: server agreed on %v, expected %v", a, alg)
+		}
+		if err := c.Close(); err != nil {
+			t.Errorf("TestFail: %v close failed with '%v'", alg, err)
+		}
+		if n, err := s.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+			t.Errorf("TestFail: %v end of stream read as %d bytes, '%v'", alg, n, err)
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	c, s := pair(t,
+		Config{Algorithms: []dcl.Algorithm{dcl.LZ4, dcl.GZIP}},
+		Config{Algorithms: []dcl.Algorithm{dcl.GZIP, dcl.LZ4}})
+	go s.Handshake()
+	if a, err := c.Algorithm(); err != nil || a != dcl.LZ4 {
+		t.Errorf("TestFail: client agreed on %v, '%v'", a, err)
+	}
+
+	c, s = pair(t,
+		Config{Algorithms: []dcl.Algorithm{dcl.LZ4}},
+		Config{Algorithms: []dcl.Algorithm{dcl.GZIP}})
+	errs := make(chan error, 1)
+	go func() { errs <- s.Handshake() }()
+	if err := c.Handshake(); err != ErrNoCommonAlgorithm {
+		t.Errorf("TestFail: client handshake returned '%v'", err)
+	}
+	if err := <-errs; err != ErrNoCommonAlgorithm {
+		t.Errorf("TestFail: server handshake returned '%v'", err)
+	}
+	if _, err := c.Write([]byte(testMessage)); err != ErrNoCommonAlgorithm {
+		t.Errorf("TestFail: write after failed handshake returned '%v'", err)
+	}
+
+	// A peer that does not speak the protocol is rejected.
+	c, s = pair(t, Config{}, Config{})
+	go c.Conn.Write([]byte("GET / HTTP/1.1\r\n"))
+	if err := s.Handshake(); err != ErrHandshake {
+		t.Errorf("TestFail: invalid handshake returned '%v'", err)
+	}
+
+	c, _ = pair(t, Config{Algorithms: []dcl.Algorithm{dcl.Algorithm(42)}}, Config{})
+	if err := c.Handshake(); err != dcl.ErrParamAlgorithm {
+		t.Errorf("TestFail: invalid algorithm returned '%v'", err)
+	}
+}
+
+func TestManualFlush(t *testing.T) {
+	c, s := pair(t, Config{ManualFlush: true}, Config{})
+	go s.Handshake()
+	for _, part := range strings.SplitAfter(testMessage, "\n") {
+		c.Write([]byte(part))
+	}
+	s.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := s.Read(make([]byte, 10)); n != 0 || !isTimeout(err) {
+		t.Errorf("TestFail: unflushed data read as %d bytes, '%v'", n, err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatalf("TestFail: flush failed with '%v'", err)
+	}
+	s.SetReadDeadline(time.Time{})
+	if got := readMessage(t, s, len(testMessage)); got != testMessage {
+		t.Errorf("TestFail: flushed message read back as %q", got[:20])
+	}
+
+	// Writes longer than a frame are sent without waiting for Flush.
+	large := bytes.Repeat([]byte(testMessage), 3*FRAME_CHUNK/len(testMessage))
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Write(large)
+		done <- err
+	}()
+	if got := readMessage(t, s, 2*FRAME_CHUNK); got != string(large[:2*FRAME_CHUNK]) {
+		t.Errorf("TestFail: large write read back as %d bytes", len(got))
+	}
+	if err := <-done; err != nil {
+		t.Errorf("TestFail: large write failed with '%v'", err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	for _, alg := range DEFAULT_ALGORITHMS {
+		c, s := pair(t, Config{Algorithms: []dcl.Algorithm{alg}}, Config{})
+		go s.Handshake()
+		c.Write([]byte(testMessage))
+		if got := readMessage(t, s, len(testMessage)); got != testMessage {
+			t.Errorf("TestFail: %v message read back as %q", alg, got[:20])
+		}
+
+		// An idle connection times out, and the stream carries on after it.
+		s.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		_, err := s.Read(make([]byte, 10))
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			t.Errorf("TestFail: %v idle read returned '%v'", alg, err)
+		}
+		s.SetReadDeadline(time.Time{})
+		c.Write([]byte(testMessage))
+		if got := readMessage(t, s, len(testMessage)); got != testMessage {
+			t.Errorf("TestFail: %v message after timeout read back as %q", alg, got[:20])
+		}
+	}
+}
+
+func TestCloseAfterWriteError(t *testing.T) {
+	m := dcltest.NewManager(t)
+	c, s := pairWith(t, m, Config{}, Config{})
+	go s.Handshake()
+	if _, err := c.Write([]byte(testMessage)); err != nil {
+		t.Fatalf("TestFail: write failed with '%v'", err)
+	}
+	c.SetWriteDeadline(time.Now())
+	if _, err := c.Write([]byte(testMessage)); !isTimeout(err) {
+		t.Fatalf("TestFail: write past the deadline returned '%v'", err)
+	}
+	if l := m.Load(dcl.QAT); l.InFlight != 1 {
+		t.Fatalf("TestInit: expected the stream on QAT, load %+v", l)
+	}
+	c.Close()
+	if l := m.Load(dcl.QAT); l.InFlight != 0 {
+		t.Errorf("TestFail: close kept %d jobs after a write error", l.InFlight)
+	}
+}
+
+func TestCloseDuringWrite(t *testing.T) {
+	m := dcltest.NewManager(t)
+	cconn, sconn := net.Pipe()
+	c := Client(cconn, Config{Options: []dcl.Option{dcl.ManagerOption(m)}})
+	s := Server(sconn, Config{Options: []dcl.Option{dcl.ManagerOption(m)}})
+	defer s.Close()
+	go s.Handshake()
+	if err := c.Handshake(); err != nil {
+		t.Fatalf("TestInit: handshake failed with '%v'", err)
+	}
+
+	// Nothing reads the pipe, so the write blocks on the connection.
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.Write([]byte(testMessage))
+		written <- err
+	}()
+	for m.Load(dcl.QAT).InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		cconn.Close()
+		t.Fatalf("TestFail: close blocked behind a write")
+	}
+	if err := <-written; err == nil {
+		t.Errorf("TestFail: write succeeded on a closed connection")
+	}
+	if l := m.Load(dcl.QAT); l.InFlight != 0 {
+		t.Errorf("TestFail: close kept %d jobs during a write", l.InFlight)
+	}
+}
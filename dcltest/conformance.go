@@ -0,0 +1,423 @@
+// Package dcltest checks that a dcl.Handler follows the contract the Manager
+// relies on. Run it from the tests of any custom or simulated handler:
+//
+//	func TestMyHandler(t *testing.T) {
+//		dcltest.TestHandler(t, dcltest.Config{
+//			New:        func() dcl.Handler { return NewMyHandler() },
+//			Algorithms: []dcl.Algorithm{dcl.GZIP},
+//		})
+//	}
+package dcltest
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dcl"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Config describes the handler under test.
+type Config struct {
+	// New returns a fresh handler. It is called once per subtest.
+	New func() dcl.Handler
+	// Algorithms the handler supports; every other algorithm must be
+	// reported as unsupported.
+	Algorithms []dcl.Algorithm
+	// Compression levels to round trip, {1} if empty.
+	Levels []int
+	// SkipUnavailable skips the suite when the handler reports that it is not
+	// installed or cannot compress a small buffer, for hardware handlers run
+	// on machines without the device.
+	SkipUnavailable bool
+}
+
+var nextID int64 = 1 << 40
+
+// newJob returns a job with an ID no Manager hands out.
+func newJob(dir dcl.Direction, alg dcl.Algorithm, level int, w io.Writer, r io.Reader) *dcl.Job {
+	id := dcl.JobID(atomic.AddInt64(&nextID, 1))
+	return dcl.NewJob(id, dcl.NewJobParams(dir, alg, level), w, r)
+}
+
+// TestData returns n bytes that mix text with random runs, so that every
+// codec produces several blocks.
+func TestData(n int) []byte {
+	rng := rand.New(rand.NewSource(int64(n)))
+	b := new(bytes.Buffer)
+	for b.Len() < n {
+		if rng.Intn(4) == 0 {
+			run := make([]byte, 64+rng.Intn(512))
+			rng.Read(run)
+			b.Write(run)
+		} else {
+			fmt.Fprintf(b, "line %d of the dcl conformance suite, value %x\n", b.Len(), rng.Int63())
+		}
+	}
+	return b.Bytes()[:n]
+}
+
+// Compress compresses p as one job of h, split into requests of at most
+// chunk bytes, and releases the job. Empty input is sent as one empty request.
+func Compress(h dcl.Handler, alg dcl.Algorithm, level int, p []byte, chunk int) ([]byte, error) {
+	out := new(bytes.Buffer)
+	job := newJob(dcl.COMPRESS, alg, level, out, nil)
+	for first := true; first || len(p) > 0; first = false {
+		c := p
+		if chunk > 0 && len(c) > chunk {
+			c = c[:chunk]
+		}
+		job.SetBuffer(c)
+		n, err := h.Request(job)
+		if err != nil {
+			h.Release(job.ID())
+			return nil, err
+		}
+		if n != len(c) {
+			h.Release(job.ID())
+			return nil, fmt.Errorf("compress request consumed %d of %d bytes", n, len(c))
+		}
+		p = p[len(c):]
+	}
+	if err := h.Release(job.ID()); err != nil {
+		return nil, fmt.Errorf("release: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// Decompress decompresses src as one job of h, reading into buffers of
+// bufSize bytes until io.EOF, and releases the job.
+func Decompress(h dcl.Handler, alg dcl.Algorithm, src []byte, bufSize int) ([]byte, error) {
+	job := newJob(dcl.DECOMPRESS, alg, 0, nil, bytes.NewReader(src))
+	out := new(bytes.Buffer)
+	buf := make([]byte, bufSize)
+	for calls := 0; ; calls++ {
+		job.SetBuffer(buf)
+		n, err := h.Request(job)
+		if n < 0 || n > len(buf) {
+			h.Release(job.ID())
+			return nil, fmt.Errorf("decompress request returned n=%d for a %d byte buffer", n, len(buf))
+		}
+		out.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.Release(job.ID())
+			return nil, err
+		}
+		if n == 0 && calls > 1000 {
+			h.Release(job.ID())
+			return nil, errors.New("decompress made no progress")
+		}
+	}
+	if err := h.Release(job.ID()); err != nil {
+		return nil, fmt.Errorf("release: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// referenceDecode decodes src with the Go codecs, to check that the output of
+// a handler is in the standard format.
+func referenceDecode(alg dcl.Algorithm, src []byte) ([]byte, error) {
+	var r io.Reader
+	switch alg {
+	case dcl.DEFLATE:
+		r = flate.NewReader(bytes.NewReader(src))
+	case dcl.GZIP:
+		gr, err := gzip.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		r = gr
+	case dcl.LZ4:
+		r = lz4.NewReader(bytes.NewReader(src))
+	case dcl.ZSTD:
+		zr, err := zstd.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("no reference decoder for %v", alg)
+	}
+	return io.ReadAll(r)
+}
+
+// TestHandler runs the conformance suite against the handler built by
+// cfg.New. It checks round trips at every level, streams spanning many
+// requests, concurrent jobs, release semantics, capacity and the error
+// sentinels.
+func TestHandler(t *testing.T, cfg Config) {
+	t.Helper()
+	if len(cfg.Levels) == 0 {
+		cfg.Levels = []int{1}
+	}
+	if cfg.SkipUnavailable {
+		h := cfg.New()
+		if cr, ok := h.(dcl.CapabilityReporter); ok && !cr.Installed() {
+			t.Skip("handler not installed")
+		}
+		if len(cfg.Algorithms) > 0 {
+			if _, err := Compress(h, cfg.Algorithms[0], cfg.Levels[0], []byte("probe"), 0); err != nil {
+				t.Skipf("handler not usable: %v", err)
+			}
+		}
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, cfg) })
+	t.Run("Streaming", func(t *testing.T) { testStreaming(t, cfg) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, cfg) })
+	t.Run("Release", func(t *testing.T) { testRelease(t, cfg) })
+	t.Run("Errors", func(t *testing.T) { testErrors(t, cfg) })
+	t.Run("Capacity", func(t *testing.T) { testCapacity(t, cfg) })
+}
+
+func testRoundTrip(t *testing.T, cfg Config) {
+	input := TestData(256 * 1024)
+	for _, alg := range cfg.Algorithms {
+		for _, level := range cfg.Levels {
+			t.Run(fmt.Sprintf("%v/%d", alg, level), func(t *testing.T) {
+				h := cfg.New()
+				compressed, err := Compress(h, alg, level, input, 0)
+				if err != nil {
+					t.Fatalf("compress: %v", err)
+				}
+				if ref, err := referenceDecode(alg, compressed); err != nil || !bytes.Equal(ref, input) {
+					t.Errorf("output does not decode with the reference decoder: %v", err)
+				}
+				out, err := Decompress(h, alg, compressed, 32*1024)
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+				if !bytes.Equal(out, input) {
+					t.Errorf("round trip returned %d bytes, expected %d", len(out), len(input))
+				}
+
+				empty, err := Compress(h, alg, level, nil, 0)
+				if err != nil {
+					t.Fatalf("compress of empty input: %v", err)
+				}
+				if len(empty) > 0 {
+					if out, err := Decompress(h, alg, empty, 1024); err != nil || len(out) != 0 {
+						t.Errorf("empty stream decoded to %d bytes: %v", len(out), err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func testStreaming(t *testing.T, cfg Config) {
+	input := TestData(100 * 1000)
+	for _, alg := range cfg.Algorithms {
+		t.Run(alg.String(), func(t *testing.T) {
+			h := cfg.New()
+			compressed, err := Compress(h, alg, cfg.Levels[0], input, 7*1024+3)
+			if err != nil {
+				t.Fatalf("compress in chunks: %v", err)
+			}
+			for _, size := range []int{1, 509, 64 * 1024} {
+				src := compressed
+				if size == 1 {
+					// Byte at a time reads are slow, keep the stream short.
+					if src, err = Compress(h, alg, cfg.Levels[0], input[:4096], 1000); err != nil {
+						t.Fatalf("compress: %v", err)
+					}
+				}
+				out, err := Decompress(h, alg, src, size)
+				if err != nil {
+					t.Fatalf("decompress with %d byte reads: %v", size, err)
+				}
+				expected := input
+				if size == 1 {
+					expected = input[:4096]
+				}
+				if !bytes.Equal(out, expected) {
+					t.Errorf("decompress with %d byte reads returned %d bytes, expected %d", size, len(out), len(expected))
+				}
+			}
+		})
+	}
+}
+
+func testConcurrent(t *testing.T, cfg Config) {
+	h := cfg.New()
+	var wg sync.WaitGroup
+	errs := make(chan error, 16*len(cfg.Algorithms))
+	for i := 0; i < 16; i++ {
+		for _, alg := range cfg.Algorithms {
+			wg.Add(1)
+			go func(i int, alg dcl.Algorithm) {
+				defer wg.Done()
+				input := TestData(16*1024 + i)
+				var compressed, out []byte
+				err := retry(func() (err error) {
+					compressed, err = Compress(h, alg, cfg.Levels[0], input, 4096)
+					return err
+				})
+				if err == nil {
+					err = retry(func() (err error) {
+						out, err = Decompress(h, alg, compressed, 1024)
+						return err
+					})
+				}
+				if err == nil && !bytes.Equal(out, input) {
+					err = errors.New("output differs from input")
+				}
+				if err != nil {
+					errs <- fmt.Errorf("%v job %d: %w", alg, i, err)
+				}
+			}(i, alg)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// retry repeats f while the handler has no free session.
+func retry(f func() error) error {
+	for i := 0; ; i++ {
+		err := f()
+		if err != dcl.ErrNotAvailable || i == 1000 {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testRelease(t *testing.T, cfg Config) {
+	h := cfg.New()
+	if err := h.Release(dcl.JobID(-1)); err != dcl.ErrJobNotFound {
+		t.Errorf("release of an unknown job returned %v, expected ErrJobNotFound", err)
+	}
+	if len(cfg.Algorithms) == 0 {
+		return
+	}
+	alg := cfg.Algorithms[0]
+
+	out := new(bytes.Buffer)
+	job := newJob(dcl.COMPRESS, alg, cfg.Levels[0], out, nil)
+	job.SetBuffer(TestData(1000))
+	if _, err := h.Request(job); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	if err := h.Release(job.ID()); err != nil {
+		t.Errorf("release returned %v", err)
+	}
+	if err := h.Release(job.ID()); err != dcl.ErrJobNotFound {
+		t.Errorf("second release returned %v, expected ErrJobNotFound", err)
+	}
+	if ref, err := referenceDecode(alg, out.Bytes()); err != nil || len(ref) != 1000 {
+		t.Errorf("stream is incomplete after release: %v", err)
+	}
+
+	// A decompress job released before the end of its stream.
+	compressed, err := Compress(h, alg, cfg.Levels[0], TestData(64*1024), 0)
+	if err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	job = newJob(dcl.DECOMPRESS, alg, 0, nil, bytes.NewReader(compressed))
+	job.SetBuffer(make([]byte, 100))
+	if _, err := h.Request(job); err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if err := h.Release(job.ID()); err != nil {
+		t.Errorf("release of an unfinished stream returned %v", err)
+	}
+
+	// A job whose request failed must still be safe to release.
+	job = newJob(dcl.DECOMPRESS, alg, 0, nil, bytes.NewReader(bytes.Repeat([]byte{0xff}, 1024)))
+	job.SetBuffer(make([]byte, 1024))
+	if _, err := h.Request(job); err == nil {
+		t.Errorf("decompress of corrupt input did not fail")
+	}
+	if err := h.Release(job.ID()); err != nil && err != dcl.ErrJobNotFound {
+		t.Errorf("release of a failed job returned %v", err)
+	}
+}
+
+func testErrors(t *testing.T, cfg Config) {
+	h := cfg.New()
+	supported := map[dcl.Algorithm]bool{}
+	for _, alg := range cfg.Algorithms {
+		supported[alg] = true
+	}
+	cr, reports := h.(dcl.CapabilityReporter)
+	for _, alg := range dcl.DEFAULT_ALGORITHMS {
+		if reports && cr.Supports(alg) != supported[alg] {
+			t.Errorf("Supports(%v) = %v, expected %v", alg, cr.Supports(alg), supported[alg])
+		}
+		if supported[alg] {
+			continue
+		}
+		job := newJob(dcl.COMPRESS, alg, cfg.Levels[0], io.Discard, nil)
+		job.SetBuffer([]byte("data"))
+		if _, err := h.Request(job); err != dcl.ErrUnsupported {
+			t.Errorf("compress with unsupported %v returned %v, expected ErrUnsupported", alg, err)
+		}
+		if err := h.Release(job.ID()); err != dcl.ErrJobNotFound {
+			t.Errorf("release of a job that never started returned %v, expected ErrJobNotFound", err)
+		}
+	}
+}
+
+func testCapacity(t *testing.T, cfg Config) {
+	h := cfg.New()
+	lr, ok := h.(dcl.LoadReporter)
+	if !ok || lr.Load().Capacity == 0 || len(cfg.Algorithms) == 0 {
+		t.Skip("handler has no fixed capacity")
+	}
+	alg := cfg.Algorithms[0]
+	capacity := lr.Load().Capacity
+	var open []*dcl.Job
+	defer func() {
+		for _, job := range open {
+			h.Release(job.ID())
+		}
+	}()
+	for i := 0; i < capacity; i++ {
+		job := newJob(dcl.COMPRESS, alg, cfg.Levels[0], io.Discard, nil)
+		job.SetBuffer([]byte("data"))
+		if _, err := h.Request(job); err != nil {
+			t.Fatalf("job %d of %d: %v", i+1, capacity, err)
+		}
+		open = append(open, job)
+	}
+	if l := lr.Load(); l.InFlight != capacity {
+		t.Errorf("load reports %d jobs in flight, expected %d", l.InFlight, capacity)
+	}
+
+	extra := newJob(dcl.COMPRESS, alg, cfg.Levels[0], io.Discard, nil)
+	extra.SetBuffer([]byte("data"))
+	if _, err := h.Request(extra); err != dcl.ErrNotAvailable {
+		t.Errorf("job over capacity returned %v, expected ErrNotAvailable", err)
+	}
+	// Jobs already holding a session continue when the handler is full.
+	open[0].SetBuffer([]byte("more"))
+	if _, err := h.Request(open[0]); err != nil {
+		t.Errorf("request to a running job at capacity returned %v", err)
+	}
+
+	h.Release(open[0].ID())
+	open = open[1:]
+	if _, err := h.Request(extra); err != nil {
+		t.Errorf("job after a release returned %v", err)
+	}
+	open = append(open, extra)
+}
@@ -0,0 +1,51 @@
+package dcltest
+
+import (
+	"testing"
+
+	"dcl"
+)
+
+func TestDefaultHandler(t *testing.T) {
+	TestHandler(t, Config{
+		New:        func() dcl.Handler { return dcl.NewDefaultHandler() },
+		Algorithms: dcl.DEFAULT_ALGORITHMS,
+		Levels:     []int{1, 6, 9},
+	})
+}
+
+func TestSimulatedHandler(t *testing.T) {
+	for _, s := range []dcl.StrategyType{dcl.QAT, dcl.IAA, dcl.ISAL} {
+		t.Run(s.String(), func(t *testing.T) {
+			cfg := dcl.SimulatedConfigFor(s)
+			TestHandler(t, Config{
+				New:        func() dcl.Handler { return dcl.NewSimulatedHandler(cfg) },
+				Algorithms: cfg.Algorithms,
+			})
+		})
+	}
+}
+
+func TestQATHandler(t *testing.T) {
+	TestHandler(t, Config{
+		New:             func() dcl.Handler { return dcl.NewQATHandler() },
+		Algorithms:      dcl.QAT_ALGORITHMS,
+		SkipUnavailable: true,
+	})
+}
+
+func TestIAAHandler(t *testing.T) {
+	TestHandler(t, Config{
+		New:             func() dcl.Handler { return dcl.NewIAAHandler() },
+		Algorithms:      dcl.IAA_ALGORITHMS,
+		SkipUnavailable: true,
+	})
+}
+
+func TestISALHandler(t *testing.T) {
+	TestHandler(t, Config{
+		New:             func() dcl.Handler { return dcl.NewISALHandler() },
+		Algorithms:      dcl.ISAL_ALGORITHMS,
+		SkipUnavailable: true,
+	})
+}
@@ -0,0 +1,23 @@
+package dcltest
+
+import (
+	"testing"
+
+	"dcl"
+)
+
+// NewManager returns a Manager with simulated QAT, IAA and ISAL handlers and
+// a policy that tries QAT and then the software fallback, after applying
+// options. Tests of packages built on dcl use it to exercise the accelerator
+// paths on machines without one.
+func NewManager(t testing.TB, options ...dcl.Option) *dcl.Manager {
+	t.Helper()
+	m, err := dcl.NewManager(append([]dcl.Option{dcl.SimulatedHandlersOption()}, options...)...)
+	if err != nil {
+		t.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	m.SetPolicy(func(*dcl.PolicyParameters) []dcl.StrategyType {
+		return []dcl.StrategyType{dcl.QAT, dcl.DEFAULT}
+	})
+	return m
+}
@@ -0,0 +1,185 @@
+package dcl
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// JobSnapshot describes a job the Manager is holding. A compress job is only
+// held while a request runs, unless it is a stream, which is held until its
+// Writer is closed. Decompress jobs are held until the end of their stream.
+// Old jobs therefore point at Writers that were never closed or at readers
+// that were never drained or closed.
+type JobSnapshot struct {
+	ID        JobID         `json:"id"`
+	Started   time.Time     `json:"started"`
+	Age       time.Duration `json:"age_ns"`
+	Direction Direction     `json:"direction"`
+	Algorithm Algorithm     `json:"algorithm"`
+	Level     int           `json:"level"`
+	Tag       string        `json:"tag,omitempty"`
+	Strategy  StrategyType  `json:"strategy"`
+	BytesIn   int64         `json:"bytes_in"`
+	BytesOut  int64         `json:"bytes_out"`
+}
+
+// HandlerSnapshot describes the state and configuration of a strategy.
+type HandlerSnapshot struct {
+	Strategy          StrategyType  `json:"strategy"`
+	Installed         bool          `json:"installed"`
+	Algorithms        []Algorithm   `json:"algorithms"`
+	InFlight          int           `json:"in_flight"`
+	Capacity          int           `json:"capacity"`
+	QueueWait         time.Duration `json:"queue_wait_ns"`
+	CircuitOpen       bool          `json:"circuit_open"`
+	ConsecutiveErrors int           `json:"consecutive_errors"`
+	LastError         string        `json:"last_error,omitempty"`
+}
+
+// QuotaSnapshot describes the quota of a tag on a strategy.
+type QuotaSnapshot struct {
+	Tag      string       `json:"tag"`
+	Strategy StrategyType `json:"strategy"`
+	InFlight int          `json:"in_flight"`
+	Limit    int          `json:"limit"`
+}
+
+// ManagerSnapshot is a point-in-time view of the jobs and handlers of a
+// Manager, oldest jobs first.
+type ManagerSnapshot struct {
+	Time     time.Time         `json:"time"`
+	Jobs     []JobSnapshot     `json:"jobs"`
+	Handlers []HandlerSnapshot `json:"handlers"`
+	Quotas   []QuotaSnapshot   `json:"quotas"`
+	Tracing  bool              `json:"tracing"`
+}
+
+// Snapshot returns the jobs the Manager is holding and the state of its
+// handlers.
+func (m *Manager) Snapshot() ManagerSnapshot {
+	snap := ManagerSnapshot{
+		Time:     time.Now(),
+		Jobs:     []JobSnapshot{},
+		Handlers: []HandlerSnapshot{},
+		Quotas:   m.quotas.snapshot(),
+		Tracing:  m.trace.Load() != nil,
+	}
+
+	m.jobsLock.Lock()
+	for _, job := range m.jobs {
+		js := JobSnapshot{
+			ID:        job.id,
+			Started:   job.start,
+			Age:       snap.Time.Sub(job.start),
+			Direction: job.params.JobType,
+			Algorithm: job.params.a,
+			Level:     job.params.level,
+			Tag:       job.params.tag,
+			Strategy:  job.strategy,
+		}
+		if js.Direction == COMPRESS {
+			js.BytesIn, js.BytesOut = atomic.LoadInt64(&job.returned), atomic.LoadInt64(&job.written)
+		} else {
+			js.BytesIn, js.BytesOut = atomic.LoadInt64(&job.read), atomic.LoadInt64(&job.returned)
+		}
+		snap.Jobs = append(snap.Jobs, js)
+	}
+	m.jobsLock.Unlock()
+	sort.Slice(snap.Jobs, func(i, j int) bool { return snap.Jobs[i].Started.Before(snap.Jobs[j].Started) })
+
+	for _, s := range m.strategies {
+		hs := HandlerSnapshot{Strategy: s, Installed: true, Algorithms: []Algorithm{}}
+		if cr, ok := m.getHandler(s).(CapabilityReporter); ok {
+			hs.Installed = cr.Installed()
+			for _, a := range DEFAULT_ALGORITHMS {
+				if cr.Supports(a) {
+					hs.Algorithms = append(hs.Algorithms, a)
+				}
+			}
+		}
+		l := m.Load(s)
+		hs.InFlight, hs.Capacity, hs.QueueWait = l.InFlight, l.Capacity, l.QueueWait
+		h := m.Health(s)
+		hs.CircuitOpen, hs.ConsecutiveErrors = h.Open, h.ConsecutiveErrors
+		if h.LastError != nil {
+			hs.LastError = h.LastError.Error()
+		}
+		snap.Handlers = append(snap.Handlers, hs)
+	}
+	return snap
+}
+
+func (q *tagQuotas) snapshot() []QuotaSnapshot {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	quotas := []QuotaSnapshot{}
+	for tag, limits := range q.limits {
+		for s, limit := range limits {
+			if limit == 0 {
+				continue
+			}
+			qs := QuotaSnapshot{Tag: tag, Strategy: StrategyType(s), Limit: limit}
+			if u := q.used[tag]; u != nil {
+				qs.InFlight = u[s]
+			}
+			quotas = append(quotas, qs)
+		}
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		if quotas[i].Tag != quotas[j].Tag {
+			return quotas[i].Tag < quotas[j].Tag
+		}
+		return quotas[i].Strategy < quotas[j].Strategy
+	})
+	return quotas
+}
+
+// DebugHandler serves Snapshot as an HTML page, or as JSON when the request
+// has ?format=json or accepts application/json. It is meant to be mounted on
+// an internal address, for example at /debug/dcl.
+func (m *Manager) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snap := m.Snapshot()
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(snap)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		debugPage.Execute(w, snap)
+	})
+}
+
+var debugPage = template.Must(template.New("dcl").Parse(`<!DOCTYPE html>
+<html>
+<head><title>dcl</title>
+<style>body{font-family:sans-serif} table{border-collapse:collapse} td,th{border:1px solid #ccc;padding:2px 8px;text-align:left}</style>
+</head>
+<body>
+<h1>dcl</h1>
+<p>{{.Time.Format "2006-01-02 15:04:05.000"}}{{if .Tracing}}, tracing{{end}}</p>
+<h2>Handlers</h2>
+<table>
+<tr><th>Strategy</th><th>Installed</th><th>Algorithms</th><th>In flight</th><th>Capacity</th><th>Queue wait</th><th>Circuit</th><th>Errors</th><th>Last error</th></tr>
+{{range .Handlers}}<tr><td>{{.Strategy}}</td><td>{{.Installed}}</td><td>{{range $i, $a := .Algorithms}}{{if $i}}, {{end}}{{$a}}{{end}}</td><td>{{.InFlight}}</td><td>{{if .Capacity}}{{.Capacity}}{{else}}unbounded{{end}}</td><td>{{.QueueWait}}</td><td>{{if .CircuitOpen}}open{{else}}closed{{end}}</td><td>{{.ConsecutiveErrors}}</td><td>{{.LastError}}</td></tr>
+{{end}}</table>
+{{if .Quotas}}<h2>Tag quotas</h2>
+<table>
+<tr><th>Tag</th><th>Strategy</th><th>In flight</th><th>Limit</th></tr>
+{{range .Quotas}}<tr><td>{{.Tag}}</td><td>{{.Strategy}}</td><td>{{.InFlight}}</td><td>{{.Limit}}</td></tr>
+{{end}}</table>
+{{end}}<h2>Jobs ({{len .Jobs}})</h2>
+<table>
+<tr><th>ID</th><th>Age</th><th>Direction</th><th>Algorithm</th><th>Level</th><th>Tag</th><th>Strategy</th><th>Bytes in</th><th>Bytes out</th></tr>
+{{range .Jobs}}<tr><td>{{.ID}}</td><td>{{.Age}}</td><td>{{.Direction}}</td><td>{{.Algorithm}}</td><td>{{.Level}}</td><td>{{.Tag}}</td><td>{{.Strategy}}</td><td>{{.BytesIn}}</td><td>{{.BytesOut}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,72 @@
+package dcl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	m := newTestManager()
+	m.SetPolicy(fixed(DEFAULT))
+	m.SetTagQuota("batch", QAT, 2)
+
+	compressed := new(bytes.Buffer)
+	gw := gzip.NewWriter(compressed)
+	gw.Write([]byte(strings.Repeat("Hello World\n", 1000)))
+	gw.Close()
+
+	// A partly read stream keeps its job open.
+	r := NewReader(compressed)
+	r.m = m
+	r.Apply(TagOption("batch"))
+	p := make([]byte, 100)
+	if _, err := r.Read(p); err != nil {
+		t.Fatalf("TestFail: read failed with '%v'", err)
+	}
+
+	snap := m.Snapshot()
+	if len(snap.Jobs) != 1 {
+		t.Fatalf("TestFail: expected 1 job, received %d", len(snap.Jobs))
+	}
+	job := snap.Jobs[0]
+	if job.Direction != DECOMPRESS || job.Strategy != DEFAULT || job.Tag != "batch" || job.BytesOut != 100 || job.BytesIn == 0 || job.Age < 0 {
+		t.Errorf("TestFail: unexpected job %+v", job)
+	}
+	if len(snap.Handlers) != len(GetStrategies()) {
+		t.Errorf("TestFail: expected %d handlers, received %d", len(GetStrategies()), len(snap.Handlers))
+	}
+	for _, h := range snap.Handlers {
+		if h.Strategy == QAT && (h.Capacity != MAX_QAT_BINDINGS || len(h.Algorithms) != len(QAT_ALGORITHMS)) {
+			t.Errorf("TestFail: unexpected QAT handler %+v", h)
+		}
+	}
+	if len(snap.Quotas) != 1 || snap.Quotas[0] != (QuotaSnapshot{"batch", QAT, 0, 2}) {
+		t.Errorf("TestFail: unexpected quotas %+v", snap.Quotas)
+	}
+
+	rec := httptest.NewRecorder()
+	m.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/dcl?format=json", nil))
+	var decoded struct {
+		Jobs []struct {
+			ID        int64  `json:"id"`
+			Direction string `json:"direction"`
+			Strategy  string `json:"strategy"`
+		} `json:"jobs"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&decoded); err != nil {
+		t.Fatalf("TestFail: invalid JSON: '%v'", err)
+	}
+	if len(decoded.Jobs) != 1 || decoded.Jobs[0].ID != int64(job.ID) || decoded.Jobs[0].Direction != "decompress" || decoded.Jobs[0].Strategy != "default" {
+		t.Errorf("TestFail: unexpected JSON jobs %+v", decoded.Jobs)
+	}
+
+	rec = httptest.NewRecorder()
+	m.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/dcl", nil))
+	if !strings.Contains(rec.Body.String(), "<td>decompress</td>") || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("TestFail: HTML page does not list the job")
+	}
+}
@@ -9,6 +9,7 @@ type Reader struct {
 	m      *Manager
 	policy PolicyFunc
 	p      JobParams
+	stats  stats
 }
 
 func NewReader(r io.Reader) *Reader {
@@ -22,30 +23,51 @@ func NewReader(r io.Reader) *Reader {
 }
 
 func (z *Reader) Read(p []byte) (n int, err error) {
-	if z.policy == nil {
-		n, z.p.id, err = z.m.SubmitJob(p, z.p)
-		return n, err
-	} else {
-		n, z.p.id, err = z.m.SubmitWithPolicy(p, z.p, z.policy)
-		return n, err
-	}
+	res := z.m.request(p, z.p, z.policy)
+	z.p.id = res.id
+	z.stats.add(&res)
+	return res.n, res.err
 }
 
 func (z *Reader) SetPolicy(p PolicyFunc) {
 	z.policy = p
 }
 
+// Stats reports the requests made by the Reader since it was created or reset.
+func (z *Reader) Stats() Stats {
+	return z.stats.get(z.p.JobType)
+}
+
+// LastStrategy returns the strategy that served the latest successful request,
+// or false if none has been served yet.
+func (z *Reader) LastStrategy() (StrategyType, bool) {
+	return z.stats.last, z.stats.served
+}
+
+// JobParams returns the parameters the Reader submits its jobs with.
+func (z *Reader) JobParams() JobParams {
+	return z.p
+}
+
+// Close releases a stream that has not been read to the end. It does not
+// close the underlying reader.
 func (z *Reader) Close() (err error) {
 	if z.closed {
 		return errClosed
 	}
 	z.closed = true
+	z.m.end(z.p.id)
 	return nil
 }
 
 func (z *Reader) Reset(r io.Reader) {
+	if !z.closed {
+		z.m.end(z.p.id)
+	}
 	z.closed = false
 	z.p.r = r
+	z.p.id = 0
+	z.stats = stats{}
 }
 
 func (z *Reader) Apply(options ...Option) (err error) {
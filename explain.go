@@ -0,0 +1,166 @@
+package dcl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ExplainReason tells why a strategy returned by a policy would or would not
+// take a job.
+type ExplainReason int
+
+const (
+	EXPLAIN_SELECTED      ExplainReason = iota // The strategy would take the job
+	EXPLAIN_KEPT                               // Usable, tried if the strategies before it turn the job away
+	EXPLAIN_INVALID                            // Not a strategy; the job would fail here
+	EXPLAIN_NOT_INSTALLED                      // The hardware or library is missing
+	EXPLAIN_UNSUPPORTED                        // The algorithm is not supported
+	EXPLAIN_CIRCUIT_OPEN                       // Skipped after repeated errors
+	EXPLAIN_TAG_QUOTA                          // The job's tag reached its quota
+	EXPLAIN_AT_CAPACITY                        // Every session is in use
+	EXPLAIN_SOFTWARE_ONLY                      // Options only the software codecs support
+	EXPLAIN_CANNOT_FLUSH                       // The stream will be flushed but the handler cannot
+)
+
+func (r ExplainReason) String() string {
+	switch r {
+	case EXPLAIN_SELECTED:
+		return "selected"
+	case EXPLAIN_KEPT:
+		return "kept"
+	case EXPLAIN_INVALID:
+		return "invalid strategy"
+	case EXPLAIN_NOT_INSTALLED:
+		return "not installed"
+	case EXPLAIN_UNSUPPORTED:
+		return "unsupported algorithm"
+	case EXPLAIN_CIRCUIT_OPEN:
+		return "circuit open"
+	case EXPLAIN_TAG_QUOTA:
+		return "tag quota reached"
+	case EXPLAIN_AT_CAPACITY:
+		return "at capacity"
+	case EXPLAIN_SOFTWARE_ONLY:
+		return "software-only options"
+	case EXPLAIN_CANNOT_FLUSH:
+		return "cannot flush"
+	}
+	return "unknown"
+}
+
+type Candidate struct {
+	Strategy StrategyType
+	Reason   ExplainReason
+}
+
+// Kept reports whether the strategy could take the job.
+func (c Candidate) Kept() bool {
+	return c.Reason == EXPLAIN_SELECTED || c.Reason == EXPLAIN_KEPT
+}
+
+// Explanation describes how a job would be routed. Policy holds the
+// strategies returned by the policy and Candidates the verdict on each of
+// them, in the same order. Found is false if none would take the job.
+type Explanation struct {
+	Policy     []StrategyType
+	Candidates []Candidate
+	Selected   StrategyType
+	Found      bool
+	Level      int // Compression level after any override by the policy
+}
+
+func (e Explanation) String() string {
+	var b strings.Builder
+	for i, c := range e.Candidates {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%v: %v", c.Strategy, c.Reason)
+	}
+	if !e.Found {
+		if b.Len() > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString("no strategy would take the job")
+	}
+	return b.String()
+}
+
+// NewJobParams returns the parameters of a job, for use with Explain.
+func NewJobParams(dir Direction, alg Algorithm, level int) JobParams {
+	return JobParams{a: alg, level: level, JobType: dir}
+}
+
+// Explain reports what SubmitJob would do with a buffer of the given size
+// without running the job. It evaluates the policy of the job's tag, then
+// checks each strategy the way the Manager would: whether it is installed and
+// supports the algorithm and options, whether it can flush a stream that will
+// be flushed, its circuit breaker, the tag's quota and its free capacity. The live state may change before a real job is submitted.
+func (m *Manager) Explain(jp JobParams, size int) Explanation {
+	return m.ExplainWithPolicy(jp, size, m.TagPolicy(jp.tag))
+}
+
+// ExplainWithPolicy is like Explain but evaluates the given policy, such as
+// one set on a Reader/Writer.
+func (m *Manager) ExplainWithPolicy(jp JobParams, size int, policy PolicyFunc) Explanation {
+	params := &PolicyParameters{
+		BufferSize:   size,
+		Strategies:   m.strategies,
+		Tag:          jp.tag,
+		Deadline:     jp.deadline,
+		LatencyClass: jp.class,
+		JobParams:    jp,
+		env:          m,
+	}
+	e := Explanation{Level: jp.level}
+	e.Policy = policy(params)
+	if params.levelOverridden && jp.JobType == COMPRESS {
+		e.Level = params.level
+	}
+
+	now := time.Now()
+	for _, s := range e.Policy {
+		reason := m.explainStrategy(s, jp, now)
+		if reason == EXPLAIN_KEPT && !e.Found {
+			reason = EXPLAIN_SELECTED
+			e.Selected, e.Found = s, true
+		}
+		e.Candidates = append(e.Candidates, Candidate{s, reason})
+		if reason == EXPLAIN_INVALID && !e.Found {
+			// SubmitWithPolicy fails the job at an invalid strategy.
+			break
+		}
+	}
+	return e
+}
+
+func (m *Manager) explainStrategy(s StrategyType, jp JobParams, now time.Time) ExplainReason {
+	if !s.IsValid() {
+		return EXPLAIN_INVALID
+	}
+	if cr, ok := m.getHandler(s).(CapabilityReporter); ok {
+		if !cr.Installed() {
+			return EXPLAIN_NOT_INSTALLED
+		}
+		if !cr.Supports(jp.a) {
+			return EXPLAIN_UNSUPPORTED
+		}
+	}
+	if jp.softwareOnly() && s != DEFAULT {
+		return EXPLAIN_SOFTWARE_ONLY
+	}
+	if jp.stream && jp.flush && !canFlush(m.handlerFor(s)) {
+		return EXPLAIN_CANNOT_FLUSH
+	}
+	if !m.healthy(s, now) {
+		return EXPLAIN_CIRCUIT_OPEN
+	}
+	if inFlight, limit := m.quotas.usage(jp.tag, s); limit > 0 && inFlight >= limit {
+		return EXPLAIN_TAG_QUOTA
+	}
+	if m.Load(s).Free() == 0 {
+		return EXPLAIN_AT_CAPACITY
+	}
+	return EXPLAIN_KEPT
+}
@@ -0,0 +1,133 @@
+package dcl
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExplain(t *testing.T) {
+	m := newTestManager()
+	m.SetPolicy(fixed(IAA, ISAL, QAT, DEFAULT))
+	jp := NewJobParams(COMPRESS, ZSTD, 3)
+	installed := func(s StrategyType) bool { return m.getHandler(s).(CapabilityReporter).Installed() }
+	if installed(IAA) || installed(ISAL) {
+		t.Skip("TestSkip: expects IAA and ISAL to be missing")
+	}
+
+	e := m.Explain(jp, 4096)
+	expected := []Candidate{{IAA, EXPLAIN_NOT_INSTALLED}, {ISAL, EXPLAIN_NOT_INSTALLED}, {QAT, EXPLAIN_SELECTED}, {DEFAULT, EXPLAIN_KEPT}}
+	if !reflect.DeepEqual(e.Candidates, expected) || !e.Found || e.Selected != QAT || e.Level != 3 {
+		t.Errorf("TestFail: unexpected explanation %v", e)
+	}
+
+	if e := m.Explain(NewJobParams(COMPRESS, LZ4, 1), 4096); e.Candidates[2].Reason != EXPLAIN_UNSUPPORTED || e.Selected != DEFAULT {
+		t.Errorf("TestFail: lz4 explained as %v", e)
+	}
+
+	for i := 0; i < CIRCUIT_ERROR_THRESHOLD; i++ {
+		m.recordHealth(QAT, ErrJobNotFound)
+	}
+	if e := m.Explain(jp, 4096); e.Candidates[2].Reason != EXPLAIN_CIRCUIT_OPEN || e.Selected != DEFAULT {
+		t.Errorf("TestFail: open circuit explained as %v", e)
+	}
+
+	z := NewWriter(new(bytes.Buffer))
+	z.Apply(TagOption("x"), AlgorithmOption(ZSTD))
+	m.SetTagQuota("x", DEFAULT, 1)
+	m.quotas.acquire("x", DEFAULT)
+	if e := m.Explain(z.JobParams(), 4096); e.Found || e.Candidates[3].Reason != EXPLAIN_TAG_QUOTA {
+		t.Errorf("TestFail: tag quota explained as %v", e)
+	}
+
+	e = m.ExplainWithPolicy(jp, 10, fixed(StrategyType(9), DEFAULT))
+	if e.Found || len(e.Candidates) != 1 || e.Candidates[0].Reason != EXPLAIN_INVALID {
+		t.Errorf("TestFail: invalid strategy explained as %v", e)
+	}
+}
+
+func TestExplainOptions(t *testing.T) {
+	m := newSimulatedManager(t)
+	m.SetPolicy(fixed(QAT, DEFAULT))
+	explain := func(options ...Option) Explanation {
+		z := NewWriter(new(bytes.Buffer))
+		if err := z.Apply(options...); err != nil {
+			t.Fatalf("TestInit: could not apply options: '%v'", err)
+		}
+		return m.Explain(z.JobParams(), 4096)
+	}
+	if e := explain(AlgorithmOption(ZSTD), WindowSizeOption(1<<20)); e.Candidates[0].Reason != EXPLAIN_SOFTWARE_ONLY || e.Selected != DEFAULT {
+		t.Errorf("TestFail: window size explained as %v", e)
+	}
+	if e := explain(StreamOption(true), FlushOption(true)); e.Selected != QAT {
+		t.Errorf("TestFail: flushed stream explained as %v", e)
+	}
+	m.Use(QAT, func(next Handler) Handler {
+		return HandlerFuncs{RequestFunc: next.Request, ReleaseFunc: next.Release}
+	})
+	if e := explain(StreamOption(true), FlushOption(true)); e.Candidates[0].Reason != EXPLAIN_CANNOT_FLUSH || e.Selected != DEFAULT {
+		t.Errorf("TestFail: flushed stream explained as %v", e)
+	}
+	if e := explain(StreamOption(true)); e.Selected != QAT {
+		t.Errorf("TestFail: stream explained as %v", e)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var c circuit
+	now := time.Now()
+	for i := 0; i < CIRCUIT_ERROR_THRESHOLD-1; i++ {
+		c.failure(now, ErrJobNotFound)
+	}
+	if !c.allow(now) {
+		t.Fatalf("TestFail: circuit opened before the threshold")
+	}
+	c.failure(now, ErrJobNotFound)
+	if c.allow(now) || !c.health(now).Open {
+		t.Errorf("TestFail: circuit did not open")
+	}
+	later := now.Add(CIRCUIT_COOLDOWN)
+	if !c.allow(later) || c.health(later).Open {
+		t.Errorf("TestFail: circuit did not close after the cooldown")
+	}
+	c.failure(later, ErrJobNotFound)
+	if c.allow(later) {
+		t.Errorf("TestFail: circuit did not open again after a failed retry")
+	}
+	c.success()
+	if !c.allow(later) || c.health(later).ConsecutiveErrors != 0 {
+		t.Errorf("TestFail: circuit did not close after a success")
+	}
+
+	m := newTestManager()
+	for i := 0; i < 2*CIRCUIT_ERROR_THRESHOLD; i++ {
+		m.recordHealth(DEFAULT, ErrJobNotFound)
+	}
+	if m.Health(DEFAULT).Open || !m.healthy(DEFAULT, now) {
+		t.Errorf("TestFail: circuit of the software fallback opened")
+	}
+}
+
+func TestCircuitCorruptInput(t *testing.T) {
+	m, err := NewManager(SimulatedHandlersOption())
+	if err != nil {
+		t.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	m.SetPolicy(fixed(QAT, DEFAULT))
+	garbage := bytes.Repeat([]byte{0xff, 0x00, 0x42}, 100)
+	for _, alg := range []Algorithm{DEFLATE, GZIP, ZSTD} {
+		for i := 0; i < 2*CIRCUIT_ERROR_THRESHOLD; i++ {
+			z := NewReader(bytes.NewReader(garbage))
+			z.Apply(ManagerOption(m), AlgorithmOption(alg))
+			if _, err := io.Copy(io.Discard, z); err == nil {
+				t.Fatalf("TestFail: %v decompressed garbage", alg)
+			}
+			z.Close()
+		}
+	}
+	if h := m.Health(QAT); h.Open || h.ConsecutiveErrors != 0 {
+		t.Errorf("TestFail: corrupt input counted as strategy errors: %+v", h)
+	}
+}
@@ -0,0 +1,262 @@
+package dcl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FUZZ_MAX_OUTPUT bounds the data decompressed from one fuzz input, so that
+// small inputs expanding to gigabytes do not stall the fuzzer.
+const FUZZ_MAX_OUTPUT = 4 << 20
+
+// fuzzManager returns a Manager with simulated accelerators, so that the fuzz
+// targets reach the accelerator paths on any machine.
+func fuzzManager(tb testing.TB) *Manager {
+	m, err := NewManager(SimulatedHandlersOption())
+	if err != nil {
+		tb.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	m.SetPolicy(fixed(QAT, IAA, ISAL, DEFAULT))
+	return m
+}
+
+// checkReleased fails if m still tracks a job or a handler holds a session.
+func checkReleased(t *testing.T, m *Manager) {
+	m.jobsLock.Lock()
+	jobs := len(m.jobs)
+	m.jobsLock.Unlock()
+	if jobs != 0 {
+		t.Errorf("TestFail: %d jobs left in the manager", jobs)
+	}
+	for _, s := range []StrategyType{QAT, IAA, ISAL, DEFAULT} {
+		if lr, ok := m.getHandler(s).(LoadReporter); ok && lr.Load().InFlight != 0 {
+			t.Errorf("TestFail: %v holds %d sessions", s, lr.Load().InFlight)
+		}
+	}
+}
+
+// readAll reads r to the end in small reads, stopping after FUZZ_MAX_OUTPUT
+// bytes. It fails the test if the reads stop making progress.
+func readAll(t *testing.T, r io.Reader) (out []byte, complete bool, err error) {
+	b := new(bytes.Buffer)
+	p := make([]byte, 4093)
+	for stalled := 0; b.Len() <= FUZZ_MAX_OUTPUT; {
+		n, err := r.Read(p)
+		b.Write(p[:n])
+		if err == io.EOF {
+			return b.Bytes(), true, nil
+		}
+		if err != nil {
+			return b.Bytes(), true, err
+		}
+		if n == 0 {
+			if stalled++; stalled > 100 {
+				t.Fatalf("TestFail: read made no progress")
+			}
+		}
+	}
+	return b.Bytes(), false, nil
+}
+
+func FuzzDecompressString(f *testing.F) {
+	f.Add("Hello World")
+	f.Add(strings.Repeat("Hello World\n", 1000))
+	m := fuzzManager(f)
+	f.Fuzz(func(t *testing.T, str string) {
+		b := new(bytes.Buffer)
+		gw := gzip.NewWriter(b)
+		gw.Write([]byte(str))
+		if err := gw.Close(); err != nil {
+			t.Fatalf("TestInit: error failed to close gzip writer '%v'", err)
+		}
+
+		z := NewReader(b)
+		z.Apply(ManagerOption(m))
+		out := new(bytes.Buffer)
+		if _, err := io.Copy(out, z); err != nil {
+			t.Fatalf("TestFail: decompression failed: '%v'", err)
+		}
+		stringCompare(str, out, t)
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	text := []byte(strings.Repeat("Hello World\n", 500))
+	for i, alg := range DEFAULT_ALGORITHMS {
+		f.Add(text, uint8(alg), uint8(1+i*2), uint8(i))
+	}
+	f.Add([]byte{}, uint8(GZIP), uint8(1), uint8(DEFAULT))
+	f.Add(randomBytes(10000), uint8(ZSTD), uint8(9), uint8(QAT))
+	m := fuzzManager(f)
+	f.Fuzz(func(t *testing.T, data []byte, alg, level, strategy uint8) {
+		a := DEFAULT_ALGORITHMS[int(alg)%len(DEFAULT_ALGORITHMS)]
+		s := StrategyType(int(strategy) % int(DEFAULT+1))
+		policy := fixed(s, DEFAULT)
+
+		b := new(bytes.Buffer)
+		w := NewWriter(b)
+		if err := w.Apply(ManagerOption(m), AlgorithmOption(a), CompressionLevelOption(1+int(level)%9)); err != nil {
+			t.Fatalf("TestInit: apply failed with '%v'", err)
+		}
+		w.SetPolicy(policy)
+		if n, err := w.Write(data); err != nil || n != len(data) {
+			t.Fatalf("TestFail: %v write of %d bytes returned %d, '%v'", a, len(data), n, err)
+		}
+
+		r := NewReader(bytes.NewReader(b.Bytes()))
+		r.Apply(ManagerOption(m), AlgorithmOption(a))
+		r.SetPolicy(policy)
+		out, _, err := readAll(t, r)
+		if err != nil || !bytes.Equal(out, data) {
+			t.Errorf("TestFail: %v round trip returned %d of %d bytes, '%v'", a, len(out), len(data), err)
+		}
+		checkReleased(t, m)
+	})
+}
+
+func FuzzDecompress(f *testing.F) {
+	text := []byte(strings.Repeat("Hello World\n", 500))
+	for _, alg := range DEFAULT_ALGORITHMS {
+		b := new(bytes.Buffer)
+		sw, _ := newSoftwareWriter(JobParams{a: alg, level: 5}, b)
+		sw.Write(text)
+		sw.Close()
+		f.Add(b.Bytes(), uint8(alg))
+		f.Add(b.Bytes()[:b.Len()/2], uint8(alg))
+		corrupt := append([]byte{}, b.Bytes()...)
+		corrupt[len(corrupt)/2] ^= 0x55
+		f.Add(corrupt, uint8(alg))
+	}
+	f.Add([]byte{}, uint8(GZIP))
+	f.Add([]byte{0x1f, 0x8b, 0x08}, uint8(GZIP))
+	m := fuzzManager(f)
+	f.Fuzz(func(t *testing.T, data []byte, alg uint8) {
+		a := DEFAULT_ALGORITHMS[int(alg)%len(DEFAULT_ALGORITHMS)]
+
+		// The result must match the Go codec reading the same bytes.
+		var ref []byte
+		rr, refErr := newSoftwareReader(JobParams{a: a}, bytes.NewReader(data))
+		if refErr == nil {
+			ref, refErr = io.ReadAll(io.LimitReader(rr, FUZZ_MAX_OUTPUT+1))
+			rr.Close()
+		} else if refErr == io.EOF {
+			// Empty input, which the Reader reports as an empty stream.
+			refErr = nil
+		}
+
+		r := NewReader(bytes.NewReader(data))
+		r.Apply(ManagerOption(m), AlgorithmOption(a))
+		out, complete, err := readAll(t, r)
+		if !complete || len(ref) > FUZZ_MAX_OUTPUT {
+			return
+		}
+		switch {
+		case refErr == nil && err != nil:
+			t.Errorf("TestFail: %v stream read by the codec failed with '%v'", a, err)
+		case refErr == nil && !bytes.Equal(out, ref):
+			t.Errorf("TestFail: %v returned %d bytes, the codec %d", a, len(out), len(ref))
+		case refErr != nil && err == nil:
+			t.Errorf("TestFail: %v stream rejected by the codec with '%v' was accepted", a, refErr)
+		case refErr != nil && err.Error() != refErr.Error() && !errors.Is(err, ErrNoWorkingStrategies):
+			t.Errorf("TestFail: %v returned '%v', expected the codec error '%v'", a, err, refErr)
+		}
+		checkReleased(t, m)
+	})
+}
+
+// fuzzOption maps an operation and argument byte onto an Option, invalid
+// values included.
+func fuzzOption(m *Manager, op, arg byte) Option {
+	switch op % 9 {
+	case 0:
+		return AlgorithmOption(Algorithm(arg % 8))
+	case 1:
+		return CompressionLevelOption(int(int8(arg)))
+	case 2:
+		return DeadlineOption(time.Duration(int8(arg)) * time.Millisecond)
+	case 3:
+		return LatencyClassOption(LatencyClass(arg % 4))
+	case 4:
+		return TagOption(strings.Repeat("t", int(arg)*2))
+	case 5:
+		if arg%2 == 0 {
+			return ManagerOption(nil)
+		}
+		return ManagerOption(m)
+	case 6:
+		return WindowSizeOption(int(int8(arg)) << 10)
+	case 7:
+		return DictionaryOption(bytes.Repeat([]byte("d"), int(arg%3)))
+	}
+	return HandlerOption(StrategyType(arg%8), NewDefaultHandler())
+}
+
+var fuzzApplyErrors = []error{
+	ErrParamCompressionLevel, ErrApplyInvalidType, ErrParamAlgorithm, ErrParamDeadline,
+	ErrParamLatencyClass, ErrParamStrategy, ErrParamManager, ErrParamTag, ErrParamWindowSize,
+	ErrParamDictionary,
+}
+
+func isOneOf(err error, errs []error) bool {
+	for _, e := range errs {
+		if err == e {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzApply(f *testing.F) {
+	f.Add([]byte{0, 3, 1, 9}, []byte{0, 3}, []byte("Hello World"))
+	f.Add([]byte{0, 2, 4, 1}, []byte{1, 1, 3}, []byte(strings.Repeat("Hello World\n", 100)))
+	f.Add([]byte{1, 200, 5, 0, 6, 9}, []byte{9, 3}, []byte{})
+	f.Add([]byte{4, 200, 2, 255, 3, 7}, []byte{}, []byte("data"))
+	// A level the gzip codec rejects must not leave a session behind.
+	f.Add([]byte{1, 100}, []byte{0, 3}, []byte("Hello World"))
+	m := fuzzManager(f)
+	if err := m.SetTagQuota("tt", QAT, 1); err != nil {
+		f.Fatalf("TestInit: could not set quota: '%v'", err)
+	}
+	f.Fuzz(func(t *testing.T, ops, strategies, data []byte) {
+		b := new(bytes.Buffer)
+		w := NewWriter(b)
+		w.Apply(ManagerOption(m))
+		for i := 0; i+1 < len(ops); i += 2 {
+			if err := w.Apply(fuzzOption(m, ops[i], ops[i+1])); err != nil && !isOneOf(err, fuzzApplyErrors) {
+				t.Fatalf("TestFail: apply returned untyped error '%v'", err)
+			}
+		}
+
+		priority := make([]StrategyType, len(strategies))
+		for i, s := range strategies {
+			priority[i] = StrategyType(int8(s) % 6)
+		}
+		policy := fixed(priority...)
+
+		n, _, err := m.SubmitWithPolicy(data, w.JobParams(), policy)
+		if err != nil {
+			if !isOneOf(err, []error{ErrNoWorkingStrategies, ErrPolicyStrategy}) {
+				t.Fatalf("TestFail: submit returned untyped error '%v'", err)
+			}
+			if b.Len() != 0 {
+				t.Errorf("TestFail: failed submit wrote %d bytes", b.Len())
+			}
+		} else {
+			if n != len(data) {
+				t.Errorf("TestFail: submit consumed %d of %d bytes", n, len(data))
+			}
+			r := NewReader(bytes.NewReader(b.Bytes()))
+			r.Apply(ManagerOption(m), AlgorithmOption(w.JobParams().Algorithm()))
+			r.SetPolicy(policy)
+			if out, _, err := readAll(t, r); err != nil || !bytes.Equal(out, data) {
+				t.Errorf("TestFail: %v output read back as %d of %d bytes, '%v'", w.JobParams().Algorithm(), len(out), len(data), err)
+			}
+		}
+		checkReleased(t, m)
+	})
+}
@@ -0,0 +1,257 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzip
+
+import (
+	"bufio"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"dcl"
+)
+
+// byteReader is the input of a Reader. It must be an io.ByteReader so that
+// the DEFLATE decoder stops at the end of a member and leaves the trailer.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// software is the policy of the DEFLATE data of each member. Accelerators read
+// their input in blocks and would consume the trailer and the members after
+// it, while the Go decoder stops at the end of the DEFLATE data.
+func software(*dcl.PolicyParameters) []dcl.StrategyType {
+	return []dcl.StrategyType{dcl.DEFAULT}
+}
+
+// A Reader is an io.Reader that can be read to retrieve uncompressed data from
+// a gzip stream. Like compress/gzip, it reads concatenated members as one
+// stream unless Multistream(false) is set, and Header holds the header of
+// the current member. Members are decoded in software.
+type Reader struct {
+	Header
+	r           byteReader
+	z           *dcl.Reader
+	options     []dcl.Option
+	digest      uint32
+	size        uint32
+	buf         [512]byte
+	err         error
+	multistream bool
+}
+
+// NewReader creates a Reader reading the given stream, and reads the header
+// of the first member. If r does not implement io.ByteReader the Reader may
+// read more data than necessary from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	z := new(Reader)
+	if err := z.Reset(r); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Apply passes options, such as dcl.ManagerOption, to the dcl Reader doing
+// the decompression. They are kept across Reset. A dcl.PolicyOption has no
+// effect, as members are always decoded in software.
+func (z *Reader) Apply(options ...dcl.Option) error {
+	if err := z.z.Apply(options...); err != nil {
+		return err
+	}
+	z.z.Apply(dcl.PolicyOption(software))
+	z.options = append(z.options, options...)
+	return nil
+}
+
+// Stats reports the requests made since the Reader was created or reset.
+func (z *Reader) Stats() dcl.Stats {
+	return z.z.Stats()
+}
+
+// Reset discards the state of the Reader and makes it read from r, like
+// NewReader. The applied options are kept.
+func (z *Reader) Reset(r io.Reader) error {
+	if z.z != nil {
+		z.z.Close()
+	}
+	*z = Reader{
+		options:     z.options,
+		multistream: true,
+	}
+	if rr, ok := r.(byteReader); ok {
+		z.r = rr
+	} else {
+		z.r = bufio.NewReader(r)
+	}
+	z.z = dcl.NewReader(z.r)
+	options := append([]dcl.Option{dcl.AlgorithmOption(dcl.DEFLATE)}, z.options...)
+	if err := z.z.Apply(options...); err != nil {
+		return err
+	}
+	z.z.Apply(dcl.PolicyOption(software))
+	z.Header, z.err = z.readHeader()
+	return z.err
+}
+
+// Multistream controls whether the Reader supports multistream files. When
+// disabled, Read returns io.EOF at the end of the current member, and the
+// next one can be read after calling Reset on the same underlying reader.
+func (z *Reader) Multistream(ok bool) {
+	z.multistream = ok
+}
+
+// readString reads a NUL-terminated string in ISO 8859-1 (Latin-1).
+func (z *Reader) readString() (string, error) {
+	var err error
+	needConv := false
+	for i := 0; ; i++ {
+		if i >= len(z.buf) {
+			return "", ErrHeader
+		}
+		z.buf[i], err = z.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if z.buf[i] > 0x7f {
+			needConv = true
+		}
+		if z.buf[i] == 0 {
+			// Digest covers the NUL terminator.
+			z.digest = crc32.Update(z.digest, crc32.IEEETable, z.buf[:i+1])
+
+			// Strings are ISO 8859-1, Latin-1 (RFC 1952, section 2.3.1).
+			if needConv {
+				s := make([]rune, 0, i)
+				for _, v := range z.buf[:i] {
+					s = append(s, rune(v))
+				}
+				return string(s), nil
+			}
+			return string(z.buf[:i]), nil
+		}
+	}
+}
+
+// readHeader reads a member header. It returns io.EOF if the input ends
+// cleanly before the header, as a stream may hold zero members.
+func (z *Reader) readHeader() (hdr Header, err error) {
+	if _, err = io.ReadFull(z.r, z.buf[:10]); err != nil {
+		return hdr, err
+	}
+	if z.buf[0] != gzipID1 || z.buf[1] != gzipID2 || z.buf[2] != gzipDeflate {
+		return hdr, ErrHeader
+	}
+	flg := z.buf[3]
+	if t := int64(le.Uint32(z.buf[4:8])); t > 0 {
+		// Section 2.3.1, the zero value for MTIME means that the
+		// modified time is not set.
+		hdr.ModTime = time.Unix(t, 0)
+	}
+	// z.buf[8] is XFL and is currently ignored.
+	hdr.OS = z.buf[9]
+	z.digest = crc32.ChecksumIEEE(z.buf[:10])
+
+	if flg&flagExtra != 0 {
+		if _, err = io.ReadFull(z.r, z.buf[:2]); err != nil {
+			return hdr, noEOF(err)
+		}
+		z.digest = crc32.Update(z.digest, crc32.IEEETable, z.buf[:2])
+		data := make([]byte, le.Uint16(z.buf[:2]))
+		if _, err = io.ReadFull(z.r, data); err != nil {
+			return hdr, noEOF(err)
+		}
+		z.digest = crc32.Update(z.digest, crc32.IEEETable, data)
+		hdr.Extra = data
+	}
+
+	var s string
+	if flg&flagName != 0 {
+		if s, err = z.readString(); err != nil {
+			return hdr, noEOF(err)
+		}
+		hdr.Name = s
+	}
+
+	if flg&flagComment != 0 {
+		if s, err = z.readString(); err != nil {
+			return hdr, noEOF(err)
+		}
+		hdr.Comment = s
+	}
+
+	if flg&flagHdrCrc != 0 {
+		if _, err = io.ReadFull(z.r, z.buf[:2]); err != nil {
+			return hdr, noEOF(err)
+		}
+		digest := le.Uint16(z.buf[:2])
+		if digest != uint16(z.digest) {
+			return hdr, ErrHeader
+		}
+	}
+
+	z.digest = 0
+	return hdr, nil
+}
+
+// Read implements io.Reader, reading uncompressed bytes from its underlying
+// Reader. The checksum of each member is verified when its end is reached.
+func (z *Reader) Read(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for n == 0 {
+		n, z.err = z.z.Read(p)
+		z.digest = crc32.Update(z.digest, crc32.IEEETable, p[:n])
+		z.size += uint32(n)
+		if z.err != io.EOF {
+			// In the normal case we return here.
+			return n, z.err
+		}
+
+		// Finished the DEFLATE data, check the trailer.
+		if _, err := io.ReadFull(z.r, z.buf[:8]); err != nil {
+			z.err = noEOF(err)
+			return n, z.err
+		}
+		digest := le.Uint32(z.buf[:4])
+		size := le.Uint32(z.buf[4:8])
+		if digest != z.digest || size != z.size {
+			z.err = ErrChecksum
+			return n, z.err
+		}
+		z.digest, z.size = 0, 0
+
+		// File is ok; check if there is another.
+		if !z.multistream {
+			return n, io.EOF
+		}
+		z.err = nil // Remove io.EOF
+
+		if _, z.err = z.readHeader(); z.err != nil {
+			return n, z.err
+		}
+	}
+
+	return n, nil
+}
+
+// Close releases the decompression job. It does not close the underlying
+// io.Reader.
+func (z *Reader) Close() error {
+	return z.z.Close()
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,304 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gzip mirrors the compress/gzip API on top of dcl, so that moving
+// existing code to the library is an import path change. The gzip header and
+// trailer are handled here and the DEFLATE data in between is compressed and
+// decompressed by the strategy the dcl Manager selects.
+package gzip
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"dcl"
+)
+
+const (
+	NoCompression      = flate.NoCompression
+	BestSpeed          = flate.BestSpeed
+	BestCompression    = flate.BestCompression
+	DefaultCompression = flate.DefaultCompression
+	HuffmanOnly        = flate.HuffmanOnly
+)
+
+// DEFAULT_LEVEL is the level used for DefaultCompression, as in compress/flate.
+const DEFAULT_LEVEL = 6
+
+const (
+	gzipID1     = 0x1f
+	gzipID2     = 0x8b
+	gzipDeflate = 8
+	flagHdrCrc  = 1 << 1
+	flagExtra   = 1 << 2
+	flagName    = 1 << 3
+	flagComment = 1 << 4
+)
+
+var (
+	// ErrChecksum and ErrHeader are the compress/gzip errors, so existing
+	// comparisons keep working.
+	ErrChecksum = gzip.ErrChecksum
+	ErrHeader   = gzip.ErrHeader
+)
+
+// Header is the compress/gzip header, holding the Name, Comment, ModTime,
+// Extra and OS fields of a member.
+type Header = gzip.Header
+
+var le = binary.LittleEndian
+
+// A Writer is an io.WriteCloser that writes a gzip member. Header fields must
+// be set before the first call to Write, Flush or Close.
+type Writer struct {
+	Header
+	w           io.Writer
+	level       int
+	options     []dcl.Option
+	z           *dcl.Writer
+	out         *switchWriter
+	digest      uint32
+	size        uint32
+	wroteHeader bool
+	closed      bool
+	buf         [10]byte
+	err         error
+}
+
+// NewWriter returns a Writer at DefaultCompression.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel returns a Writer at the given level, which is one of the
+// compress/gzip levels or a value from BestSpeed to BestCompression.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < HuffmanOnly || level > BestCompression {
+		return nil, fmt.Errorf("gzip: invalid compression level: %d", level)
+	}
+	z := new(Writer)
+	z.init(w, level, nil)
+	return z, nil
+}
+
+func (z *Writer) init(w io.Writer, level int, options []dcl.Option) {
+	*z = Writer{
+		Header:  Header{OS: 255}, // unknown
+		w:       w,
+		level:   level,
+		options: options,
+	}
+}
+
+// Apply passes options, such as dcl.ManagerOption or dcl.TagOption, to the
+// dcl Writer doing the compression. They are kept across Reset. Members are
+// only compressed by handlers that can flush, unless dcl.FlushOption(false)
+// is applied for a Writer that is never flushed.
+func (z *Writer) Apply(options ...dcl.Option) error {
+	if z.wroteHeader {
+		return errors.New("gzip: options applied after the first write")
+	}
+	z.options = append(z.options, options...)
+	return nil
+}
+
+// Stats reports the requests made for the current member.
+func (z *Writer) Stats() dcl.Stats {
+	if z.z == nil {
+		return dcl.Stats{}
+	}
+	return z.z.Stats()
+}
+
+// Reset discards the state of the Writer and makes it write to w, like
+// compress/gzip. The level and the applied options are kept.
+func (z *Writer) Reset(w io.Writer) {
+	if z.z != nil && !z.closed {
+		// End the open stream without writing to the old destination.
+		z.out.w = io.Discard
+		z.z.Close()
+	}
+	z.init(w, z.level, z.options)
+}
+
+// switchWriter lets Reset redirect the output of a stream it abandons.
+type switchWriter struct {
+	w io.Writer
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+// writeBytes writes a length-prefixed byte slice.
+func (z *Writer) writeBytes(b []byte) error {
+	if len(b) > 0xffff {
+		return errors.New("gzip.Write: Extra data is too large")
+	}
+	le.PutUint16(z.buf[:2], uint16(len(b)))
+	if _, err := z.w.Write(z.buf[:2]); err != nil {
+		return err
+	}
+	_, err := z.w.Write(b)
+	return err
+}
+
+// writeString writes a NUL-terminated string in ISO 8859-1 (Latin-1), as
+// required by the gzip format.
+func (z *Writer) writeString(s string) (err error) {
+	needconv := false
+	for _, v := range s {
+		if v == 0 || v > 0xff {
+			return errors.New("gzip.Write: non-Latin-1 header string")
+		}
+		if v > 0x7f {
+			needconv = true
+		}
+	}
+	if needconv {
+		b := make([]byte, 0, len(s))
+		for _, v := range s {
+			b = append(b, byte(v))
+		}
+		_, err = z.w.Write(b)
+	} else {
+		_, err = io.WriteString(z.w, s)
+	}
+	if err != nil {
+		return err
+	}
+	z.buf[0] = 0
+	_, err = z.w.Write(z.buf[:1])
+	return err
+}
+
+func (z *Writer) writeHeader() error {
+	z.wroteHeader = true
+	z.buf = [10]byte{0: gzipID1, 1: gzipID2, 2: gzipDeflate}
+	if z.Extra != nil {
+		z.buf[3] |= flagExtra
+	}
+	if z.Name != "" {
+		z.buf[3] |= flagName
+	}
+	if z.Comment != "" {
+		z.buf[3] |= flagComment
+	}
+	if z.ModTime.After(time.Unix(0, 0)) {
+		// Section 2.3.1, the zero value for MTIME means that the
+		// modified time is not set.
+		le.PutUint32(z.buf[4:8], uint32(z.ModTime.Unix()))
+	}
+	if z.level == BestCompression {
+		z.buf[8] = 2
+	} else if z.level == BestSpeed {
+		z.buf[8] = 4
+	}
+	z.buf[9] = z.OS
+	if _, err := z.w.Write(z.buf[:10]); err != nil {
+		return err
+	}
+	if z.Extra != nil {
+		if err := z.writeBytes(z.Extra); err != nil {
+			return err
+		}
+	}
+	if z.Name != "" {
+		if err := z.writeString(z.Name); err != nil {
+			return err
+		}
+	}
+	if z.Comment != "" {
+		if err := z.writeString(z.Comment); err != nil {
+			return err
+		}
+	}
+
+	level := z.level
+	if level == DefaultCompression {
+		level = DEFAULT_LEVEL
+	}
+	z.out = &switchWriter{z.w}
+	z.z = dcl.NewWriter(z.out)
+	options := append([]dcl.Option{
+		dcl.AlgorithmOption(dcl.DEFLATE),
+		dcl.CompressionLevelOption(level),
+		dcl.StreamOption(true),
+		dcl.FlushOption(true),
+	}, z.options...)
+	return z.z.Apply(options...)
+}
+
+// Write writes a compressed form of p to the underlying io.Writer. The
+// compressed bytes are not necessarily flushed until the Writer is closed.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if !z.wroteHeader {
+		if z.err = z.writeHeader(); z.err != nil {
+			return 0, z.err
+		}
+	}
+	z.size += uint32(len(p))
+	z.digest = crc32.Update(z.digest, crc32.IEEETable, p)
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var n int
+	n, z.err = z.z.Write(p)
+	return n, z.err
+}
+
+// Flush flushes any pending compressed data to the underlying writer, so
+// that a reader can decode everything written so far. It returns
+// dcl.ErrUnsupported if dcl.FlushOption(false) was applied and the strategy
+// running the stream cannot flush.
+func (z *Writer) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	if !z.wroteHeader {
+		if z.err = z.writeHeader(); z.err != nil {
+			return z.err
+		}
+	}
+	z.err = z.z.Flush()
+	return z.err
+}
+
+// Close closes the Writer by flushing any unwritten data to the underlying
+// io.Writer and writing the gzip footer. It does not close the underlying
+// io.Writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if !z.wroteHeader {
+		if z.err = z.writeHeader(); z.err != nil {
+			return z.err
+		}
+	}
+	if z.err = z.z.Close(); z.err != nil {
+		return z.err
+	}
+	le.PutUint32(z.buf[:4], z.digest)
+	le.PutUint32(z.buf[4:8], z.size)
+	_, z.err = z.w.Write(z.buf[:8])
+	return z.err
+}
@@ -0,0 +1,178 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"dcl"
+	"dcl/dcltest"
+)
+
+var testHeader = Header{
+	Name:    "hello-ü.txt",
+	Comment: "dcl gzip test",
+	ModTime: time.Unix(1700000000, 0),
+	Extra:   []byte("extra"),
+	OS:      3,
+}
+
+func checkHeader(t *testing.T, got Header) {
+	if got.Name != testHeader.Name || got.Comment != testHeader.Comment || !got.ModTime.Equal(testHeader.ModTime) ||
+		!bytes.Equal(got.Extra, testHeader.Extra) || got.OS != testHeader.OS {
+		t.Errorf("TestFail: header mismatch, received %+v", got)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	m := dcltest.NewManager(t)
+	input := strings.Repeat("Hello World\n", 10000)
+	for _, level := range []int{DefaultCompression, NoCompression, HuffmanOnly, BestSpeed, BestCompression} {
+		b := new(bytes.Buffer)
+		w, err := NewWriterLevel(b, level)
+		if err != nil {
+			t.Fatalf("TestInit: level %d rejected with '%v'", level, err)
+		}
+		w.Apply(dcl.ManagerOption(m))
+		w.Header = testHeader
+		for i := 0; i < len(input); i += 7000 {
+			end := i + 7000
+			if end > len(input) {
+				end = len(input)
+			}
+			if _, err := w.Write([]byte(input[i:end])); err != nil {
+				t.Fatalf("TestFail: write failed with '%v'", err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("TestFail: close failed with '%v'", err)
+		}
+		if s := w.Stats(); s.Jobs != 1 || s.Strategies[dcl.QAT].Requests == 0 {
+			t.Errorf("TestFail: level %d member not compressed as one QAT job: %+v", level, s)
+		}
+
+		r, err := gzip.NewReader(b)
+		if err != nil {
+			t.Fatalf("TestFail: compress/gzip rejected the header: '%v'", err)
+		}
+		checkHeader(t, r.Header)
+		if out, err := io.ReadAll(r); err != nil || string(out) != input {
+			t.Errorf("TestFail: level %d read back as %d bytes, '%v'", level, len(out), err)
+		}
+	}
+	if _, err := NewWriterLevel(io.Discard, 10); err == nil {
+		t.Errorf("TestFail: invalid level accepted")
+	}
+}
+
+func TestWriterFlushReset(t *testing.T) {
+	m := dcltest.NewManager(t)
+	// QAT comes first but cannot flush.
+	m.Use(dcl.QAT, func(next dcl.Handler) dcl.Handler {
+		return dcl.HandlerFuncs{RequestFunc: next.Request, ReleaseFunc: next.Release}
+	})
+	b := new(bytes.Buffer)
+	w := NewWriter(b)
+	w.Apply(dcl.ManagerOption(m))
+	w.Write([]byte("first part "))
+	if err := w.Flush(); err != nil {
+		t.Fatalf("TestFail: flush failed with '%v'", err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("TestFail: flushed header not readable: '%v'", err)
+	}
+	p := make([]byte, 100)
+	if n, _ := io.ReadFull(r, p[:11]); string(p[:n]) != "first part " {
+		t.Errorf("TestFail: flushed data read as %q", p[:n])
+	}
+
+	// Reset abandons the open member without writing to the old buffer.
+	size := b.Len()
+	b2 := new(bytes.Buffer)
+	w.Reset(b2)
+	if b.Len() != size {
+		t.Errorf("TestFail: reset wrote %d bytes to the old writer", b.Len()-size)
+	}
+	w.Close()
+	r, err = gzip.NewReader(b2)
+	if err != nil {
+		t.Fatalf("TestFail: empty member not readable: '%v'", err)
+	}
+	if out, err := io.ReadAll(r); err != nil || len(out) != 0 {
+		t.Errorf("TestFail: empty member read as %d bytes, '%v'", len(out), err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	m := dcltest.NewManager(t)
+	members := []string{strings.Repeat("first\n", 5000), strings.Repeat("second\n", 3000)}
+	b := new(bytes.Buffer)
+	for i, s := range members {
+		w := gzip.NewWriter(b)
+		if i == 0 {
+			w.Header = testHeader
+		}
+		w.Write([]byte(s))
+		w.Close()
+	}
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("TestFail: header not read: '%v'", err)
+	}
+	r.Apply(dcl.ManagerOption(m))
+	checkHeader(t, r.Header)
+	if out, err := io.ReadAll(r); err != nil || string(out) != members[0]+members[1] {
+		t.Errorf("TestFail: multistream read %d bytes, '%v'", len(out), err)
+	}
+	if st := r.Stats(); st.Jobs != 2 || len(st.Strategies) != 1 || st.Strategies[dcl.DEFAULT].Requests == 0 {
+		t.Errorf("TestFail: expected a software job per member: %+v", st)
+	}
+
+	src := bytes.NewReader(b.Bytes())
+	r.Reset(src)
+	r.Multistream(false)
+	for i, s := range members {
+		if out, err := io.ReadAll(r); err != nil || string(out) != s {
+			t.Errorf("TestFail: member %d read as %d bytes, '%v'", i, len(out), err)
+		}
+		if err := r.Reset(src); i == 0 && err != nil || i == 1 && err != io.EOF {
+			t.Errorf("TestFail: reset after member %d returned '%v'", i, err)
+		}
+		r.Multistream(false)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	m := dcltest.NewManager(t)
+	b := new(bytes.Buffer)
+	w := gzip.NewWriter(b)
+	w.Write([]byte("Hello World"))
+	w.Close()
+	valid := b.Bytes()
+
+	if _, err := NewReader(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("TestFail: empty input returned '%v'", err)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte("not gzip data"))); err != ErrHeader {
+		t.Errorf("TestFail: bad header returned '%v'", err)
+	}
+
+	corrupt := append([]byte{}, valid...)
+	corrupt[len(corrupt)-5] ^= 0xff
+	r, _ := NewReader(bytes.NewReader(corrupt))
+	r.Apply(dcl.ManagerOption(m))
+	if _, err := io.ReadAll(r); err != ErrChecksum {
+		t.Errorf("TestFail: bad checksum returned '%v'", err)
+	}
+
+	r, _ = NewReader(bytes.NewReader(valid[:len(valid)-4]))
+	r.Apply(dcl.ManagerOption(m))
+	if _, err := io.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("TestFail: truncated trailer returned '%v'", err)
+	}
+}
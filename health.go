@@ -0,0 +1,137 @@
+package dcl
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/intel/qatgo/qatzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+var ErrCircuitOpen = errors.New("strategy disabled after repeated errors")
+
+const (
+	// Consecutive errors after which a strategy is no longer tried.
+	CIRCUIT_ERROR_THRESHOLD = 5
+	// How long a strategy is skipped once its circuit opens. Afterwards jobs
+	// are tried on it again, and the next error opens the circuit again.
+	CIRCUIT_COOLDOWN = 10 * time.Second
+)
+
+// StrategyHealth reports the circuit breaker of a strategy. A strategy whose
+// circuit is open is skipped by every job until OpenUntil. The software
+// fallback never opens its circuit, since jobs have nowhere else to go.
+type StrategyHealth struct {
+	ConsecutiveErrors int
+	Open              bool
+	OpenUntil         time.Time
+	LastError         error
+}
+
+type circuit struct {
+	lock      sync.Mutex
+	errors    int
+	openUntil time.Time
+	lastError error
+}
+
+func (c *circuit) allow(now time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.errors < CIRCUIT_ERROR_THRESHOLD || !now.Before(c.openUntil)
+}
+
+func (c *circuit) success() {
+	c.lock.Lock()
+	c.errors = 0
+	c.lock.Unlock()
+}
+
+func (c *circuit) failure(now time.Time, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.errors++
+	c.lastError = err
+	if c.errors >= CIRCUIT_ERROR_THRESHOLD {
+		c.openUntil = now.Add(CIRCUIT_COOLDOWN)
+	}
+}
+
+func (c *circuit) health(now time.Time) StrategyHealth {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	h := StrategyHealth{ConsecutiveErrors: c.errors, LastError: c.lastError}
+	if c.errors >= CIRCUIT_ERROR_THRESHOLD && now.Before(c.openUntil) {
+		h.Open, h.OpenUntil = true, c.openUntil
+	}
+	return h
+}
+
+// Health returns the circuit breaker state of strategy s.
+func (m *Manager) Health(s StrategyType) StrategyHealth {
+	if !s.IsValid() {
+		return StrategyHealth{}
+	}
+	return m.health[s].health(time.Now())
+}
+
+func (m *Manager) healthy(s StrategyType, now time.Time) bool {
+	return s == DEFAULT || m.health[s].allow(now)
+}
+
+// dataErrors are returned for corrupt or truncated input. They say nothing
+// about the strategy that found them.
+var dataErrors = []error{
+	io.ErrUnexpectedEOF,
+	gzip.ErrHeader,
+	gzip.ErrChecksum,
+	zstd.ErrMagicMismatch,
+	zstd.ErrReservedBlockType,
+	zstd.ErrCompressedSizeTooBig,
+	zstd.ErrBlockTooSmall,
+	zstd.ErrUnexpectedBlockSize,
+	zstd.ErrWindowSizeExceeded,
+	zstd.ErrWindowSizeTooSmall,
+	zstd.ErrUnknownDictionary,
+	zstd.ErrFrameSizeExceeded,
+	zstd.ErrFrameSizeMismatch,
+	zstd.ErrCRCMismatch,
+	lz4.ErrInvalidFrame,
+	lz4.ErrInvalidSourceShortBuffer,
+	lz4.ErrInvalidHeaderChecksum,
+	lz4.ErrInvalidBlockChecksum,
+	lz4.ErrInvalidFrameChecksum,
+	qatzip.ErrData,
+	qatzip.ErrIntegrity,
+}
+
+func isDataError(err error) bool {
+	var corrupt flate.CorruptInputError
+	if errors.As(err, &corrupt) {
+		return true
+	}
+	for _, e := range dataErrors {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordHealth updates the circuit of s with the outcome of a request. Errors
+// caused by corrupt input leave the circuit as it is.
+func (m *Manager) recordHealth(s StrategyType, err error) {
+	if s == DEFAULT || isDataError(err) {
+		return
+	}
+	if err != nil && err != io.EOF {
+		m.health[s].failure(time.Now(), err)
+	} else {
+		m.health[s].success()
+	}
+}
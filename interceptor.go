@@ -0,0 +1,206 @@
+package dcl
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// Interceptor wraps a Handler with behaviour that applies to every job, such
+// as timing, logging, retries or fault injection. It returns the Handler the
+// Manager calls instead of next.
+type Interceptor func(next Handler) Handler
+
+// HandlerFuncs adapts functions to the Handler interface, which makes
+// interceptors short to write:
+//
+//	func(next dcl.Handler) dcl.Handler {
+//		return dcl.HandlerFuncs{
+//			RequestFunc: func(job *dcl.Job) (int, error) { ...; return next.Request(job) },
+//			ReleaseFunc: next.Release,
+//			FlushFunc:   dcl.FlushFuncOf(next),
+//		}
+//	}
+//
+// The handler can flush streams only if FlushFunc is set.
+type HandlerFuncs struct {
+	RequestFunc func(job *Job) (n int, err error)
+	ReleaseFunc func(id JobID) (err error)
+	FlushFunc   func(id JobID) (err error)
+}
+
+func (h HandlerFuncs) Request(job *Job) (n int, err error) {
+	return h.RequestFunc(job)
+}
+
+func (h HandlerFuncs) Release(id JobID) (err error) {
+	return h.ReleaseFunc(id)
+}
+
+func (h HandlerFuncs) Flush(id JobID) (err error) {
+	if h.FlushFunc == nil {
+		return ErrUnsupported
+	}
+	return h.FlushFunc(id)
+}
+
+// FlushFuncOf returns the Flush method of h, or nil if h cannot flush.
+func FlushFuncOf(h Handler) func(id JobID) error {
+	if !canFlush(h) {
+		return nil
+	}
+	return h.(Flusher).Flush
+}
+
+func canFlush(h Handler) bool {
+	if hf, ok := h.(HandlerFuncs); ok {
+		return hf.FlushFunc != nil
+	}
+	_, ok := h.(Flusher)
+	return ok
+}
+
+type handlerRef struct {
+	Handler
+}
+
+// Use wraps the handler of strategy s with interceptors, in addition to any
+// added before. The first interceptor is the outermost, so it sees each call
+// first. Jobs already running keep the chain they started with.
+//
+// Interceptors also see Flush when they set HandlerFuncs.FlushFunc. Load and
+// capability reporting always come from the handler itself.
+func (m *Manager) Use(s StrategyType, interceptors ...Interceptor) error {
+	if !s.IsValid() {
+		return ErrParamStrategy
+	}
+	m.interceptorsLock.Lock()
+	defer m.interceptorsLock.Unlock()
+	m.interceptors[s] = append(m.interceptors[s], interceptors...)
+	m.buildChain(s)
+	return nil
+}
+
+// ClearInterceptors removes every interceptor of strategy s.
+func (m *Manager) ClearInterceptors(s StrategyType) error {
+	if !s.IsValid() {
+		return ErrParamStrategy
+	}
+	m.interceptorsLock.Lock()
+	defer m.interceptorsLock.Unlock()
+	m.interceptors[s] = nil
+	m.buildChain(s)
+	return nil
+}
+
+func (m *Manager) buildChain(s StrategyType) {
+	list := m.interceptors[s]
+	if len(list) == 0 {
+		m.chains[s].Store(nil)
+		return
+	}
+	h := m.getHandler(s)
+	for i := len(list) - 1; i >= 0; i-- {
+		h = list[i](h)
+	}
+	m.chains[s].Store(&handlerRef{h})
+}
+
+// handlerFor returns the handler of strategy s wrapped by its interceptors.
+func (m *Manager) handlerFor(s StrategyType) Handler {
+	if s.IsValid() {
+		if ref := m.chains[s].Load(); ref != nil {
+			return ref.Handler
+		}
+	}
+	return m.getHandler(s)
+}
+
+// TimingInterceptor calls observe with the duration of every Request.
+func TimingInterceptor(observe func(job *Job, d time.Duration, n int, err error)) Interceptor {
+	return func(next Handler) Handler {
+		return HandlerFuncs{
+			RequestFunc: func(job *Job) (n int, err error) {
+				start := time.Now()
+				n, err = next.Request(job)
+				observe(job, time.Since(start), n, err)
+				return n, err
+			},
+			ReleaseFunc: next.Release,
+			FlushFunc:   FlushFuncOf(next),
+		}
+	}
+}
+
+// PanicError is returned by handlers wrapped with RecoverInterceptor when they
+// panic.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// RecoverInterceptor turns panics in Request, Release and Flush into a *PanicError,
+// so a faulty handler fails its job instead of the process.
+func RecoverInterceptor() Interceptor {
+	return func(next Handler) Handler {
+		recoverTo := func(err *error) {
+			if v := recover(); v != nil {
+				*err = &PanicError{Value: v, Stack: debug.Stack()}
+			}
+		}
+		h := HandlerFuncs{
+			RequestFunc: func(job *Job) (n int, err error) {
+				defer recoverTo(&err)
+				return next.Request(job)
+			},
+			ReleaseFunc: func(id JobID) (err error) {
+				defer recoverTo(&err)
+				return next.Release(id)
+			},
+		}
+		if flush := FlushFuncOf(next); flush != nil {
+			h.FlushFunc = func(id JobID) (err error) {
+				defer recoverTo(&err)
+				return flush(id)
+			}
+		}
+		return h
+	}
+}
+
+// LoggingInterceptor logs every call to l, prefixed with name. A nil logger
+// uses the standard logger.
+func LoggingInterceptor(l *log.Logger, name string) Interceptor {
+	if l == nil {
+		l = log.Default()
+	}
+	return func(next Handler) Handler {
+		h := HandlerFuncs{
+			RequestFunc: func(job *Job) (n int, err error) {
+				start := time.Now()
+				n, err = next.Request(job)
+				l.Printf("%s: request job %d %v %v: n=%d err=%v in %v",
+					name, job.id, job.params.JobType, job.params.a, n, err, time.Since(start))
+				return n, err
+			},
+			ReleaseFunc: func(id JobID) (err error) {
+				err = next.Release(id)
+				l.Printf("%s: release job %d: err=%v", name, id, err)
+				return err
+			},
+		}
+		if flush := FlushFuncOf(next); flush != nil {
+			h.FlushFunc = func(id JobID) (err error) {
+				err = flush(id)
+				l.Printf("%s: flush job %d: err=%v", name, id, err)
+				return err
+			}
+		}
+		return h
+	}
+}
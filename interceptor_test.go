@@ -0,0 +1,102 @@
+package dcl
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInterceptors(t *testing.T) {
+	m := newTestManager()
+	m.SetPolicy(fixed(DEFAULT))
+	var calls []string
+	named := func(name string) Interceptor {
+		return func(next Handler) Handler {
+			return HandlerFuncs{
+				RequestFunc: func(job *Job) (int, error) {
+					calls = append(calls, name)
+					return next.Request(job)
+				},
+				ReleaseFunc: next.Release,
+			}
+		}
+	}
+	var timed []time.Duration
+	logs := new(bytes.Buffer)
+	m.Use(DEFAULT, named("outer"), TimingInterceptor(func(job *Job, d time.Duration, n int, err error) {
+		if job.Params().Algorithm() != GZIP || n != 11 || err != nil {
+			t.Errorf("TestFail: timed job %d returned n=%d err='%v'", job.ID(), n, err)
+		}
+		timed = append(timed, d)
+	}))
+	m.Use(DEFAULT, named("inner"), LoggingInterceptor(log.New(logs, "", 0), "default"))
+
+	z := NewWriter(new(bytes.Buffer))
+	z.m = m
+	if _, err := z.Write([]byte("Hello World")); err != nil {
+		t.Fatalf("TestFail: write failed with '%v'", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"outer", "inner"}) || len(timed) != 1 {
+		t.Errorf("TestFail: unexpected calls %v, %d timings", calls, len(timed))
+	}
+	if !strings.Contains(logs.String(), "default: request job") || !strings.Contains(logs.String(), "default: release job") {
+		t.Errorf("TestFail: unexpected log %q", logs)
+	}
+
+	m.ClearInterceptors(DEFAULT)
+	calls = nil
+	z.Write([]byte("Hello World"))
+	if len(calls) != 0 {
+		t.Errorf("TestFail: cleared interceptors were called: %v", calls)
+	}
+
+	m.Use(DEFAULT, RecoverInterceptor(), func(next Handler) Handler {
+		return HandlerFuncs{
+			RequestFunc: func(job *Job) (int, error) { panic("boom") },
+			ReleaseFunc: next.Release,
+		}
+	})
+	_, err := z.Write([]byte("Hello World"))
+	var perr *PanicError
+	if !errors.As(err, &perr) || perr.Value != "boom" || len(perr.Stack) == 0 {
+		t.Errorf("TestFail: expected a PanicError, received '%v'", err)
+	}
+	if m.Load(DEFAULT).Capacity != 0 || m.Use(StrategyType(9)) != ErrParamStrategy {
+		t.Errorf("TestFail: unexpected load or strategy validation")
+	}
+}
+
+func TestInterceptorFlush(t *testing.T) {
+	m, err := NewManager(SimulatedHandlersOption())
+	if err != nil {
+		t.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	m.SetPolicy(fixed(QAT, DEFAULT))
+	logs := new(bytes.Buffer)
+	m.Use(QAT, RecoverInterceptor(), LoggingInterceptor(log.New(logs, "", 0), "qat"))
+
+	flushed := func() (StrategyType, error) {
+		w := NewWriter(new(bytes.Buffer))
+		defer w.Close()
+		w.Apply(ManagerOption(m), AlgorithmOption(DEFLATE), StreamOption(true), FlushOption(true))
+		w.Write([]byte("Hello World"))
+		err := w.Flush()
+		s, _ := w.LastStrategy()
+		return s, err
+	}
+	if s, err := flushed(); s != QAT || err != nil || !strings.Contains(logs.String(), "qat: flush job") {
+		t.Errorf("TestFail: flush through interceptors ran on %v, returned '%v', logged %q", s, err, logs)
+	}
+
+	// An interceptor that does not pass Flush on hides it.
+	m.Use(QAT, func(next Handler) Handler {
+		return HandlerFuncs{RequestFunc: next.Request, ReleaseFunc: next.Release}
+	})
+	if s, err := flushed(); s != DEFAULT || err != nil {
+		t.Errorf("TestFail: flushed stream ran on %v, returned '%v'", s, err)
+	}
+}
@@ -0,0 +1,158 @@
+package dcl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+var interopLevels = []int{1, 6, 9}
+
+// interopInput mixes text with random runs so that every codec emits several
+// blocks, and is larger than the buffers used to read it back.
+func interopInput() []byte {
+	b := new(bytes.Buffer)
+	random := randomBytes(4096)
+	for i := 0; b.Len() < 300*1024; i++ {
+		fmt.Fprintf(b, "%d: %s\n", i, strings.Repeat("interop ", i%13))
+		if i%50 == 0 {
+			b.Write(random[:i%4096])
+		}
+	}
+	return b.Bytes()
+}
+
+// interopCompress compresses input with strategy s only. Every write is a job
+// of its own, so a chunk above zero produces one member per chunk.
+func interopCompress(m *Manager, s StrategyType, alg Algorithm, level int, input []byte, chunk int) ([]byte, error) {
+	b := new(bytes.Buffer)
+	w := NewWriter(b)
+	w.Apply(ManagerOption(m), AlgorithmOption(alg), CompressionLevelOption(level))
+	w.SetPolicy(fixed(s))
+	for p := input; len(p) > 0; {
+		n := chunk
+		if n <= 0 || n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			return nil, err
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if last, ok := w.LastStrategy(); !ok || last != s {
+		return nil, fmt.Errorf("compressed by %v", last)
+	}
+	return b.Bytes(), nil
+}
+
+// interopDecompress decompresses src with strategy s only, in reads of
+// bufSize bytes.
+func interopDecompress(m *Manager, s StrategyType, alg Algorithm, src []byte, bufSize int) ([]byte, error) {
+	r := NewReader(bytes.NewReader(src))
+	r.Apply(ManagerOption(m), AlgorithmOption(alg))
+	r.SetPolicy(fixed(s))
+	out := new(bytes.Buffer)
+	p := make([]byte, bufSize)
+	for {
+		n, err := r.Read(p)
+		out.Write(p[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return out.Bytes(), err
+		}
+	}
+	if last, ok := r.LastStrategy(); !ok || last != s {
+		return nil, fmt.Errorf("decompressed by %v", last)
+	}
+	return out.Bytes(), nil
+}
+
+// interopAvailable probes whether strategy s of m can do alg in direction dir
+// on this machine, since hardware handlers may accept jobs they cannot run.
+func interopAvailable(m *Manager, s StrategyType, alg Algorithm, level int, dir Direction) bool {
+	if cr, ok := m.getHandler(s).(CapabilityReporter); ok && (!cr.Installed() || !cr.Supports(alg)) {
+		return false
+	}
+	probe := []byte("interop probe")
+	if dir == COMPRESS {
+		_, err := interopCompress(m, s, alg, level, probe, 0)
+		return err == nil
+	}
+	src, err := interopCompress(m, DEFAULT, alg, 1, probe, 0)
+	if err != nil {
+		return false
+	}
+	out, err := interopDecompress(m, s, alg, src, 64)
+	return err == nil && bytes.Equal(out, probe)
+}
+
+// runInteropMatrix compresses with every available strategy, algorithm and
+// level of m and decompresses the result with every other strategy, in reads
+// much smaller than the stream. Gzip and zstd are also written in several
+// chunks, giving a stream of concatenated members.
+func runInteropMatrix(t *testing.T, m *Manager) {
+	input := interopInput()
+	strategies := []StrategyType{QAT, IAA, ISAL, DEFAULT}
+	for _, alg := range DEFAULT_ALGORITHMS {
+		var decoders []StrategyType
+		for _, s := range strategies {
+			if interopAvailable(m, s, alg, 1, DECOMPRESS) {
+				decoders = append(decoders, s)
+			}
+		}
+		for _, c := range strategies {
+			for _, level := range interopLevels {
+				t.Run(fmt.Sprintf("%v/%v/%d", alg, c, level), func(t *testing.T) {
+					if !interopAvailable(m, c, alg, level, COMPRESS) {
+						t.Skipf("%v cannot compress %v at level %d here", c, alg, level)
+					}
+					compressed, err := interopCompress(m, c, alg, level, input, 0)
+					if err != nil {
+						t.Fatalf("TestFail: compress failed with '%v'", err)
+					}
+					v[alg].Validate(string(input), compressed, t)
+
+					// Raw deflate and the Go lz4 reader stop after one member.
+					var members []byte
+					if alg == GZIP || alg == ZSTD {
+						if members, err = interopCompress(m, c, alg, level, input, 40*1024); err != nil {
+							t.Fatalf("TestFail: compress in chunks failed with '%v'", err)
+						}
+					}
+					for _, d := range decoders {
+						out, err := interopDecompress(m, d, alg, compressed, 1000)
+						if err != nil || !bytes.Equal(out, input) {
+							t.Errorf("TestFail: %v output decompressed by %v: %d bytes, '%v'", c, d, len(out), err)
+						}
+						if members == nil {
+							continue
+						}
+						out, err = interopDecompress(m, d, alg, members, 64*1024)
+						if err != nil || !bytes.Equal(out, input) {
+							t.Errorf("TestFail: %v members decompressed by %v: %d bytes, '%v'", c, d, len(out), err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestInteropMatrix(t *testing.T) {
+	runInteropMatrix(t, newTestManager())
+}
+
+func TestInteropMatrixSimulated(t *testing.T) {
+	m, err := NewManager(SimulatedHandlersOption())
+	if err != nil {
+		t.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	runInteropMatrix(t, m)
+}
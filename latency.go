@@ -0,0 +1,191 @@
+package dcl
+
+import (
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+)
+
+type LatencyClass int
+
+const (
+	BEST_EFFORT LatencyClass = iota
+	INTERACTIVE
+	BULK
+)
+
+func (c LatencyClass) isValid() bool {
+	switch c {
+	case BEST_EFFORT, INTERACTIVE, BULK:
+		return true
+	}
+	return false
+}
+
+func (c LatencyClass) String() string {
+	switch c {
+	case INTERACTIVE:
+		return "interactive"
+	case BULK:
+		return "bulk"
+	}
+	return "best-effort"
+}
+
+const (
+	// Histogram bucket i counts latencies below 1µs << i.
+	LATENCY_BUCKETS = 26
+	// Buffers are grouped by the number of bits in their size.
+	LATENCY_SIZE_CLASSES = 33
+	// Once a histogram holds this many samples its counts are halved, so the
+	// distribution follows recent behaviour.
+	LATENCY_WINDOW = 1024
+	// Estimates need at least this many samples.
+	LATENCY_MIN_SAMPLES = 8
+)
+
+type latencyHistogram struct {
+	counts [LATENCY_BUCKETS]uint32
+	total  uint32
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	if h.total >= LATENCY_WINDOW {
+		h.total = 0
+		for i := range h.counts {
+			h.counts[i] /= 2
+			h.total += h.counts[i]
+		}
+	}
+	b := 0
+	for b < LATENCY_BUCKETS-1 && d >= time.Microsecond<<b {
+		b++
+	}
+	h.counts[b]++
+	h.total++
+}
+
+// quantile returns the upper bound of the bucket holding quantile q.
+func (h *latencyHistogram) quantile(q float64) (time.Duration, bool) {
+	if h.total < LATENCY_MIN_SAMPLES {
+		return 0, false
+	}
+	rank := uint32(q * float64(h.total))
+	var seen uint32
+	for i, n := range h.counts {
+		seen += n
+		if seen > rank {
+			return time.Microsecond << i, true
+		}
+	}
+	return time.Microsecond << (LATENCY_BUCKETS - 1), true
+}
+
+type latencyTracker struct {
+	lock sync.Mutex
+	hist [DEFAULT + 1][DECOMPRESS + 1][LATENCY_SIZE_CLASSES]latencyHistogram
+}
+
+func sizeClass(size int) int {
+	c := bits.Len(uint(size))
+	if c >= LATENCY_SIZE_CLASSES {
+		c = LATENCY_SIZE_CLASSES - 1
+	}
+	return c
+}
+
+func (t *latencyTracker) record(s StrategyType, dir Direction, size int, d time.Duration) {
+	if !s.IsValid() || (dir != COMPRESS && dir != DECOMPRESS) {
+		return
+	}
+	t.lock.Lock()
+	t.hist[s][dir][sizeClass(size)].add(d)
+	t.lock.Unlock()
+}
+
+func (t *latencyTracker) estimate(s StrategyType, dir Direction, size int, q float64) (time.Duration, bool) {
+	if !s.IsValid() || (dir != COMPRESS && dir != DECOMPRESS) {
+		return 0, false
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.hist[s][dir][sizeClass(size)].quantile(q)
+}
+
+// LatencyEstimate returns quantile q (0-1) of the latency strategy s has shown
+// recently for jobs of this direction and a similar buffer size. It returns
+// false until enough jobs have been measured.
+func (p *PolicyParameters) LatencyEstimate(s StrategyType, q float64) (time.Duration, bool) {
+	if p.env == nil {
+		return 0, false
+	}
+	return p.env.latencyEstimate(s, p.JobParams.JobType, p.BufferSize, q)
+}
+
+func (m *Manager) latencyEstimate(s StrategyType, dir Direction, size int, q float64) (time.Duration, bool) {
+	return m.latency.estimate(s, dir, size, q)
+}
+
+// LatencyPolicy orders the strategies chosen by next so that jobs are likely
+// to meet their deadline, using quantile q of the latency measured for each
+// strategy at the job's buffer size.
+//
+// For jobs with a deadline, strategies expected to meet it come first,
+// fastest first, followed by strategies without enough measurements in the
+// order given by next, and then the ones expected to miss it. Interactive jobs
+// without a deadline are ordered fastest first. This keeps tiny, latency
+// critical buffers on the CPU when it beats an accelerator round trip, which
+// leaves the accelerators to bulk jobs; those have the accelerators moved to
+// the front.
+func LatencyPolicy(q float64, next PolicyFunc) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		list := next(params)
+		deadline := params.Deadline
+
+		if deadline <= 0 {
+			switch params.LatencyClass {
+			case BULK:
+				candidates := params.Strategies
+				params.Strategies = list
+				list = Prefer(QAT, IAA)(params)
+				params.Strategies = candidates
+				return list
+			case INTERACTIVE:
+				deadline = -1
+			default:
+				return list
+			}
+		}
+
+		type estimate struct {
+			s StrategyType
+			d time.Duration
+		}
+		var meets, misses []estimate
+		var unknown []StrategyType
+		for _, s := range list {
+			d, ok := params.LatencyEstimate(s, q)
+			switch {
+			case !ok:
+				unknown = append(unknown, s)
+			case deadline < 0 || d <= deadline:
+				meets = append(meets, estimate{s, d})
+			default:
+				misses = append(misses, estimate{s, d})
+			}
+		}
+		sort.SliceStable(meets, func(i, j int) bool { return meets[i].d < meets[j].d })
+		sort.SliceStable(misses, func(i, j int) bool { return misses[i].d < misses[j].d })
+
+		ordered := make([]StrategyType, 0, len(list))
+		for _, e := range meets {
+			ordered = append(ordered, e.s)
+		}
+		ordered = append(ordered, unknown...)
+		for _, e := range misses {
+			ordered = append(ordered, e.s)
+		}
+		return ordered
+	}
+}
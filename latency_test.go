@@ -0,0 +1,99 @@
+package dcl
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	if _, ok := h.quantile(0.5); ok {
+		t.Error("TestFail: estimate returned without samples")
+	}
+	for i := 0; i < 90; i++ {
+		h.add(3 * time.Microsecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.add(time.Millisecond)
+	}
+	if d, _ := h.quantile(0.5); d != 4*time.Microsecond {
+		t.Errorf("TestFail: unexpected median %v", d)
+	}
+	if d, _ := h.quantile(0.95); d != 1024*time.Microsecond {
+		t.Errorf("TestFail: unexpected p95 %v", d)
+	}
+
+	for i := 0; i < 2*LATENCY_WINDOW; i++ {
+		h.add(time.Second)
+	}
+	if d, _ := h.quantile(0.5); d <= time.Second {
+		t.Errorf("TestFail: histogram did not follow recent samples, median %v", d)
+	}
+}
+
+func TestLatencyPolicy(t *testing.T) {
+	m := newTestManager()
+	for i := 0; i < LATENCY_MIN_SAMPLES; i++ {
+		m.latency.record(QAT, COMPRESS, 100, 200*time.Microsecond)
+		m.latency.record(ISAL, COMPRESS, 100, 20*time.Microsecond)
+		m.latency.record(DEFAULT, COMPRESS, 100, 50*time.Microsecond)
+	}
+	policy := LatencyPolicy(0.99, fixed(QAT, IAA, ISAL, DEFAULT))
+
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		class    LatencyClass
+		size     int
+		expected []StrategyType
+	}{
+		{"Deadline", 100 * time.Microsecond, BEST_EFFORT, 100, []StrategyType{ISAL, DEFAULT, IAA, QAT}},
+		{"Interactive", 0, INTERACTIVE, 100, []StrategyType{ISAL, DEFAULT, QAT, IAA}},
+		{"NoMeasurements", 100 * time.Microsecond, BEST_EFFORT, 1 << 20, []StrategyType{QAT, IAA, ISAL, DEFAULT}},
+		{"BestEffort", 0, BEST_EFFORT, 100, []StrategyType{QAT, IAA, ISAL, DEFAULT}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			params := &PolicyParameters{BufferSize: tc.size, Deadline: tc.deadline, LatencyClass: tc.class, env: m}
+			if got := policy(params); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, received %v", tc.expected, got)
+			}
+		})
+	}
+
+	bulk := LatencyPolicy(0.99, fixed(ISAL, DEFAULT, IAA, QAT))
+	if got := bulk(&PolicyParameters{LatencyClass: BULK, env: m}); !reflect.DeepEqual(got, []StrategyType{QAT, IAA, ISAL, DEFAULT}) {
+		t.Errorf("TestFail: bulk job not sent to accelerators first: %v", got)
+	}
+}
+
+func TestDeadlineOption(t *testing.T) {
+	z := NewWriter(new(bytes.Buffer))
+	z.m = newTestManager()
+	if err := z.Apply(DeadlineOption(-time.Second)); err != ErrParamDeadline {
+		t.Errorf("TestFail: negative deadline accepted: '%v'", err)
+	}
+	if err := z.Apply(LatencyClassOption(LatencyClass(-1))); err != ErrParamLatencyClass {
+		t.Errorf("TestFail: invalid class accepted: '%v'", err)
+	}
+	if err := z.Apply(DeadlineOption(time.Millisecond), LatencyClassOption(INTERACTIVE)); err != nil {
+		t.Fatalf("TestFail: options not applied: '%v'", err)
+	}
+
+	var seen PolicyParameters
+	z.SetPolicy(func(params *PolicyParameters) []StrategyType {
+		seen = *params
+		return []StrategyType{DEFAULT}
+	})
+	if _, err := z.Write([]byte("Hello World")); err != nil {
+		t.Fatalf("TestFail: write failed with '%v'", err)
+	}
+	if seen.Deadline != time.Millisecond || seen.LatencyClass != INTERACTIVE {
+		t.Errorf("TestFail: options not passed to the policy: %v %v", seen.Deadline, seen.LatencyClass)
+	}
+	if _, ok := z.m.latency.estimate(DEFAULT, COMPRESS, 11, 0.5); ok {
+		t.Error("TestFail: estimate returned after a single job")
+	}
+}
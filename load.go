@@ -0,0 +1,116 @@
+package dcl
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Weight of a new sample in the moving average of the queue wait, as 1/n.
+const QUEUE_WAIT_SMOOTHING = 8
+
+// StrategyLoad describes how busy a strategy is when a policy runs. A Capacity
+// of zero means the strategy has no fixed limit on concurrent jobs.
+//
+// QueueWait is a moving average of how long recent jobs waited between being
+// submitted and being accepted by this strategy, which grows when strategies
+// ahead of it in policies turn jobs away.
+type StrategyLoad struct {
+	InFlight  int
+	Capacity  int
+	QueueWait time.Duration
+}
+
+// Free returns the number of jobs the strategy can still take, or -1 if it has
+// no fixed limit.
+func (l StrategyLoad) Free() int {
+	if l.Capacity == 0 {
+		return -1
+	}
+	if l.InFlight >= l.Capacity {
+		return 0
+	}
+	return l.Capacity - l.InFlight
+}
+
+// Load returns the live load of the given strategy.
+func (m *Manager) Load(s StrategyType) (l StrategyLoad) {
+	if lr, ok := m.getHandler(s).(LoadReporter); ok {
+		hl := lr.Load()
+		l.InFlight, l.Capacity = hl.InFlight, hl.Capacity
+	}
+	if s.IsValid() {
+		l.QueueWait = time.Duration(m.queueWait[s].Load())
+	}
+	return l
+}
+
+func (m *Manager) recordQueueWait(s StrategyType, wait time.Duration) {
+	if !s.IsValid() {
+		return
+	}
+	for {
+		old := m.queueWait[s].Load()
+		avg := old + (int64(wait)-old)/QUEUE_WAIT_SMOOTHING
+		if m.queueWait[s].CompareAndSwap(old, avg) {
+			return
+		}
+	}
+}
+
+// Load returns the load of strategy s at the time the policy is evaluated.
+// It is zero when the parameters were not created by a Manager or a replay.
+func (p *PolicyParameters) Load(s StrategyType) StrategyLoad {
+	if p.env == nil {
+		return StrategyLoad{}
+	}
+	return p.env.Load(s)
+}
+
+// LoadBalancedPolicy spreads jobs over the candidate strategies in proportion
+// to their free capacity, so that busy accelerators are not tried first only
+// to return ErrNotAvailable. Strategies without a fixed capacity, such as the
+// CPU paths, count as cpuWeight free slots.
+//
+// The first strategy is picked at random with those weights. The others
+// follow in order of free capacity, and full strategies come last so they are
+// still tried if everything else fails.
+func LoadBalancedPolicy(cpuWeight int) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		type candidate struct {
+			s    StrategyType
+			free int
+		}
+		candidates := make([]candidate, 0, len(params.Strategies))
+		total := 0
+		for _, s := range params.Strategies {
+			free := params.Load(s).Free()
+			if free < 0 {
+				free = cpuWeight
+			}
+			candidates = append(candidates, candidate{s, free})
+			total += free
+		}
+
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].free > candidates[j].free
+		})
+		if total > 0 {
+			pick := rand.Intn(total)
+			for i, c := range candidates {
+				if pick < c.free {
+					copy(candidates[1:i+1], candidates[:i])
+					candidates[0] = c
+					break
+				}
+				pick -= c.free
+			}
+		}
+
+		list := make([]StrategyType, len(candidates))
+		for i, c := range candidates {
+			list[i] = c.s
+		}
+		return list
+	}
+}
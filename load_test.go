@@ -0,0 +1,45 @@
+package dcl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrategyLoad(t *testing.T) {
+	m := newTestManager()
+	for i := 0; i < MAX_QAT_BINDINGS-2; i++ {
+		m.getHandler(QAT).(*QatHandler).jobs[JobID(-i)] = &QATJob{}
+	}
+	if l := m.Load(QAT); l.InFlight != MAX_QAT_BINDINGS-2 || l.Capacity != MAX_QAT_BINDINGS || l.Free() != 2 {
+		t.Errorf("TestFail: unexpected QAT load %+v", l)
+	}
+	if l := m.Load(DEFAULT); l.Free() != -1 {
+		t.Errorf("TestFail: unexpected default load %+v", l)
+	}
+
+	m.recordQueueWait(ISAL, 8*time.Millisecond)
+	if l := m.Load(ISAL); l.QueueWait != time.Millisecond {
+		t.Errorf("TestFail: unexpected ISAL queue wait %v", l.QueueWait)
+	}
+}
+
+func TestLoadBalancedPolicy(t *testing.T) {
+	m := newTestManager()
+	for i := 0; i < MAX_QAT_BINDINGS; i++ {
+		m.getHandler(QAT).(*QatHandler).jobs[JobID(-i)] = &QATJob{}
+	}
+	policy := LoadBalancedPolicy(MAX_IAA_BINDINGS)
+	params := &PolicyParameters{Strategies: []StrategyType{QAT, IAA, DEFAULT}, env: m}
+
+	first := map[StrategyType]int{}
+	for i := 0; i < 1000; i++ {
+		list := policy(params)
+		if len(list) != 3 || list[2] != QAT {
+			t.Fatalf("TestFail: full strategy not placed last: %v", list)
+		}
+		first[list[0]]++
+	}
+	if first[IAA] < 350 || first[DEFAULT] < 350 {
+		t.Errorf("TestFail: work not spread by free capacity: %v", first)
+	}
+}
@@ -5,22 +5,39 @@ import (
 	"io"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var (
-	errNoWorkingStrategies = errors.New("all strategies failed")
+	ErrNoWorkingStrategies = errors.New("all strategies failed")
+	ErrPolicyStrategy      = errors.New("invalid strategy given by the policy")
 )
 
 type Manager struct {
-	strategies   []StrategyType
-	GlobalPolicy PolicyFunc
-	qat          *QatHandler
-	isal         *ISALHandler
-	iaa          *IAAHandler
-	fallback     *DefaultHandler
-	jobs         map[JobID]*Job
+	strategies    []StrategyType
+	policy        PolicyFunc
+	policyLock    sync.RWMutex
+	policyChanged PolicyChangedFunc
+	tagPolicies   map[string]PolicyFunc
+	quotas        tagQuotas
+	handlers      [DEFAULT + 1]atomic.Pointer[handlerRef]
+	jobs          map[JobID]*Job
+	jobsLock      sync.Mutex
+	queueWait     [DEFAULT + 1]atomic.Int64
+	latency       latencyTracker
+	trace         atomic.Pointer[TraceWriter]
+	metrics       metrics
+	observer      atomic.Pointer[observerRef]
+	health        [DEFAULT + 1]circuit
+
+	interceptorsLock sync.Mutex
+	interceptors     [DEFAULT + 1][]Interceptor
+	chains           [DEFAULT + 1]atomic.Pointer[handlerRef]
 }
 
+// PolicyChangedFunc is called after the global policy of a Manager is replaced.
+type PolicyChangedFunc func(old, new PolicyFunc)
+
 type Direction int
 type JobID int64
 
@@ -29,19 +46,38 @@ const (
 	DECOMPRESS
 )
 
+func (d Direction) String() string {
+	switch d {
+	case COMPRESS:
+		return "compress"
+	case DECOMPRESS:
+		return "decompress"
+	}
+	return "unknown"
+}
+
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
 var instance *Manager
 var once sync.Once
 
-func initManager() {
-	instance = &Manager{
-		strategies:   GetStrategies(),
-		GlobalPolicy: GetDefaultPolicy(),
-		qat:          NewQATHandler(),
-		isal:         NewISALHandler(),
-		iaa:          NewIAAHandler(),
-		fallback:     NewDefaultHandler(),
-		jobs:         make(map[JobID]*Job),
+func newManager() *Manager {
+	m := &Manager{
+		strategies: GetStrategies(),
+		policy:     GetDefaultPolicy(),
+		jobs:       make(map[JobID]*Job),
 	}
+	m.handlers[QAT].Store(&handlerRef{NewQATHandler()})
+	m.handlers[ISAL].Store(&handlerRef{NewISALHandler()})
+	m.handlers[IAA].Store(&handlerRef{NewIAAHandler()})
+	m.handlers[DEFAULT].Store(&handlerRef{NewDefaultHandler()})
+	return m
+}
+
+func initManager() {
+	instance = newManager()
 }
 
 func GetManager() *Manager {
@@ -49,28 +85,170 @@ func GetManager() *Manager {
 	return instance
 }
 
+// NewManager returns a Manager separate from the one returned by GetManager,
+// with the built-in handlers and the default policy, then applies options
+// such as HandlerOption. Use ManagerOption to make a Reader/Writer submit its
+// jobs to it.
+func NewManager(options ...Option) (*Manager, error) {
+	m := newManager()
+	if err := m.Apply(options...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Apply options to Manager
+func (m *Manager) Apply(options ...Option) (err error) {
+	for _, op := range options {
+		if err = op(m); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func (m *Manager) SubmitJob(p []byte, jp JobParams) (n int, id JobID, err error) {
-	return m.SubmitWithPolicy(p, jp, m.GlobalPolicy)
+	return m.SubmitWithPolicy(p, jp, m.TagPolicy(jp.tag))
+}
+
+// Policy returns the global policy used by SubmitJob for jobs without a tag
+// policy.
+func (m *Manager) Policy() PolicyFunc {
+	m.policyLock.RLock()
+	defer m.policyLock.RUnlock()
+	return m.policy
+}
+
+// SetPolicy replaces the global policy. It is safe to call while jobs are being
+// submitted; jobs that already evaluated the old policy are not affected. A nil
+// policy restores the default policy.
+func (m *Manager) SetPolicy(policy PolicyFunc) {
+	if policy == nil {
+		policy = GetDefaultPolicy()
+	}
+
+	m.policyLock.Lock()
+	old := m.policy
+	m.policy = policy
+	changed := m.policyChanged
+	m.policyLock.Unlock()
+
+	if changed != nil {
+		changed(old, policy)
+	}
+}
+
+// OnPolicyChange registers fn to be called after every SetPolicy. Passing nil
+// removes the callback.
+func (m *Manager) OnPolicyChange(fn PolicyChangedFunc) {
+	m.policyLock.Lock()
+	defer m.policyLock.Unlock()
+	m.policyChanged = fn
 }
 
 type Job struct {
-	id      JobID
-	p       []byte
-	JobType Direction
-	params  JobParams
-	w       io.Writer
-	r       io.Reader
-	h       Handler
+	id       JobID
+	p        []byte
+	JobType  Direction
+	params   JobParams
+	w        io.Writer
+	r        io.Reader
+	h        Handler
+	strategy StrategyType
+	start    time.Time
+	read     int64 // bytes read from r by the handler
+	written  int64 // bytes written to w by the handler
+	returned int64 // bytes consumed from or returned in p over all requests
 	// dir Direction TODO Add direction, e.g. compress or decompress
 }
 
+// ID returns the identifier of the job.
+func (job *Job) ID() JobID {
+	return job.id
+}
+
+// Params returns the parameters the job was submitted with.
+func (job *Job) Params() JobParams {
+	return job.params
+}
+
+// Buffer returns the data of the current request: the input of a compress
+// job or the destination of a decompress job.
+func (job *Job) Buffer() []byte {
+	return job.p
+}
+
+// Writer returns the destination of a compress job.
+func (job *Job) Writer() io.Writer {
+	return job.w
+}
+
+// Reader returns the source of a decompress job.
+func (job *Job) Reader() io.Reader {
+	return job.r
+}
+
+// NewJob returns a job for driving a Handler directly, as the Manager would.
+// The direction of jp selects whether w or r is used. Call SetBuffer before
+// each request.
+func NewJob(id JobID, jp JobParams, w io.Writer, r io.Reader) *Job {
+	jp.id, jp.w, jp.r = id, w, r
+	return &Job{id: id, JobType: jp.JobType, params: jp, w: w, r: r, start: time.Now()}
+}
+
+// SetBuffer sets the data of the next request.
+func (job *Job) SetBuffer(p []byte) {
+	job.p = p
+}
+
 type JobParams struct {
-	a       Algorithm
-	level   int
-	id      JobID
-	JobType Direction
-	w       io.Writer
-	r       io.Reader
+	a        Algorithm
+	level    int
+	id       JobID
+	JobType  Direction
+	tag      string
+	deadline time.Duration
+	class    LatencyClass
+	stream   bool
+	flush    bool
+	window   int
+	dicts    [][]byte
+	w        io.Writer
+	r        io.Reader
+}
+
+func (jp JobParams) Algorithm() Algorithm {
+	return jp.a
+}
+
+func (jp JobParams) Level() int {
+	return jp.level
+}
+
+func (jp JobParams) Tag() string {
+	return jp.tag
+}
+
+func (jp JobParams) Deadline() time.Duration {
+	return jp.deadline
+}
+
+func (jp JobParams) LatencyClass() LatencyClass {
+	return jp.class
+}
+
+func (jp JobParams) WindowSize() int {
+	return jp.window
+}
+
+func (jp JobParams) Dictionaries() [][]byte {
+	return jp.dicts
+}
+
+// softwareOnly reports whether the job uses codec options that only the Go
+// codecs implement. Hardware handlers decline such jobs with ErrUnsupported.
+func (jp JobParams) softwareOnly() bool {
+	return jp.window != 0 || len(jp.dicts) > 0
 }
 
 var (
@@ -106,68 +284,242 @@ func freeJobID(id JobID) {
 }
 
 func (m *Manager) getHandler(s StrategyType) Handler {
-	switch s {
-	case QAT:
-		return m.qat
-	case IAA:
-		return m.iaa
-	case ISAL:
-		return m.isal
-	default:
-		return m.fallback
+	if !s.IsValid() {
+		s = DEFAULT
 	}
+	return m.handlers[s].Load().Handler
+}
+
+// jobResult describes the outcome of one call to SubmitWithPolicy.
+type jobResult struct {
+	n         int
+	id        JobID
+	err       error
+	strategy  StrategyType // strategy that handled the request, if served
+	served    bool
+	continued bool // request continued a job started by an earlier call
+	fallbacks int  // strategies skipped before one handled the request
+	latency   time.Duration
+	bytesIn   int64
+	bytesOut  int64
 }
 
 func (m *Manager) SubmitWithPolicy(p []byte, jp JobParams, policy PolicyFunc) (n int, id JobID, err error) {
-	if _, present := m.jobs[jp.id]; present && jp.JobType == DECOMPRESS {
-		currentJob := m.jobs[jp.id]
+	res := m.request(p, jp, policy)
+	return res.n, res.id, res.err
+}
+
+// request handles one call from a Reader/Writer and reports how it went.
+func (m *Manager) request(p []byte, jp JobParams, policy PolicyFunc) jobResult {
+	if policy == nil {
+		policy = m.TagPolicy(jp.tag)
+	}
+	start := time.Now()
+	res := m.submit(p, jp, policy, start)
+	m.finish(p, jp, start, &res)
+	return res
+}
+
+func (m *Manager) submit(p []byte, jp JobParams, policy PolicyFunc, start time.Time) (res jobResult) {
+	m.jobsLock.Lock()
+	currentJob, present := m.jobs[jp.id]
+	m.jobsLock.Unlock()
+	if present && (jp.JobType == DECOMPRESS || jp.stream) {
 		currentJob.p = p
-		n, err = currentJob.h.Request(currentJob)
-		if err == io.EOF {
-			currentJob.h.Release(currentJob.id)
-			delete(m.jobs, currentJob.id)
-			freeJobID(currentJob.id)
+		res.id, res.strategy, res.served, res.continued = currentJob.id, currentJob.strategy, true, true
+		read, written := atomic.LoadInt64(&currentJob.read), atomic.LoadInt64(&currentJob.written)
+		res.n, res.err = currentJob.h.Request(currentJob)
+		res.latency = time.Since(start)
+		atomic.AddInt64(&currentJob.returned, int64(res.n))
+		m.recordHealth(currentJob.strategy, res.err)
+		if res.err == nil || res.err == io.EOF {
+			m.latency.record(currentJob.strategy, jp.JobType, len(p), res.latency)
 		}
-		return n, currentJob.id, err
+		if res.err != nil {
+			// The stream ended or failed, either way the session is done.
+			m.release(currentJob)
+		}
+		if jp.JobType == COMPRESS {
+			res.bytesIn, res.bytesOut = int64(res.n), atomic.LoadInt64(&currentJob.written)-written
+		} else {
+			res.bytesIn, res.bytesOut = atomic.LoadInt64(&currentJob.read)-read, int64(res.n)
+		}
+		return res
 	}
 
 	params := &PolicyParameters{
-		BufferSize: len(p),
-		Strategies: m.strategies,
-		JobParams:  jp,
+		BufferSize:   len(p),
+		Strategies:   m.strategies,
+		Tag:          jp.tag,
+		Deadline:     jp.deadline,
+		LatencyClass: jp.class,
+		JobParams:    jp,
+		buf:          p,
+		env:          m,
 	}
 
 	job := createJob()
+	job.start = start
 	job.p = p
 	job.params = jp
-	job.w = jp.w
-	job.r = jp.r
+	if jp.w != nil {
+		job.w = countingWriter{jp.w, &job.written}
+	}
+	if jp.r != nil {
+		job.r = newCountingReader(jp.r, &job.read)
+	}
+	res.id = job.id
 	priority := policy(params)
+	obs := m.getObserver()
+	if obs != nil {
+		obs.PolicyEvaluated(job.id, params, priority)
+	}
+	if params.levelOverridden && jp.JobType == COMPRESS {
+		job.params.level = params.level
+	}
 	//TODO Filter by algorithm, installed (default by having all of them installed, then remove when proved otherwise)
 	for _, strategy := range priority {
 
 		if !strategy.IsValid() {
-			return 0, job.id, errors.New("invalid strategy given by the policy")
+			res.err = ErrPolicyStrategy
+			return res
+		}
+		if jp.stream && jp.flush {
+			if !canFlush(m.handlerFor(strategy)) {
+				m.skipped(obs, job.id, jp, strategy, ErrUnsupported)
+				res.fallbacks++
+				continue
+			}
+		}
+		if !m.healthy(strategy, time.Now()) {
+			m.skipped(obs, job.id, jp, strategy, ErrCircuitOpen)
+			res.fallbacks++
+			continue
 		}
-		h := m.getHandler(strategy)
+		if !m.quotas.acquire(jp.tag, strategy) {
+			m.skipped(obs, job.id, jp, strategy, ErrTagQuota)
+			res.fallbacks++
+			continue
+		}
+		h := m.handlerFor(strategy)
+		attempt := time.Now()
 		n, err := h.Request(job)
 		if err == ErrNotAvailable || err == ErrUnsupported {
+			m.quotas.release(jp.tag, strategy)
+			m.skipped(obs, job.id, jp, strategy, err)
+			res.fallbacks++
 			continue
 		} else if err == ErrNotInstalled {
 			// TODO Remove from the global strategy options
+			m.quotas.release(jp.tag, strategy)
+			m.skipped(obs, job.id, jp, strategy, err)
+			res.fallbacks++
 			continue
-		} else if err != nil && err != io.EOF {
-			return 0, job.id, err
+		}
+		res.strategy, res.served = strategy, true
+		res.latency = time.Since(attempt)
+		m.recordHealth(strategy, err)
+		if err != nil && err != io.EOF {
+			h.Release(job.id)
+			m.quotas.release(jp.tag, strategy)
+			res.err = err
+			return res
 		}
 		job.h = h
+		job.strategy = strategy
+		atomic.AddInt64(&job.returned, int64(n))
+		m.jobsLock.Lock()
 		m.jobs[job.id] = job
+		m.jobsLock.Unlock()
+		m.recordQueueWait(strategy, attempt.Sub(start))
+		m.latency.record(strategy, jp.JobType, len(p), res.latency)
 
-		if job.params.JobType == DECOMPRESS && err == io.EOF || job.params.JobType == COMPRESS && err == nil {
-			h.Release(job.id)
-			delete(m.jobs, job.id) //delete job from manager list in addition to handler list
-			freeJobID(job.id)
+		if job.params.JobType == DECOMPRESS && err == io.EOF {
+			m.release(job)
+		} else if job.params.JobType == COMPRESS && !jp.stream {
+			// The output is incomplete if the handler cannot finish it.
+			err = m.release(job)
+		}
+		// Handlers may flush output on release, so count it afterwards.
+		if jp.JobType == COMPRESS {
+			res.bytesIn, res.bytesOut = int64(n), atomic.LoadInt64(&job.written)
+		} else {
+			res.bytesIn, res.bytesOut = atomic.LoadInt64(&job.read), int64(n)
 		}
-		return n, job.id, err
+		res.n, res.err = n, err
+		return res
+	}
+	res.err = ErrNoWorkingStrategies
+	return res
+}
+
+// release frees the handler session and the tag quota slot held by a job. It
+// returns the error of the handler, which for a compress job means its output
+// is incomplete.
+func (m *Manager) release(job *Job) (err error) {
+	err = job.h.Release(job.id)
+	m.jobsLock.Lock()
+	delete(m.jobs, job.id) //delete job from manager list in addition to handler list
+	m.jobsLock.Unlock()
+	freeJobID(job.id)
+	m.quotas.release(job.params.tag, job.strategy)
+	if obs := m.getObserver(); obs != nil {
+		obs.JobReleased(job.id, job.params, job.strategy)
+	}
+	return err
+}
+
+func (m *Manager) lookup(id JobID) (job *Job, present bool) {
+	m.jobsLock.Lock()
+	defer m.jobsLock.Unlock()
+	job, present = m.jobs[id]
+	return
+}
+
+// flush makes the handler of a compress stream write out the data it holds,
+// and returns the number of bytes written.
+func (m *Manager) flush(id JobID) (written int64, err error) {
+	job, present := m.lookup(id)
+	if !present {
+		return 0, nil
+	}
+	if !canFlush(job.h) {
+		return 0, ErrUnsupported
+	}
+	before := atomic.LoadInt64(&job.written)
+	err = job.h.(Flusher).Flush(id)
+	m.recordHealth(job.strategy, err)
+	return atomic.LoadInt64(&job.written) - before, err
+}
+
+// end releases a job that is still open, such as a compress stream or an
+// unfinished decompress stream, and returns the number of bytes written by
+// the release.
+func (m *Manager) end(id JobID) (written int64, err error) {
+	job, present := m.lookup(id)
+	if !present {
+		return 0, nil
+	}
+	before := atomic.LoadInt64(&job.written)
+	err = m.release(job)
+	return atomic.LoadInt64(&job.written) - before, err
+}
+
+// skipped reports a strategy that turned a job away.
+func (m *Manager) skipped(obs Observer, id JobID, jp JobParams, s StrategyType, err error) {
+	m.metrics.skipped(s, jp, err == ErrNotAvailable || err == ErrTagQuota)
+	if obs != nil {
+		obs.StrategySkipped(id, jp, s, err)
+	}
+}
+
+// finish reports the outcome of a request once it has been handled.
+func (m *Manager) finish(p []byte, jp JobParams, start time.Time, res *jobResult) {
+	m.metrics.record(jp, res)
+	if t := m.trace.Load(); t != nil {
+		t.record(p, jp, start, res)
+	}
+	if obs := m.getObserver(); obs != nil {
+		obs.RequestFinished(res.id, jp, res.outcome())
 	}
-	return 0, job.id, errNoWorkingStrategies
 }
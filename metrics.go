@@ -0,0 +1,271 @@
+package dcl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of the request latency histogram buckets.
+var METRICS_LATENCY_BUCKETS = [...]time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// StrategyMetrics holds the counters of one strategy, algorithm and direction
+// since the Manager was created.
+//
+// Jobs counts the jobs started on the strategy and Requests every call it
+// served, including calls that continued a job. Fallbacks counts the times the
+// strategy was skipped for a job, and Rejections the subset of those where it
+// was at capacity (ErrNotAvailable or a tag quota). Errors counts requests that
+// failed with any other error.
+type StrategyMetrics struct {
+	Strategy   StrategyType
+	Algorithm  Algorithm
+	Direction  Direction
+	Jobs       uint64
+	Requests   uint64
+	BytesIn    uint64
+	BytesOut   uint64
+	Fallbacks  uint64
+	Rejections uint64
+	Errors     uint64
+	Latency    LatencyHistogram
+}
+
+// LatencyHistogram is a cumulative histogram of request latencies: Counts[i]
+// holds the requests that took at most Buckets[i].
+type LatencyHistogram struct {
+	Buckets []time.Duration
+	Counts  []uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+// MetricsSnapshot is a point-in-time copy of the Manager's metrics. Strategies
+// only holds combinations that have seen any activity. Unserved counts the
+// requests no strategy could take.
+type MetricsSnapshot struct {
+	Time       time.Time
+	Strategies []StrategyMetrics
+	Unserved   uint64
+}
+
+type metricsCell struct {
+	jobs       atomic.Uint64
+	requests   atomic.Uint64
+	bytesIn    atomic.Uint64
+	bytesOut   atomic.Uint64
+	fallbacks  atomic.Uint64
+	rejections atomic.Uint64
+	errors     atomic.Uint64
+	latency    [len(METRICS_LATENCY_BUCKETS) + 1]atomic.Uint64
+	latencySum atomic.Int64
+}
+
+type metrics struct {
+	cells    [DEFAULT + 1][ZSTD + 1][DECOMPRESS + 1]metricsCell
+	unserved atomic.Uint64
+}
+
+func (mt *metrics) cell(s StrategyType, a Algorithm, dir Direction) *metricsCell {
+	if !s.IsValid() || !a.isValid() || (dir != COMPRESS && dir != DECOMPRESS) {
+		return nil
+	}
+	return &mt.cells[s][a][dir]
+}
+
+// skipped counts a strategy that did not take a job.
+func (mt *metrics) skipped(s StrategyType, jp JobParams, rejected bool) {
+	c := mt.cell(s, jp.a, jp.JobType)
+	if c == nil {
+		return
+	}
+	c.fallbacks.Add(1)
+	if rejected {
+		c.rejections.Add(1)
+	}
+}
+
+func (mt *metrics) record(jp JobParams, res *jobResult) {
+	if !res.served {
+		mt.unserved.Add(1)
+		return
+	}
+	c := mt.cell(res.strategy, jp.a, jp.JobType)
+	if c == nil {
+		return
+	}
+	c.requests.Add(1)
+	if !res.continued {
+		c.jobs.Add(1)
+	}
+	if res.err != nil && res.err != io.EOF {
+		c.errors.Add(1)
+		return
+	}
+	c.bytesIn.Add(uint64(res.bytesIn))
+	c.bytesOut.Add(uint64(res.bytesOut))
+	b := 0
+	for b < len(METRICS_LATENCY_BUCKETS) && res.latency > METRICS_LATENCY_BUCKETS[b] {
+		b++
+	}
+	c.latency[b].Add(1)
+	c.latencySum.Add(int64(res.latency))
+}
+
+func (c *metricsCell) snapshot() (sm StrategyMetrics) {
+	sm.Jobs = c.jobs.Load()
+	sm.Requests = c.requests.Load()
+	sm.BytesIn = c.bytesIn.Load()
+	sm.BytesOut = c.bytesOut.Load()
+	sm.Fallbacks = c.fallbacks.Load()
+	sm.Rejections = c.rejections.Load()
+	sm.Errors = c.errors.Load()
+	sm.Latency.Buckets = METRICS_LATENCY_BUCKETS[:]
+	sm.Latency.Counts = make([]uint64, len(METRICS_LATENCY_BUCKETS))
+	for i := range c.latency {
+		n := c.latency[i].Load()
+		sm.Latency.Count += n
+		if i < len(sm.Latency.Counts) {
+			sm.Latency.Counts[i] = sm.Latency.Count
+		}
+	}
+	sm.Latency.Sum = time.Duration(c.latencySum.Load())
+	return sm
+}
+
+// Metrics returns a snapshot of the counters of every strategy.
+func (m *Manager) Metrics() MetricsSnapshot {
+	snap := MetricsSnapshot{Time: time.Now(), Unserved: m.metrics.unserved.Load()}
+	for _, s := range GetStrategies() {
+		for _, a := range DEFAULT_ALGORITHMS {
+			for _, dir := range []Direction{COMPRESS, DECOMPRESS} {
+				sm := m.metrics.cells[s][a][dir].snapshot()
+				if sm.Requests == 0 && sm.Fallbacks == 0 {
+					continue
+				}
+				sm.Strategy, sm.Algorithm, sm.Direction = s, a, dir
+				snap.Strategies = append(snap.Strategies, sm)
+			}
+		}
+	}
+	return snap
+}
+
+// WritePrometheus writes the snapshot in the Prometheus text exposition format.
+func (snap MetricsSnapshot) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	counters := []struct {
+		name, help string
+		value      func(*StrategyMetrics) uint64
+	}{
+		{"dcl_jobs_total", "Jobs started on a strategy.", func(s *StrategyMetrics) uint64 { return s.Jobs }},
+		{"dcl_requests_total", "Requests served by a strategy.", func(s *StrategyMetrics) uint64 { return s.Requests }},
+		{"dcl_bytes_in_total", "Bytes consumed by a strategy.", func(s *StrategyMetrics) uint64 { return s.BytesIn }},
+		{"dcl_bytes_out_total", "Bytes produced by a strategy.", func(s *StrategyMetrics) uint64 { return s.BytesOut }},
+		{"dcl_fallbacks_total", "Jobs that skipped a strategy.", func(s *StrategyMetrics) uint64 { return s.Fallbacks }},
+		{"dcl_rejections_total", "Jobs a strategy turned away at capacity.", func(s *StrategyMetrics) uint64 { return s.Rejections }},
+		{"dcl_errors_total", "Requests a strategy failed.", func(s *StrategyMetrics) uint64 { return s.Errors }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for i := range snap.Strategies {
+			s := &snap.Strategies[i]
+			fmt.Fprintf(bw, "%s{%s} %d\n", c.name, s.labels(), c.value(s))
+		}
+	}
+
+	const hist = "dcl_request_duration_seconds"
+	fmt.Fprintf(bw, "# HELP %s Latency of requests served by a strategy.\n# TYPE %s histogram\n", hist, hist)
+	for i := range snap.Strategies {
+		s := &snap.Strategies[i]
+		labels := s.labels()
+		for b, bound := range s.Latency.Buckets {
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"%s\"} %d\n", hist, labels, seconds(bound), s.Latency.Counts[b])
+		}
+		fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", hist, labels, s.Latency.Count)
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", hist, labels, seconds(s.Latency.Sum))
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", hist, labels, s.Latency.Count)
+	}
+
+	fmt.Fprintf(bw, "# HELP dcl_unserved_requests_total Requests no strategy could take.\n# TYPE dcl_unserved_requests_total counter\n")
+	fmt.Fprintf(bw, "dcl_unserved_requests_total %d\n", snap.Unserved)
+	return bw.Flush()
+}
+
+func (s *StrategyMetrics) labels() string {
+	return fmt.Sprintf("strategy=%q,algorithm=%q,direction=%q", s.Strategy, s.Algorithm, s.Direction)
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// MetricsHandler serves the Manager's metrics in the Prometheus text format.
+func (m *Manager) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.Metrics().WritePrometheus(w)
+	})
+}
+
+// countingWriter and countingReader count the bytes a handler moves through
+// the output or input of a job.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// countingByteReader keeps the io.ByteReader of the source, which lets codecs
+// such as compress/flate stop reading exactly at the end of a stream.
+type countingByteReader struct {
+	countingReader
+	br io.ByteReader
+}
+
+func (c countingByteReader) ReadByte() (byte, error) {
+	b, err := c.br.ReadByte()
+	if err == nil {
+		atomic.AddInt64(c.n, 1)
+	}
+	return b, err
+}
+
+func newCountingReader(r io.Reader, n *int64) io.Reader {
+	if br, ok := r.(io.ByteReader); ok {
+		return countingByteReader{countingReader{r, n}, br}
+	}
+	return countingReader{r, n}
+}
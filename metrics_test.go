@@ -0,0 +1,77 @@
+package dcl
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func findMetrics(snap MetricsSnapshot, s StrategyType, a Algorithm, dir Direction) StrategyMetrics {
+	for _, sm := range snap.Strategies {
+		if sm.Strategy == s && sm.Algorithm == a && sm.Direction == dir {
+			return sm
+		}
+	}
+	return StrategyMetrics{}
+}
+
+func TestMetrics(t *testing.T) {
+	m := newSimulatedManager(t, IAA)
+	m.SetPolicy(fixed(IAA, DEFAULT))
+	input := []byte(strings.Repeat("Hello World\n", 1000))
+	b := new(bytes.Buffer)
+	z := NewWriter(b)
+	z.m = m
+	if _, err := z.Write(input); err != nil {
+		t.Fatalf("TestFail: write failed with '%v'", err)
+	}
+
+	m.SetTagQuota("x", DEFAULT, 1)
+	m.quotas.acquire("x", DEFAULT)
+	z.Apply(TagOption("x"))
+	if _, err := z.Write(input); err != ErrNoWorkingStrategies {
+		t.Fatalf("TestFail: job over quota returned '%v'", err)
+	}
+
+	snap := m.Metrics()
+	sm := findMetrics(snap, DEFAULT, GZIP, COMPRESS)
+	if sm.Jobs != 1 || sm.Requests != 1 || sm.Errors != 0 {
+		t.Errorf("TestFail: unexpected job counts %+v", sm)
+	}
+	if sm.BytesIn != uint64(len(input)) || sm.BytesOut != uint64(b.Len()) {
+		t.Errorf("TestFail: expected %d bytes in and %d out, received %d and %d", len(input), b.Len(), sm.BytesIn, sm.BytesOut)
+	}
+	if sm.Fallbacks != 1 || sm.Rejections != 1 {
+		t.Errorf("TestFail: expected one quota rejection, received %d fallbacks and %d rejections", sm.Fallbacks, sm.Rejections)
+	}
+	if sm.Latency.Count != 1 || sm.Latency.Counts[len(sm.Latency.Counts)-1] > 1 {
+		t.Errorf("TestFail: unexpected latency histogram %+v", sm.Latency)
+	}
+	if iaa := findMetrics(snap, IAA, GZIP, COMPRESS); iaa.Fallbacks != 2 || iaa.Rejections != 0 || iaa.Requests != 0 {
+		t.Errorf("TestFail: unexpected IAA counts %+v", iaa)
+	}
+	if snap.Unserved != 1 {
+		t.Errorf("TestFail: expected 1 unserved request, received %d", snap.Unserved)
+	}
+
+	rec := httptest.NewRecorder()
+	m.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, line := range []string{
+		"# TYPE dcl_jobs_total counter",
+		`dcl_jobs_total{strategy="default",algorithm="gzip",direction="compress"} 1`,
+		`dcl_fallbacks_total{strategy="IAA",algorithm="gzip",direction="compress"} 2`,
+		`dcl_request_duration_seconds_bucket{strategy="default",algorithm="gzip",direction="compress",le="+Inf"} 1`,
+		`dcl_request_duration_seconds_count{strategy="default",algorithm="gzip",direction="compress"} 1`,
+		"dcl_unserved_requests_total 1",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("TestFail: exposition is missing %q", line)
+		}
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("TestFail: unexpected content type %q", ct)
+	}
+}
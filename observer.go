@@ -0,0 +1,112 @@
+package dcl
+
+import (
+	"time"
+)
+
+// Observer is notified of the decisions the Manager makes for each job. The
+// methods are called synchronously from the goroutine submitting the job, so
+// they should return quickly. Embed NopObserver to implement only some of
+// them.
+type Observer interface {
+	// PolicyEvaluated is called with the strategies returned by the policy
+	// for a new job, before any of them is tried.
+	PolicyEvaluated(id JobID, params *PolicyParameters, strategies []StrategyType)
+	// StrategySkipped is called when a strategy turns a job away with
+	// ErrNotAvailable, ErrUnsupported, ErrNotInstalled, ErrTagQuota or ErrCircuitOpen.
+	StrategySkipped(id JobID, jp JobParams, s StrategyType, err error)
+	// RequestFinished is called after every request, whether it started a
+	// job or continued one, and whether or not a strategy took it.
+	RequestFinished(id JobID, jp JobParams, o Outcome)
+	// JobReleased is called when a job gives up its handler session.
+	JobReleased(id JobID, jp JobParams, s StrategyType)
+}
+
+// Outcome describes how a request was handled. Strategy is only meaningful
+// when Served is set.
+type Outcome struct {
+	Strategy  StrategyType
+	Served    bool
+	Continued bool // The request continued a job started by an earlier one
+	N         int
+	Err       error
+	Fallbacks int
+	Latency   time.Duration
+	BytesIn   int64
+	BytesOut  int64
+}
+
+// NopObserver implements Observer with methods that do nothing.
+type NopObserver struct{}
+
+func (NopObserver) PolicyEvaluated(JobID, *PolicyParameters, []StrategyType) {}
+func (NopObserver) StrategySkipped(JobID, JobParams, StrategyType, error)    {}
+func (NopObserver) RequestFinished(JobID, JobParams, Outcome)                {}
+func (NopObserver) JobReleased(JobID, JobParams, StrategyType)               {}
+
+type multiObserver []Observer
+
+// MultiObserver notifies each of the observers in turn.
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+func (mo multiObserver) PolicyEvaluated(id JobID, params *PolicyParameters, strategies []StrategyType) {
+	for _, o := range mo {
+		o.PolicyEvaluated(id, params, strategies)
+	}
+}
+
+func (mo multiObserver) StrategySkipped(id JobID, jp JobParams, s StrategyType, err error) {
+	for _, o := range mo {
+		o.StrategySkipped(id, jp, s, err)
+	}
+}
+
+func (mo multiObserver) RequestFinished(id JobID, jp JobParams, out Outcome) {
+	for _, o := range mo {
+		o.RequestFinished(id, jp, out)
+	}
+}
+
+func (mo multiObserver) JobReleased(id JobID, jp JobParams, s StrategyType) {
+	for _, o := range mo {
+		o.JobReleased(id, jp, s)
+	}
+}
+
+type observerRef struct {
+	Observer
+}
+
+// SetObserver registers o to be notified of the Manager's decisions. It
+// replaces any earlier observer; use MultiObserver to register several. A nil
+// observer removes it.
+func (m *Manager) SetObserver(o Observer) {
+	if o == nil {
+		m.observer.Store(nil)
+		return
+	}
+	m.observer.Store(&observerRef{o})
+}
+
+func (m *Manager) getObserver() Observer {
+	if ref := m.observer.Load(); ref != nil {
+		return ref.Observer
+	}
+	return nil
+}
+
+func (res *jobResult) outcome() Outcome {
+	return Outcome{
+		Strategy:  res.strategy,
+		Served:    res.served,
+		Continued: res.continued,
+		N:         res.n,
+		Err:       res.err,
+		Fallbacks: res.fallbacks,
+		Latency:   res.latency,
+		BytesIn:   res.bytesIn,
+		BytesOut:  res.bytesOut,
+	}
+}
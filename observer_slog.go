@@ -0,0 +1,79 @@
+//go:build go1.21
+
+package dcl
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+type slogObserver struct {
+	l *slog.Logger
+}
+
+// NewSlogObserver returns an Observer that logs every decision to l. Policy
+// evaluations, skipped strategies and releases are logged at debug level,
+// finished requests at debug level unless they failed or found no strategy,
+// which are logged as warnings.
+func NewSlogObserver(l *slog.Logger) Observer {
+	if l == nil {
+		l = slog.Default()
+	}
+	return slogObserver{l}
+}
+
+func jobAttrs(id JobID, jp JobParams) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.Int64("job", int64(id)),
+		slog.String("algorithm", jp.a.String()),
+		slog.String("direction", jp.JobType.String()),
+	}
+	if jp.tag != "" {
+		attrs = append(attrs, slog.String("tag", jp.tag))
+	}
+	return attrs
+}
+
+func strategyNames(list []StrategyType) []string {
+	names := make([]string, len(list))
+	for i, s := range list {
+		names[i] = s.String()
+	}
+	return names
+}
+
+func (o slogObserver) PolicyEvaluated(id JobID, params *PolicyParameters, strategies []StrategyType) {
+	attrs := append(jobAttrs(id, params.JobParams),
+		slog.Int("size", params.BufferSize),
+		slog.Any("strategies", strategyNames(strategies)))
+	o.l.LogAttrs(context.Background(), slog.LevelDebug, "dcl policy evaluated", attrs...)
+}
+
+func (o slogObserver) StrategySkipped(id JobID, jp JobParams, s StrategyType, err error) {
+	attrs := append(jobAttrs(id, jp), slog.String("strategy", s.String()), slog.String("reason", err.Error()))
+	o.l.LogAttrs(context.Background(), slog.LevelDebug, "dcl strategy skipped", attrs...)
+}
+
+func (o slogObserver) RequestFinished(id JobID, jp JobParams, out Outcome) {
+	level := slog.LevelDebug
+	attrs := jobAttrs(id, jp)
+	if out.Served {
+		attrs = append(attrs, slog.String("strategy", out.Strategy.String()))
+	}
+	attrs = append(attrs,
+		slog.Int("n", out.N),
+		slog.Int("fallbacks", out.Fallbacks),
+		slog.Duration("latency", out.Latency),
+		slog.Bool("continued", out.Continued))
+	if out.Err != nil && out.Err != io.EOF {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("error", out.Err.Error()))
+	}
+	o.l.LogAttrs(context.Background(), level, "dcl request finished", attrs...)
+}
+
+func (o slogObserver) JobReleased(id JobID, jp JobParams, s StrategyType) {
+	attrs := append(jobAttrs(id, jp), slog.String("strategy", s.String()))
+	o.l.LogAttrs(context.Background(), slog.LevelDebug, "dcl job released", attrs...)
+}
@@ -0,0 +1,37 @@
+//go:build go1.21
+
+package dcl
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogObserver(t *testing.T) {
+	m := newSimulatedManager(t, IAA)
+	m.SetPolicy(fixed(IAA, DEFAULT))
+	logs := new(bytes.Buffer)
+	m.SetObserver(NewSlogObserver(slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+
+	z := NewWriter(new(bytes.Buffer))
+	z.m = m
+	z.Apply(TagOption("web"))
+	if _, err := z.Write([]byte("Hello World")); err != nil {
+		t.Fatalf("TestFail: write failed with '%v'", err)
+	}
+	for _, s := range []string{
+		`msg="dcl policy evaluated"`,
+		`strategies="[IAA default]"`,
+		`msg="dcl strategy skipped"`,
+		`strategy=IAA`,
+		`msg="dcl request finished"`,
+		`tag=web`,
+		`msg="dcl job released"`,
+	} {
+		if !strings.Contains(logs.String(), s) {
+			t.Errorf("TestFail: log is missing %s:\n%s", s, logs)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package dcl
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type recordingObserver struct {
+	events []string
+}
+
+func (r *recordingObserver) PolicyEvaluated(id JobID, params *PolicyParameters, strategies []StrategyType) {
+	r.events = append(r.events, fmt.Sprintf("policy %v", strategies))
+}
+
+func (r *recordingObserver) StrategySkipped(id JobID, jp JobParams, s StrategyType, err error) {
+	r.events = append(r.events, fmt.Sprintf("skip %v: %v", s, err))
+}
+
+func (r *recordingObserver) RequestFinished(id JobID, jp JobParams, o Outcome) {
+	if !o.Served {
+		r.events = append(r.events, fmt.Sprintf("unserved fallbacks=%d err=%v", o.Fallbacks, o.Err))
+		return
+	}
+	r.events = append(r.events, fmt.Sprintf("finish %v n=%d fallbacks=%d err=%v", o.Strategy, o.N, o.Fallbacks, o.Err))
+}
+
+func (r *recordingObserver) JobReleased(id JobID, jp JobParams, s StrategyType) {
+	r.events = append(r.events, fmt.Sprintf("release %v", s))
+}
+
+func TestObserver(t *testing.T) {
+	m := newSimulatedManager(t, IAA)
+	m.SetPolicy(fixed(IAA, DEFAULT))
+	obs := &recordingObserver{}
+	m.SetObserver(MultiObserver(obs, NopObserver{}))
+
+	z := NewWriter(new(bytes.Buffer))
+	z.m = m
+	if _, err := z.Write([]byte("Hello World")); err != nil {
+		t.Fatalf("TestFail: write failed with '%v'", err)
+	}
+	m.SetTagQuota("x", DEFAULT, 1)
+	m.quotas.acquire("x", DEFAULT)
+	z.Apply(TagOption("x"))
+	z.Write([]byte("Hello World"))
+
+	expected := []string{
+		"policy [IAA default]",
+		"skip IAA: " + ErrNotInstalled.Error(),
+		"release default",
+		"finish default n=11 fallbacks=1 err=<nil>",
+		"policy [IAA default]",
+		"skip IAA: " + ErrNotInstalled.Error(),
+		"skip default: " + ErrTagQuota.Error(),
+		"unserved fallbacks=2 err=" + ErrNoWorkingStrategies.Error(),
+	}
+	if !reflect.DeepEqual(obs.events, expected) {
+		t.Errorf("TestFail: expected events\n%q\nreceived\n%q", expected, obs.events)
+	}
+
+	m.SetObserver(nil)
+	z.Apply(TagOption(""))
+	z.Write([]byte("Hello World"))
+	if len(obs.events) != len(expected) {
+		t.Errorf("TestFail: removed observer was notified")
+	}
+}
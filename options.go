@@ -1,6 +1,10 @@
 package dcl
 
-import "errors"
+import (
+	"compress/flate"
+	"errors"
+	"time"
+)
 
 type Option func(a applier) error
 
@@ -8,15 +12,26 @@ var (
 	ErrParamCompressionLevel = errors.New("compression parameter invalid")
 	ErrApplyInvalidType      = errors.New("cannot apply parameters to this object")
 	ErrParamAlgorithm        = errors.New("algorithm parameter invalid")
+	ErrParamDeadline         = errors.New("deadline parameter invalid")
+	ErrParamLatencyClass     = errors.New("latency class parameter invalid")
+	ErrParamStrategy         = errors.New("strategy parameter invalid")
+	ErrParamHandler          = errors.New("handler parameter invalid")
+	ErrParamManager          = errors.New("manager parameter invalid")
+	ErrParamWindowSize       = errors.New("window size parameter invalid")
+	ErrParamDictionary       = errors.New("dictionary parameter invalid")
 )
 
 type applier interface {
 	Apply(...Option) error
 }
 
+// CompressionLevelOption sets the level of a Writer. Levels follow
+// compress/flate: 1 to 9 trade speed for size, 0 stores the data, -1 selects
+// the codec default and -2 only applies Huffman coding. Handlers that cannot
+// honor a level decline the job.
 func CompressionLevelOption(level int) Option {
 	return func(a applier) error {
-		if level <= 0 {
+		if level < flate.HuffmanOnly {
 			return ErrParamCompressionLevel
 		}
 
@@ -50,3 +65,190 @@ func AlgorithmOption(alg Algorithm) Option {
 		return nil
 	}
 }
+
+// DeadlineOption sets how long each job of the Reader/Writer may take. It is
+// passed to policies as PolicyParameters.Deadline; zero removes the deadline.
+func DeadlineOption(d time.Duration) Option {
+	return func(a applier) error {
+		if d < 0 {
+			return ErrParamDeadline
+		}
+
+		switch z := a.(type) {
+		case *Reader:
+			z.p.deadline = d
+		case *Writer:
+			z.p.deadline = d
+		default:
+			return ErrApplyInvalidType
+		}
+
+		return nil
+	}
+}
+
+// LatencyClassOption marks the jobs of the Reader/Writer as latency critical
+// or as bulk work. It is passed to policies as PolicyParameters.LatencyClass.
+func LatencyClassOption(class LatencyClass) Option {
+	return func(a applier) error {
+		if !class.isValid() {
+			return ErrParamLatencyClass
+		}
+
+		switch z := a.(type) {
+		case *Reader:
+			z.p.class = class
+		case *Writer:
+			z.p.class = class
+		default:
+			return ErrApplyInvalidType
+		}
+
+		return nil
+	}
+}
+
+// StreamOption makes the writes of a Writer one compressed stream, run as a
+// single job from the first Write until Close, instead of a complete stream
+// per Write. The strategy is chosen once for the whole stream, and Flush
+// makes the data written so far decodable. Set it before the first Write.
+func StreamOption(stream bool) Option {
+	return func(a applier) error {
+		switch z := a.(type) {
+		case *Writer:
+			z.p.stream = stream
+		default:
+			return ErrApplyInvalidType
+		}
+
+		return nil
+	}
+}
+
+// FlushOption declares that a stream Writer will be flushed, so its stream is
+// only started on handlers that implement Flusher. Without it a stream may run
+// on a handler whose Flush returns ErrUnsupported. Set it before the first
+// Write.
+func FlushOption(flush bool) Option {
+	return func(a applier) error {
+		switch z := a.(type) {
+		case *Writer:
+			z.p.flush = flush
+		default:
+			return ErrApplyInvalidType
+		}
+
+		return nil
+	}
+}
+
+// WindowSizeOption sets the window of a Writer, or the largest window a Reader
+// accepts. Only the ZSTD software codec supports it, so hardware handlers
+// decline jobs that set it. Zero restores the codec default.
+func WindowSizeOption(size int) Option {
+	return func(a applier) error {
+		if size < 0 {
+			return ErrParamWindowSize
+		}
+
+		switch z := a.(type) {
+		case *Reader:
+			z.p.window = size
+		case *Writer:
+			z.p.window = size
+		default:
+			return ErrApplyInvalidType
+		}
+
+		return nil
+	}
+}
+
+// DictionaryOption sets the dictionaries of a Reader/Writer. A Writer uses the
+// first one; a ZSTD Reader picks the one named by each frame. DEFLATE and ZSTD
+// support dictionaries in the software codecs only, so hardware handlers
+// decline jobs that set them. No dictionaries restores the default.
+func DictionaryOption(dicts ...[]byte) Option {
+	return func(a applier) error {
+		for _, d := range dicts {
+			if len(d) == 0 {
+				return ErrParamDictionary
+			}
+		}
+
+		switch z := a.(type) {
+		case *Reader:
+			z.p.dicts = dicts
+		case *Writer:
+			z.p.dicts = dicts
+		default:
+			return ErrApplyInvalidType
+		}
+
+		return nil
+	}
+}
+
+// PolicyOption sets the policy of a Reader/Writer, like SetPolicy. It lets
+// wrappers that only pass options through choose the policy.
+func PolicyOption(p PolicyFunc) Option {
+	return func(a applier) error {
+		switch z := a.(type) {
+		case *Reader:
+			z.policy = p
+		case *Writer:
+			z.policy = p
+		default:
+			return ErrApplyInvalidType
+		}
+
+		return nil
+	}
+}
+
+// HandlerOption replaces the handler of strategy s on a Manager, for example
+// with a SimulatedHandler. Interceptors added with Use wrap the new handler.
+// Jobs already running keep the handler they started on.
+func HandlerOption(s StrategyType, h Handler) Option {
+	return func(a applier) error {
+		if !s.IsValid() {
+			return ErrParamStrategy
+		}
+		if h == nil {
+			return ErrParamHandler
+		}
+
+		switch z := a.(type) {
+		case *Manager:
+			z.interceptorsLock.Lock()
+			z.handlers[s].Store(&handlerRef{h})
+			z.buildChain(s)
+			z.interceptorsLock.Unlock()
+		default:
+			return ErrApplyInvalidType
+		}
+
+		return nil
+	}
+}
+
+// ManagerOption makes the Reader/Writer submit its jobs to m instead of the
+// Manager returned by GetManager.
+func ManagerOption(m *Manager) Option {
+	return func(a applier) error {
+		if m == nil {
+			return ErrParamManager
+		}
+
+		switch z := a.(type) {
+		case *Reader:
+			z.m = m
+		case *Writer:
+			z.m = m
+		default:
+			return ErrApplyInvalidType
+		}
+
+		return nil
+	}
+}
@@ -1,9 +1,44 @@
 package dcl
 
+import "time"
+
 type PolicyParameters struct {
-	BufferSize int
-	Strategies []StrategyType
-	JobParams  JobParams
+	BufferSize   int
+	Strategies   []StrategyType
+	Tag          string
+	Deadline     time.Duration
+	LatencyClass LatencyClass
+	JobParams    JobParams
+
+	env             policyEnv
+	buf             []byte
+	compressibility *Compressibility
+	level           int
+	levelOverridden bool
+}
+
+// policyEnv supplies the live state that policies can query: the Manager, or
+// the simulator during a replay.
+type policyEnv interface {
+	Load(s StrategyType) StrategyLoad
+	latencyEstimate(s StrategyType, dir Direction, size int, q float64) (time.Duration, bool)
+}
+
+// Compressibility estimates how well the buffer of a compress job will
+// compress. It is computed the first time it is asked for.
+func (p *PolicyParameters) Compressibility() Compressibility {
+	if p.compressibility == nil {
+		c := EstimateCompressibility(p.buf)
+		p.compressibility = &c
+	}
+	return *p.compressibility
+}
+
+// OverrideLevel makes a new compress job run at the given compression level
+// instead of the one configured on the Writer.
+func (p *PolicyParameters) OverrideLevel(level int) {
+	p.level = level
+	p.levelOverridden = true
 }
 
 type PolicyFunc func(*PolicyParameters) []StrategyType
@@ -21,3 +56,144 @@ func BufferSizePolicy(params *PolicyParameters) []StrategyType {
 	}
 	return list
 }
+
+// Policy combinators
+//
+// A policy that returns nil has no opinion about the job. The matchers
+// (ByAlgorithm, ByDirection and BySizeRange) return nil when they do not apply,
+// which lets them be tried in turn with FirstMatch. Exclude, Prefer and
+// AppendFallback edit the candidate list in params.Strategies and are meant to
+// be used as later stages of a Chain.
+//
+// Chain evaluates its policies left to right. Each one sees the result of the
+// previous stage as params.Strategies, starting from the strategies offered
+// by the Manager; a nil result leaves the list unchanged. For example
+//
+//	Chain(BufferSizePolicy, Exclude(IAA), AppendFallback(DEFAULT))
+//
+// orders by buffer size, drops IAA and finally makes sure the software path is
+// tried last. Combinators never modify the slices they are given.
+
+// Chain runs each policy on the result of the previous one.
+func Chain(policies ...PolicyFunc) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		candidates := params.Strategies
+		defer func() { params.Strategies = candidates }()
+
+		for _, policy := range policies {
+			if list := policy(params); list != nil {
+				params.Strategies = list
+			}
+		}
+		if params.Strategies == nil {
+			return nil
+		}
+		// The result may be the strategy bank of the Manager, so callers
+		// get their own copy.
+		return append(make([]StrategyType, 0, len(params.Strategies)), params.Strategies...)
+	}
+}
+
+// FirstMatch returns the result of the first policy with an opinion.
+func FirstMatch(policies ...PolicyFunc) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		for _, policy := range policies {
+			if list := policy(params); list != nil {
+				return list
+			}
+		}
+		return nil
+	}
+}
+
+// ByAlgorithm applies the policy registered for the job's algorithm.
+func ByAlgorithm(policies map[Algorithm]PolicyFunc) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		if policy, ok := policies[params.JobParams.a]; ok && policy != nil {
+			return policy(params)
+		}
+		return nil
+	}
+}
+
+// ByDirection applies compress or decompress depending on the job type. Either
+// may be nil.
+func ByDirection(compress, decompress PolicyFunc) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		policy := compress
+		if params.JobParams.JobType == DECOMPRESS {
+			policy = decompress
+		}
+		if policy == nil {
+			return nil
+		}
+		return policy(params)
+	}
+}
+
+// BySizeRange applies policy to buffers of at least min and less than max
+// bytes. A max of zero or less means there is no upper bound.
+func BySizeRange(min, max int, policy PolicyFunc) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		if params.BufferSize < min || (max > 0 && params.BufferSize >= max) {
+			return nil
+		}
+		return policy(params)
+	}
+}
+
+// Exclude removes the given strategies from the candidate list.
+func Exclude(strategies ...StrategyType) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		list := make([]StrategyType, 0, len(params.Strategies))
+		for _, s := range params.Strategies {
+			if !containsStrategy(strategies, s) {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+}
+
+// Prefer moves the given strategies, in the given order, to the front of the
+// candidate list. Strategies that are not candidates are not added.
+func Prefer(strategies ...StrategyType) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		list := make([]StrategyType, 0, len(params.Strategies))
+		for _, s := range strategies {
+			if containsStrategy(params.Strategies, s) && !containsStrategy(list, s) {
+				list = append(list, s)
+			}
+		}
+		for _, s := range params.Strategies {
+			if !containsStrategy(list, s) {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+}
+
+// AppendFallback adds the given strategies to the end of the candidate list
+// unless they are already present.
+func AppendFallback(strategies ...StrategyType) PolicyFunc {
+	return func(params *PolicyParameters) []StrategyType {
+		list := make([]StrategyType, len(params.Strategies), len(params.Strategies)+len(strategies))
+		copy(list, params.Strategies)
+		for _, s := range strategies {
+			if !containsStrategy(list, s) {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+}
+
+func containsStrategy(slice []StrategyType, strategy StrategyType) bool {
+	for _, s := range slice {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
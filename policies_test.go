@@ -0,0 +1,96 @@
+package dcl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func fixed(list ...StrategyType) PolicyFunc {
+	return func(*PolicyParameters) []StrategyType {
+		return list
+	}
+}
+
+func TestPolicyCombinators(t *testing.T) {
+	all := []StrategyType{QAT, ISAL, IAA, DEFAULT}
+	gzipSmall := PolicyParameters{BufferSize: 100, Strategies: all, JobParams: JobParams{a: GZIP}}
+	gzipLarge := PolicyParameters{BufferSize: 100000, Strategies: all, JobParams: JobParams{a: GZIP}}
+	zstdSmall := PolicyParameters{BufferSize: 100, Strategies: all, JobParams: JobParams{a: ZSTD}}
+	gunzip := PolicyParameters{BufferSize: 100, Strategies: all, JobParams: JobParams{a: GZIP, JobType: DECOMPRESS}}
+
+	tests := []struct {
+		name     string
+		policy   PolicyFunc
+		params   PolicyParameters
+		expected []StrategyType
+	}{
+		{"Exclude", Exclude(QAT, IAA), gzipSmall, []StrategyType{ISAL, DEFAULT}},
+		{"ExcludeAll", Exclude(all...), gzipSmall, []StrategyType{}},
+		{"Prefer", Prefer(DEFAULT, IAA), gzipSmall, []StrategyType{DEFAULT, IAA, QAT, ISAL}},
+		{"AppendFallback", Chain(fixed(QAT), AppendFallback(DEFAULT, QAT)), gzipSmall, []StrategyType{QAT, DEFAULT}},
+		{"ChainOrder", Chain(BufferSizePolicy, Exclude(IAA), AppendFallback(DEFAULT)), gzipLarge, []StrategyType{QAT, ISAL, DEFAULT}},
+		{"ChainNilKeepsList", Chain(fixed(IAA, QAT), ByAlgorithm(map[Algorithm]PolicyFunc{ZSTD: fixed(DEFAULT)})), gzipSmall, []StrategyType{IAA, QAT}},
+		{"ChainEmpty", Chain(), gzipSmall, all},
+		{"ByAlgorithm", ByAlgorithm(map[Algorithm]PolicyFunc{ZSTD: fixed(DEFAULT)}), zstdSmall, []StrategyType{DEFAULT}},
+		{"ByAlgorithmMiss", ByAlgorithm(map[Algorithm]PolicyFunc{ZSTD: fixed(DEFAULT)}), gzipSmall, nil},
+		{"ByDirection", ByDirection(fixed(QAT), fixed(IAA)), gunzip, []StrategyType{IAA}},
+		{"ByDirectionNil", ByDirection(fixed(QAT), nil), gunzip, nil},
+		{"BySizeRange", BySizeRange(0, 4096, fixed(ISAL)), gzipSmall, []StrategyType{ISAL}},
+		{"BySizeRangeMiss", BySizeRange(0, 4096, fixed(ISAL)), gzipLarge, nil},
+		{"BySizeRangeUnbounded", BySizeRange(4096, 0, fixed(QAT)), gzipLarge, []StrategyType{QAT}},
+		{"FirstMatch", FirstMatch(BySizeRange(0, 4096, fixed(ISAL)), fixed(QAT)), gzipLarge, []StrategyType{QAT}},
+		{"FirstMatchNone", FirstMatch(BySizeRange(0, 4096, fixed(ISAL))), gzipLarge, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy(&tc.params); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, received %v", tc.expected, got)
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(all, []StrategyType{QAT, ISAL, IAA, DEFAULT}) {
+		t.Errorf("combinators modified the candidate list: %v", all)
+	}
+	Chain()(&gzipSmall)[0] = DEFAULT
+	if all[0] != QAT {
+		t.Errorf("editing the result of Chain modified the candidate list: %v", all)
+	}
+}
+
+func TestSetPolicy(t *testing.T) {
+	m := &Manager{policy: BufferSizePolicy}
+	params := &PolicyParameters{BufferSize: 10}
+
+	var changes int
+	m.OnPolicyChange(func(old, new PolicyFunc) {
+		if !reflect.DeepEqual(old(params), []StrategyType{ISAL, IAA, QAT}) || !reflect.DeepEqual(new(params), []StrategyType{DEFAULT}) {
+			t.Errorf("TestFail: unexpected policies passed to the callback")
+		}
+		changes++
+	})
+	m.SetPolicy(fixed(DEFAULT))
+	if changes != 1 {
+		t.Fatalf("TestFail: callback called %d times", changes)
+	}
+	m.OnPolicyChange(nil)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			m.SetPolicy(fixed(QAT))
+			m.SetPolicy(nil)
+		}
+		close(done)
+	}()
+	for i := 0; i < 1000; i++ {
+		if m.Policy()(params) == nil {
+			t.Fatal("TestFail: policy returned no strategies")
+		}
+	}
+	<-done
+
+	if got := m.Policy()(params); !reflect.DeepEqual(got, []StrategyType{ISAL, IAA, QAT}) {
+		t.Errorf("TestFail: nil policy did not restore the default, received %v", got)
+	}
+}
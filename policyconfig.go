@@ -0,0 +1,255 @@
+package dcl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	ErrPolicyNoStrategies = errors.New("no strategies given")
+	ErrPolicyRange        = errors.New("minimum is larger than maximum")
+	ErrPolicyNegative     = errors.New("value cannot be negative")
+	ErrPolicyDuplicate    = errors.New("strategy listed more than once")
+)
+
+// PolicyConfig is the declarative form of a PolicyFunc. Rules are evaluated in
+// order and the first match decides the strategy list. Default is used when no
+// rule matches; if it is empty the built-in default policy is used instead.
+type PolicyConfig struct {
+	Rules   []PolicyRule `json:"rules"`
+	Default []string     `json:"default,omitempty"`
+}
+
+// PolicyRule matches a job when every condition that is set holds. MaxSize is
+// exclusive, and a zero MaxSize or MaxLevel means there is no upper bound.
+type PolicyRule struct {
+	Name       string   `json:"name,omitempty"`
+	MinSize    int      `json:"min_size,omitempty"`
+	MaxSize    int      `json:"max_size,omitempty"`
+	Algorithms []string `json:"algorithms,omitempty"`
+	MinLevel   int      `json:"min_level,omitempty"`
+	MaxLevel   int      `json:"max_level,omitempty"`
+	Direction  string   `json:"direction,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Strategies []string `json:"strategies"`
+}
+
+// PolicyConfigError reports which rule and field of a PolicyConfig is invalid.
+// Rule is -1 for errors in the default strategy list.
+type PolicyConfigError struct {
+	Rule  int
+	Name  string
+	Field string
+	Err   error
+}
+
+func (e *PolicyConfigError) Error() string {
+	if e.Rule < 0 {
+		return fmt.Sprintf("policy default: %s: %v", e.Field, e.Err)
+	}
+	if e.Name != "" {
+		return fmt.Sprintf("policy rule %d (%q): %s: %v", e.Rule, e.Name, e.Field, e.Err)
+	}
+	return fmt.Sprintf("policy rule %d: %s: %v", e.Rule, e.Field, e.Err)
+}
+
+func (e *PolicyConfigError) Unwrap() error {
+	return e.Err
+}
+
+type policyRule struct {
+	minSize    int
+	maxSize    int
+	algs       []Algorithm
+	minLevel   int
+	maxLevel   int
+	dir        Direction
+	anyDir     bool
+	tags       []string
+	strategies []StrategyType
+}
+
+func (r *policyRule) match(params *PolicyParameters) bool {
+	if params.BufferSize < r.minSize || (r.maxSize > 0 && params.BufferSize >= r.maxSize) {
+		return false
+	}
+	if len(r.algs) > 0 && !contains(r.algs, params.JobParams.a) {
+		return false
+	}
+	if params.JobParams.level < r.minLevel || (r.maxLevel > 0 && params.JobParams.level > r.maxLevel) {
+		return false
+	}
+	if !r.anyDir && params.JobParams.JobType != r.dir {
+		return false
+	}
+	if len(r.tags) > 0 {
+		for _, tag := range r.tags {
+			if tag == params.Tag {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ReadPolicyConfig decodes and validates a JSON policy configuration.
+func ReadPolicyConfig(r io.Reader) (*PolicyConfig, error) {
+	cfg, _, err := readPolicyConfig(r)
+	return cfg, err
+}
+
+// readPolicyConfig decodes a JSON policy configuration and compiles it, which
+// validates it.
+func readPolicyConfig(r io.Reader) (*PolicyConfig, PolicyFunc, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	cfg := new(PolicyConfig)
+	if err := dec.Decode(cfg); err != nil {
+		return nil, nil, fmt.Errorf("policy config: %w", err)
+	}
+	policy, err := cfg.Compile()
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, policy, nil
+}
+
+// Compile validates the configuration and turns it into a PolicyFunc. The
+// policy returns a new slice on every call, so callers may modify it.
+func (c *PolicyConfig) Compile() (PolicyFunc, error) {
+	rules := make([]policyRule, 0, len(c.Rules))
+	for i, rule := range c.Rules {
+		compiled, err := rule.compile()
+		if err != nil {
+			err.Rule = i
+			err.Name = rule.Name
+			return nil, err
+		}
+		rules = append(rules, compiled)
+	}
+
+	var fallback []StrategyType
+	if len(c.Default) > 0 {
+		var err error
+		if fallback, err = parseStrategyList(c.Default); err != nil {
+			return nil, &PolicyConfigError{Rule: -1, Field: "default", Err: err}
+		}
+	}
+	defaultPolicy := GetDefaultPolicy()
+
+	return func(params *PolicyParameters) []StrategyType {
+		for i := range rules {
+			if rules[i].match(params) {
+				return append([]StrategyType(nil), rules[i].strategies...)
+			}
+		}
+		if fallback != nil {
+			return append([]StrategyType(nil), fallback...)
+		}
+		return defaultPolicy(params)
+	}, nil
+}
+
+func (r *PolicyRule) compile() (compiled policyRule, cerr *PolicyConfigError) {
+	fail := func(field string, err error) (policyRule, *PolicyConfigError) {
+		return policyRule{}, &PolicyConfigError{Field: field, Err: err}
+	}
+
+	if r.MinSize < 0 {
+		return fail("min_size", ErrPolicyNegative)
+	}
+	if r.MaxSize < 0 {
+		return fail("max_size", ErrPolicyNegative)
+	}
+	if r.MaxSize > 0 && r.MinSize >= r.MaxSize {
+		return fail("max_size", ErrPolicyRange)
+	}
+	if r.MinLevel < 0 {
+		return fail("min_level", ErrPolicyNegative)
+	}
+	if r.MaxLevel < 0 {
+		return fail("max_level", ErrPolicyNegative)
+	}
+	if r.MaxLevel > 0 && r.MinLevel > r.MaxLevel {
+		return fail("max_level", ErrPolicyRange)
+	}
+
+	compiled = policyRule{
+		minSize:  r.MinSize,
+		maxSize:  r.MaxSize,
+		minLevel: r.MinLevel,
+		maxLevel: r.MaxLevel,
+		anyDir:   true,
+		tags:     append([]string(nil), r.Tags...),
+	}
+
+	for _, name := range r.Algorithms {
+		a, err := ParseAlgorithm(name)
+		if err != nil {
+			return fail("algorithms", err)
+		}
+		compiled.algs = append(compiled.algs, a)
+	}
+
+	switch r.Direction {
+	case "":
+	case COMPRESS.String():
+		compiled.dir, compiled.anyDir = COMPRESS, false
+	case DECOMPRESS.String():
+		compiled.dir, compiled.anyDir = DECOMPRESS, false
+	default:
+		return fail("direction", fmt.Errorf("unknown direction %q", r.Direction))
+	}
+
+	var err error
+	if compiled.strategies, err = parseStrategyList(r.Strategies); err != nil {
+		return fail("strategies", err)
+	}
+	return compiled, nil
+}
+
+func parseStrategyList(names []string) ([]StrategyType, error) {
+	if len(names) == 0 {
+		return nil, ErrPolicyNoStrategies
+	}
+	list := make([]StrategyType, 0, len(names))
+	for _, name := range names {
+		s, err := ParseStrategyType(name)
+		if err != nil {
+			return nil, err
+		}
+		if containsStrategy(list, s) {
+			return nil, fmt.Errorf("%w: %s", ErrPolicyDuplicate, s)
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// LoadPolicyConfig replaces the global policy with the one described by the
+// JSON read from r, using SetPolicy. If the configuration does not parse or
+// validate, the current policy stays in place.
+func (m *Manager) LoadPolicyConfig(r io.Reader) error {
+	_, policy, err := readPolicyConfig(r)
+	if err != nil {
+		return err
+	}
+	m.SetPolicy(policy)
+	return nil
+}
+
+// LoadPolicyFile is LoadPolicyConfig for a configuration stored on disk, and
+// can be called again whenever the file changes.
+func (m *Manager) LoadPolicyFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.LoadPolicyConfig(f)
+}
@@ -0,0 +1,114 @@
+package dcl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPolicyConfig = `{
+	"rules": [
+		{"name": "tenant", "tags": ["batch"], "strategies": ["QAT"]},
+		{"name": "small-gzip", "max_size": 4096, "algorithms": ["gzip"], "direction": "compress", "strategies": ["ISAL", "default"]},
+		{"name": "zstd-high", "algorithms": ["zstd"], "min_level": 10, "strategies": ["default"]}
+	],
+	"default": ["QAT", "IAA", "ISAL"]
+}`
+
+func TestPolicyConfigMatch(t *testing.T) {
+	cfg, err := ReadPolicyConfig(strings.NewReader(testPolicyConfig))
+	if err != nil {
+		t.Fatalf("TestInit: could not read config: '%v'", err)
+	}
+	policy, err := cfg.Compile()
+	if err != nil {
+		t.Fatalf("TestInit: could not compile config: '%v'", err)
+	}
+
+	tests := []struct {
+		name     string
+		params   PolicyParameters
+		expected []StrategyType
+	}{
+		{"Tag", PolicyParameters{BufferSize: 10, Tag: "batch", JobParams: JobParams{a: GZIP, level: 1}}, []StrategyType{QAT}},
+		{"SmallGzip", PolicyParameters{BufferSize: 100, JobParams: JobParams{a: GZIP, level: 1}}, []StrategyType{ISAL, DEFAULT}},
+		{"LargeGzip", PolicyParameters{BufferSize: 4096, JobParams: JobParams{a: GZIP, level: 1}}, []StrategyType{QAT, IAA, ISAL}},
+		{"SmallGunzip", PolicyParameters{BufferSize: 100, JobParams: JobParams{a: GZIP, JobType: DECOMPRESS}}, []StrategyType{QAT, IAA, ISAL}},
+		{"ZstdHigh", PolicyParameters{BufferSize: 100, JobParams: JobParams{a: ZSTD, level: 12}}, []StrategyType{DEFAULT}},
+		{"ZstdLow", PolicyParameters{BufferSize: 100, JobParams: JobParams{a: ZSTD, level: 3}}, []StrategyType{QAT, IAA, ISAL}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := policy(&tc.params); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, received %v", tc.expected, got)
+			}
+		})
+	}
+
+	// A caller changing the returned list does not change the policy.
+	for _, params := range []PolicyParameters{tests[0].params, tests[2].params} {
+		policy(&params)[0] = DEFAULT
+		if got := policy(&params); got[0] == DEFAULT {
+			t.Errorf("TestFail: policy list modified by its caller: %v", got)
+		}
+	}
+
+	// Nor does editing the config after it was compiled.
+	cfg.Rules[0].Tags[0] = "interactive"
+	if got := policy(&tests[0].params); !reflect.DeepEqual(got, []StrategyType{QAT}) {
+		t.Errorf("TestFail: policy changed with its config: %v", got)
+	}
+}
+
+func TestPolicyConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		rule   int
+		field  string
+	}{
+		{"NoStrategies", `{"rules": [{"name": "a"}]}`, 0, "strategies"},
+		{"UnknownStrategy", `{"rules": [{"strategies": ["GPU"]}]}`, 0, "strategies"},
+		{"Duplicate", `{"rules": [{"strategies": ["QAT"]}, {"strategies": ["QAT", "qat"]}]}`, 1, "strategies"},
+		{"SizeRange", `{"rules": [{"min_size": 10, "max_size": 5, "strategies": ["QAT"]}]}`, 0, "max_size"},
+		{"Algorithm", `{"rules": [{"algorithms": ["brotli"], "strategies": ["QAT"]}]}`, 0, "algorithms"},
+		{"Direction", `{"rules": [{"direction": "both", "strategies": ["QAT"]}]}`, 0, "direction"},
+		{"Default", `{"default": ["nope"]}`, -1, "default"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadPolicyConfig(strings.NewReader(tc.config))
+			var cerr *PolicyConfigError
+			if !errors.As(err, &cerr) {
+				t.Fatalf("expected a PolicyConfigError, received '%v'", err)
+			}
+			if cerr.Rule != tc.rule || cerr.Field != tc.field {
+				t.Errorf("expected rule %d field %q, received '%v'", tc.rule, tc.field, err)
+			}
+		})
+	}
+
+	if _, err := ReadPolicyConfig(strings.NewReader(`{"rulez": []}`)); err == nil {
+		t.Error("expected unknown fields to be rejected")
+	}
+}
+
+func TestLoadPolicyConfig(t *testing.T) {
+	m := &Manager{policy: BufferSizePolicy}
+	params := &PolicyParameters{BufferSize: 10}
+
+	if err := m.LoadPolicyConfig(strings.NewReader(`{"default": ["IAA"]}`)); err != nil {
+		t.Fatalf("TestFail: could not load config: '%v'", err)
+	}
+	if got := m.Policy()(params); !reflect.DeepEqual(got, []StrategyType{IAA}) {
+		t.Fatalf("TestFail: policy was not replaced, received %v", got)
+	}
+
+	if err := m.LoadPolicyConfig(strings.NewReader(`{"default": ["GPU"]}`)); err == nil {
+		t.Fatal("TestFail: invalid config was accepted")
+	}
+	if got := m.Policy()(params); !reflect.DeepEqual(got, []StrategyType{IAA}) {
+		t.Errorf("TestFail: invalid config replaced the policy, received %v", got)
+	}
+}
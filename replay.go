@@ -0,0 +1,206 @@
+package dcl
+
+import (
+	"sort"
+	"time"
+)
+
+// StrategyCost models a strategy during a replay. A request costs Setup plus
+// NsPerByte for every byte of its buffer, and holds one of Capacity slots (no
+// limit if zero) while it runs.
+type StrategyCost struct {
+	Setup      time.Duration
+	NsPerByte  float64
+	Capacity   int
+	Algorithms []Algorithm // All algorithms are supported if empty
+}
+
+// CostModel holds the cost of each strategy. Strategies that are missing are
+// treated as not installed.
+type CostModel map[StrategyType]StrategyCost
+
+// DefaultCostModel returns rough costs for a host with every strategy
+// installed. Real numbers depend on the hardware and should be measured.
+func DefaultCostModel() CostModel {
+	return CostModel{
+		QAT:     {Setup: 30 * time.Microsecond, NsPerByte: 0.25, Capacity: MAX_QAT_BINDINGS, Algorithms: QAT_ALGORITHMS},
+		IAA:     {Setup: 10 * time.Microsecond, NsPerByte: 0.4, Capacity: MAX_IAA_BINDINGS, Algorithms: IAA_ALGORITHMS},
+		ISAL:    {Setup: 2 * time.Microsecond, NsPerByte: 1, Algorithms: ISAL_ALGORITHMS},
+		DEFAULT: {Setup: 5 * time.Microsecond, NsPerByte: 5, Algorithms: DEFAULT_ALGORITHMS},
+	}
+}
+
+// ReplayReport summarizes how requests were, or would have been, routed.
+type ReplayReport struct {
+	Policy       string
+	Requests     int
+	Jobs         int // Requests that started a new job and evaluated the policy
+	Strategies   map[StrategyType]int
+	Bytes        map[StrategyType]int64
+	Fallbacks    int // Strategies skipped before one took a request
+	Unserved     int // Requests no strategy could take
+	TotalLatency time.Duration
+	P50          time.Duration
+	P99          time.Duration
+}
+
+func (r *ReplayReport) MeanLatency() time.Duration {
+	served := r.Requests - r.Unserved
+	if served == 0 {
+		return 0
+	}
+	return r.TotalLatency / time.Duration(served)
+}
+
+func newReplayReport(name string) ReplayReport {
+	return ReplayReport{
+		Policy:     name,
+		Strategies: make(map[StrategyType]int),
+		Bytes:      make(map[StrategyType]int64),
+	}
+}
+
+func (r *ReplayReport) setPercentiles(latencies []time.Duration) {
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.P50 = latencies[len(latencies)*50/100]
+	r.P99 = latencies[len(latencies)*99/100]
+}
+
+// SummarizeTrace reports how the requests in a trace were actually routed.
+func SummarizeTrace(records []TraceRecord) ReplayReport {
+	r := newReplayReport("recorded")
+	var latencies []time.Duration
+	for _, rec := range records {
+		r.Requests++
+		if !rec.Continued {
+			r.Jobs++
+		}
+		r.Fallbacks += rec.Fallbacks
+		if rec.Result == TRACE_NO_STRATEGY {
+			r.Unserved++
+			continue
+		}
+		r.Strategies[rec.Strategy]++
+		r.Bytes[rec.Strategy] += int64(rec.Size)
+		r.TotalLatency += rec.Latency
+		latencies = append(latencies, rec.Latency)
+	}
+	r.setPercentiles(latencies)
+	return r
+}
+
+// simulator stands in for the Manager while a policy is replayed, so that
+// load and latency aware policies see the simulated state.
+type simulator struct {
+	cost     CostModel
+	now      time.Time
+	busy     map[StrategyType][]time.Time
+	jobs     map[JobID]StrategyType
+	measured latencyTracker
+}
+
+func (s *simulator) Load(st StrategyType) StrategyLoad {
+	return StrategyLoad{InFlight: len(s.busy[st]), Capacity: s.cost[st].Capacity}
+}
+
+func (s *simulator) latencyEstimate(st StrategyType, dir Direction, size int, q float64) (time.Duration, bool) {
+	return s.measured.estimate(st, dir, size, q)
+}
+
+// advance moves the clock and frees the slots of requests that have finished.
+func (s *simulator) advance(now time.Time) {
+	s.now = now
+	for st, ends := range s.busy {
+		running := ends[:0]
+		for _, end := range ends {
+			if end.After(now) {
+				running = append(running, end)
+			}
+		}
+		s.busy[st] = running
+	}
+}
+
+func (s *simulator) accepts(st StrategyType, rec *TraceRecord) bool {
+	cost, installed := s.cost[st]
+	if !installed {
+		return false
+	}
+	if len(cost.Algorithms) > 0 && !contains(cost.Algorithms, rec.Algorithm) {
+		return false
+	}
+	return cost.Capacity == 0 || len(s.busy[st]) < cost.Capacity
+}
+
+func (s *simulator) run(st StrategyType, rec *TraceRecord) time.Duration {
+	cost := s.cost[st]
+	d := cost.Setup + time.Duration(cost.NsPerByte*float64(rec.Size))
+	s.busy[st] = append(s.busy[st], s.now.Add(d))
+	s.measured.record(st, rec.Direction, rec.Size, d)
+	return d
+}
+
+// Replay routes the requests of a trace with policy and reports what the cost
+// model predicts. Requests arrive at their recorded times and each one holds
+// a slot of its strategy while it runs. Requests that continue a job stay on
+// the strategy the job started on.
+func Replay(records []TraceRecord, name string, policy PolicyFunc, cost CostModel) ReplayReport {
+	ordered := make([]TraceRecord, len(records))
+	copy(ordered, records)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Time.Before(ordered[j].Time) })
+
+	sim := &simulator{
+		cost: cost,
+		busy: make(map[StrategyType][]time.Time),
+		jobs: make(map[JobID]StrategyType),
+	}
+	r := newReplayReport(name)
+	var latencies []time.Duration
+
+	for i := range ordered {
+		rec := &ordered[i]
+		sim.advance(rec.Time)
+		r.Requests++
+
+		var candidates []StrategyType
+		if st, ok := sim.jobs[rec.Job]; ok && rec.Continued {
+			candidates = []StrategyType{st}
+		} else if rec.Continued {
+			candidates = []StrategyType{rec.Strategy}
+		} else {
+			r.Jobs++
+			params := &PolicyParameters{
+				BufferSize: rec.Size,
+				Strategies: GetStrategies(),
+				Tag:        rec.Tag,
+				JobParams:  JobParams{a: rec.Algorithm, level: rec.Level, JobType: rec.Direction, tag: rec.Tag},
+				env:        sim,
+			}
+			candidates = policy(params)
+		}
+
+		served := false
+		for _, st := range candidates {
+			if !st.IsValid() || !sim.accepts(st, rec) {
+				r.Fallbacks++
+				continue
+			}
+			d := sim.run(st, rec)
+			sim.jobs[rec.Job] = st
+			r.Strategies[st]++
+			r.Bytes[st] += int64(rec.Size)
+			r.TotalLatency += d
+			latencies = append(latencies, d)
+			served = true
+			break
+		}
+		if !served {
+			r.Unserved++
+		}
+	}
+	r.setPercentiles(latencies)
+	return r
+}
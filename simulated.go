@@ -0,0 +1,231 @@
+package dcl
+
+import (
+	"bufio"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SIMULATED_READ_AHEAD is the input block of the simulated QAT and IAA
+// handlers.
+const SIMULATED_READ_AHEAD = 64 << 10
+
+// FaultFunc decides whether a request to a SimulatedHandler fails. It is
+// called before every request with the number of earlier requests of the same
+// job, so call is zero when the job starts. A non-nil error is returned as
+// the result of the request.
+type FaultFunc func(job *Job, call int) error
+
+// FailJobs makes the first n jobs fail with err when they start, or every job
+// if n is negative. Use ErrNotAvailable or ErrNotInstalled to exercise the
+// fallback of the Manager.
+func FailJobs(err error, n int) FaultFunc {
+	var failed int64
+	return func(job *Job, call int) error {
+		if call != 0 {
+			return nil
+		}
+		if n >= 0 && atomic.AddInt64(&failed, 1) > int64(n) {
+			return nil
+		}
+		return err
+	}
+}
+
+// FailAfter makes every job fail with err on request number calls, after
+// calls requests have succeeded. With calls above zero this simulates a
+// device failing in the middle of a decompress stream.
+func FailAfter(err error, calls int) FaultFunc {
+	return func(job *Job, call int) error {
+		if call == calls {
+			return err
+		}
+		return nil
+	}
+}
+
+// SimulatedConfig describes a SimulatedHandler. A Capacity of zero means no
+// limit on concurrent jobs. ReadAhead is the input block a decompress job
+// reads at a time, so it may consume bytes past the end of the compressed
+// stream like a device does; zero reads only what the Go codec asks for.
+type SimulatedConfig struct {
+	Algorithms []Algorithm
+	Capacity   int
+	Latency    time.Duration // Added to every request
+	Fault      FaultFunc
+	ReadAhead  int
+}
+
+// SimulatedConfigFor returns the algorithms and capacity of the real handler
+// of strategy s.
+func SimulatedConfigFor(s StrategyType) SimulatedConfig {
+	switch s {
+	case QAT:
+		return SimulatedConfig{Algorithms: QAT_ALGORITHMS, Capacity: MAX_QAT_BINDINGS, ReadAhead: SIMULATED_READ_AHEAD}
+	case IAA:
+		return SimulatedConfig{Algorithms: IAA_ALGORITHMS, Capacity: MAX_IAA_BINDINGS, ReadAhead: SIMULATED_READ_AHEAD}
+	case ISAL:
+		return SimulatedConfig{Algorithms: ISAL_ALGORITHMS}
+	}
+	return SimulatedConfig{Algorithms: DEFAULT_ALGORITHMS}
+}
+
+type simulatedJob struct {
+	softwareJob
+	calls int
+}
+
+func (sj *simulatedJob) request(job *Job, readAhead int) (int, error) {
+	if job.params.JobType == DECOMPRESS && sj.r == nil && readAhead > 0 {
+		r, err := newSoftwareReader(job.params, bufio.NewReaderSize(job.r, readAhead))
+		if err != nil {
+			return 0, err
+		}
+		sj.r = r
+	}
+	return sj.softwareJob.request(job)
+}
+
+// SimulatedHandler stands in for an accelerator on machines without one. It
+// compresses with the Go codecs but follows the rules of a hardware handler:
+// each job holds one of Capacity sessions from its first request until it is
+// released, algorithms outside the configured list and software-only codec
+// options are unsupported, and faults can be injected. Compressed output is
+// flushed on Release, like the QAT handler.
+type SimulatedHandler struct {
+	lock      sync.Mutex
+	cfg       SimulatedConfig
+	installed bool
+	jobs      map[JobID]*simulatedJob
+	requests  int64
+}
+
+func NewSimulatedHandler(cfg SimulatedConfig) *SimulatedHandler {
+	return &SimulatedHandler{
+		cfg:       cfg,
+		installed: true,
+		jobs:      make(map[JobID]*simulatedJob),
+	}
+}
+
+// SimulatedHandlersOption replaces the QAT, IAA and ISAL handlers of a Manager
+// with simulated ones, so that tests exercise the accelerator paths.
+func SimulatedHandlersOption() Option {
+	return func(a applier) error {
+		for _, s := range []StrategyType{QAT, IAA, ISAL} {
+			if err := HandlerOption(s, NewSimulatedHandler(SimulatedConfigFor(s)))(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SetInstalled controls whether requests fail with ErrNotInstalled.
+func (h *SimulatedHandler) SetInstalled(installed bool) {
+	h.lock.Lock()
+	h.installed = installed
+	h.lock.Unlock()
+}
+
+// SetFault replaces the fault injected into requests; nil removes it.
+func (h *SimulatedHandler) SetFault(f FaultFunc) {
+	h.lock.Lock()
+	h.cfg.Fault = f
+	h.lock.Unlock()
+}
+
+func (h *SimulatedHandler) SetLatency(d time.Duration) {
+	h.lock.Lock()
+	h.cfg.Latency = d
+	h.lock.Unlock()
+}
+
+// Requests returns the number of requests the handler has accepted.
+func (h *SimulatedHandler) Requests() int {
+	return int(atomic.LoadInt64(&h.requests))
+}
+
+func (h *SimulatedHandler) Request(job *Job) (n int, err error) {
+	h.lock.Lock()
+	if !h.installed {
+		h.lock.Unlock()
+		return 0, ErrNotInstalled
+	}
+	if !contains(h.cfg.Algorithms, job.params.a) || job.params.softwareOnly() {
+		h.lock.Unlock()
+		return 0, ErrUnsupported
+	}
+	sj, present := h.jobs[job.id]
+	if !present {
+		if h.cfg.Capacity > 0 && len(h.jobs) >= h.cfg.Capacity {
+			h.lock.Unlock()
+			return 0, ErrNotAvailable
+		}
+		sj = &simulatedJob{}
+	}
+	call := sj.calls
+	sj.calls++
+	fault, latency, readAhead := h.cfg.Fault, h.cfg.Latency, h.cfg.ReadAhead
+	if fault != nil {
+		if err = fault(job, call); err != nil {
+			h.lock.Unlock()
+			return 0, err
+		}
+	}
+	if !present {
+		h.jobs[job.id] = sj
+	}
+	h.lock.Unlock()
+
+	atomic.AddInt64(&h.requests, 1)
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	n, err = sj.request(job, readAhead)
+	if !present && err == ErrUnsupported {
+		h.lock.Lock()
+		delete(h.jobs, job.id)
+		h.lock.Unlock()
+	}
+	return n, err
+}
+
+func (h *SimulatedHandler) Release(id JobID) (err error) {
+	h.lock.Lock()
+	sj, present := h.jobs[id]
+	delete(h.jobs, id)
+	h.lock.Unlock()
+	if !present {
+		return ErrJobNotFound
+	}
+	return sj.close()
+}
+
+func (h *SimulatedHandler) Flush(id JobID) error {
+	h.lock.Lock()
+	sj, present := h.jobs[id]
+	h.lock.Unlock()
+	if !present {
+		return ErrJobNotFound
+	}
+	return sj.flush()
+}
+
+func (h *SimulatedHandler) Load() HandlerLoad {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return HandlerLoad{InFlight: len(h.jobs), Capacity: h.cfg.Capacity}
+}
+
+func (h *SimulatedHandler) Installed() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.installed
+}
+
+func (h *SimulatedHandler) Supports(a Algorithm) bool {
+	return contains(h.cfg.Algorithms, a)
+}
@@ -0,0 +1,166 @@
+package dcl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulatedHandlers(t *testing.T) {
+	m, err := NewManager(SimulatedHandlersOption())
+	if err != nil {
+		t.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	qat := m.getHandler(QAT).(*SimulatedHandler)
+	iaa := m.getHandler(IAA).(*SimulatedHandler)
+	m.SetPolicy(fixed(QAT, IAA, DEFAULT))
+
+	input := strings.Repeat("Hello World\n", 5000)
+	for _, alg := range QAT_ALGORITHMS {
+		b := new(bytes.Buffer)
+		w := NewWriter(b)
+		w.Apply(ManagerOption(m), AlgorithmOption(alg))
+		if _, err := w.Write([]byte(input)); err != nil {
+			t.Fatalf("TestFail: %v write failed with '%v'", alg, err)
+		}
+		if s, _ := w.LastStrategy(); s != QAT {
+			t.Errorf("TestFail: %v compressed by %v", alg, s)
+		}
+
+		r := NewReader(b)
+		r.Apply(ManagerOption(m), AlgorithmOption(alg))
+		out, err := io.ReadAll(r)
+		if err != nil || string(out) != input {
+			t.Errorf("TestFail: %v round trip failed with '%v'", alg, err)
+		}
+	}
+	if qat.Load().InFlight != 0 {
+		t.Errorf("TestFail: QAT sessions leaked: %+v", qat.Load())
+	}
+
+	// Fill QAT with open decompress streams, the next one goes to IAA.
+	compressed := new(bytes.Buffer)
+	w := NewWriter(compressed)
+	w.Apply(ManagerOption(m))
+	w.Write([]byte(input))
+	p := make([]byte, 10)
+	for i := 0; i <= MAX_QAT_BINDINGS; i++ {
+		r := NewReader(bytes.NewReader(compressed.Bytes()))
+		r.Apply(ManagerOption(m))
+		if _, err := r.Read(p); err != nil {
+			t.Fatalf("TestFail: read %d failed with '%v'", i, err)
+		}
+		if s, _ := r.LastStrategy(); i < MAX_QAT_BINDINGS && s != QAT || i == MAX_QAT_BINDINGS && s != IAA {
+			t.Errorf("TestFail: stream %d read by %v", i, s)
+		}
+	}
+	if l := qat.Load(); l.InFlight != MAX_QAT_BINDINGS || l.Capacity != MAX_QAT_BINDINGS {
+		t.Errorf("TestFail: unexpected QAT load %+v", l)
+	}
+
+	// Injected faults.
+	qat.SetInstalled(false)
+	iaa.SetFault(FailJobs(ErrNotAvailable, 1))
+	w = NewWriter(new(bytes.Buffer))
+	w.Apply(ManagerOption(m))
+	w.Write([]byte(input))
+	if s, _ := w.LastStrategy(); s != DEFAULT || w.Stats().Fallbacks != 2 {
+		t.Errorf("TestFail: expected fallback to default, received %v with %d fallbacks", s, w.Stats().Fallbacks)
+	}
+	w.Write([]byte(input))
+	if s, _ := w.LastStrategy(); s != IAA {
+		t.Errorf("TestFail: expected IAA after its fault cleared, received %v", s)
+	}
+
+	failure := errors.New("device lost")
+	iaa.SetFault(FailAfter(failure, 1))
+	r := NewReader(bytes.NewReader(compressed.Bytes()))
+	r.Apply(ManagerOption(m))
+	if _, err := r.Read(p); err != nil {
+		t.Fatalf("TestFail: first read failed with '%v'", err)
+	}
+	if _, err := r.Read(p); err != failure {
+		t.Errorf("TestFail: expected mid-stream failure, received '%v'", err)
+	}
+	if l := iaa.Load(); l.InFlight != 1 {
+		t.Errorf("TestFail: failed stream kept its IAA session: %+v", l)
+	}
+
+	iaa.SetFault(nil)
+	iaa.SetLatency(5 * time.Millisecond)
+	w.Write([]byte(input))
+	if w.Stats().Strategies[IAA].Time < 5*time.Millisecond {
+		t.Errorf("TestFail: simulated latency not applied: %v", w.Stats().Strategies[IAA].Time)
+	}
+
+	if _, err := NewManager(HandlerOption(StrategyType(9), qat)); err != ErrParamStrategy {
+		t.Errorf("TestFail: invalid strategy accepted: '%v'", err)
+	}
+	if err := NewWriter(nil).Apply(HandlerOption(QAT, qat)); err != ErrApplyInvalidType {
+		t.Errorf("TestFail: handler applied to a Writer: '%v'", err)
+	}
+}
+
+func TestHandlerOptionWhileRunning(t *testing.T) {
+	m, err := NewManager(SimulatedHandlersOption())
+	if err != nil {
+		t.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	m.SetPolicy(fixed(QAT, DEFAULT))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			m.Apply(HandlerOption(QAT, NewSimulatedHandler(SimulatedConfigFor(QAT))))
+		}
+	}()
+	w := NewWriter(io.Discard)
+	w.Apply(ManagerOption(m))
+	for i := 0; i < 100; i++ {
+		if _, err := w.Write([]byte("Hello World")); err != nil {
+			t.Fatalf("TestFail: write failed with '%v'", err)
+		}
+	}
+	<-done
+}
+
+func TestSoftwareOnlyOptions(t *testing.T) {
+	m, err := NewManager(SimulatedHandlersOption())
+	if err != nil {
+		t.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	m.SetPolicy(fixed(QAT, DEFAULT))
+	input := strings.Repeat("Hello World\n", 1000)
+	dict := []byte("Hello World\n")
+
+	b := new(bytes.Buffer)
+	w := NewWriter(b)
+	w.Apply(ManagerOption(m), AlgorithmOption(DEFLATE), DictionaryOption(dict))
+	if _, err := w.Write([]byte(input)); err != nil {
+		t.Fatalf("TestFail: write failed with '%v'", err)
+	}
+	if s, _ := w.LastStrategy(); s != DEFAULT {
+		t.Errorf("TestFail: job with a dictionary ran on %v", s)
+	}
+	r := NewReader(bytes.NewReader(b.Bytes()))
+	r.Apply(ManagerOption(m), AlgorithmOption(DEFLATE), DictionaryOption(dict))
+	if out, err := io.ReadAll(r); err != nil || string(out) != input {
+		t.Errorf("TestFail: read back %d bytes, '%v'", len(out), err)
+	}
+
+	// Options the Go codec lacks leave no strategy.
+	w = NewWriter(io.Discard)
+	w.Apply(ManagerOption(m), AlgorithmOption(LZ4), WindowSizeOption(1<<16))
+	if _, err := w.Write([]byte(input)); err != ErrNoWorkingStrategies {
+		t.Errorf("TestFail: lz4 with a window size returned '%v'", err)
+	}
+	if err := w.Apply(WindowSizeOption(-1)); err != ErrParamWindowSize {
+		t.Errorf("TestFail: negative window size returned '%v'", err)
+	}
+	if err := w.Apply(DictionaryOption(nil)); err != ErrParamDictionary {
+		t.Errorf("TestFail: empty dictionary returned '%v'", err)
+	}
+}
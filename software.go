@@ -0,0 +1,166 @@
+package dcl
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// lz4Level maps a 1-9 compression level onto the lz4 levels. Levels below 1
+// select the fast compressor.
+func lz4Level(level int) lz4.CompressionLevel {
+	switch {
+	case level < 1:
+		return lz4.Fast
+	case level > 9:
+		return lz4.Level9
+	}
+	return lz4.CompressionLevel(1 << (8 + level))
+}
+
+// newSoftwareWriter returns a Go compressor for the algorithm, level and codec
+// options of jp writing to w. The stream is only complete once it is closed.
+func newSoftwareWriter(jp JobParams, w io.Writer) (io.WriteCloser, error) {
+	var dict []byte
+	if len(jp.dicts) > 0 {
+		dict = jp.dicts[0]
+	}
+	if jp.window != 0 && jp.a != ZSTD {
+		return nil, ErrUnsupported
+	}
+	switch jp.a {
+	case DEFLATE:
+		fw, err := flate.NewWriterDict(w, jp.level, dict)
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		return fw, nil
+	case GZIP:
+		if dict != nil {
+			return nil, ErrUnsupported
+		}
+		gw, err := gzip.NewWriterLevel(w, jp.level)
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		return gw, nil
+	case LZ4:
+		if dict != nil {
+			return nil, ErrUnsupported
+		}
+		lw := lz4.NewWriter(w)
+		if err := lw.Apply(lz4.CompressionLevelOption(lz4Level(jp.level))); err != nil {
+			return nil, ErrUnsupported
+		}
+		return lw, nil
+	case ZSTD:
+		options := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(jp.level))}
+		if jp.window != 0 {
+			options = append(options, zstd.WithWindowSize(jp.window))
+		}
+		if dict != nil {
+			options = append(options, zstd.WithEncoderDict(dict))
+		}
+		zw, err := zstd.NewWriter(w, options...)
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		return zw, nil
+	}
+	return nil, ErrUnsupported
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// newSoftwareReader returns a Go decompressor for the algorithm and codec
+// options of jp reading from r. Gzip streams may hold several members.
+func newSoftwareReader(jp JobParams, r io.Reader) (io.ReadCloser, error) {
+	if jp.window != 0 && jp.a != ZSTD {
+		return nil, ErrUnsupported
+	}
+	if len(jp.dicts) > 0 && jp.a != DEFLATE && jp.a != ZSTD {
+		return nil, ErrUnsupported
+	}
+	switch jp.a {
+	case DEFLATE:
+		if len(jp.dicts) > 0 {
+			return flate.NewReaderDict(r, jp.dicts[0]), nil
+		}
+		return flate.NewReader(r), nil
+	case GZIP:
+		return gzip.NewReader(r)
+	case LZ4:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	case ZSTD:
+		options := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if jp.window != 0 {
+			options = append(options, zstd.WithDecoderMaxWindow(uint64(jp.window)))
+		}
+		if len(jp.dicts) > 0 {
+			options = append(options, zstd.WithDecoderDicts(jp.dicts...))
+		}
+		zr, err := zstd.NewReader(r, options...)
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		return zstdReadCloser{zr}, nil
+	}
+	return nil, ErrUnsupported
+}
+
+// softwareJob holds the codec of one job of a handler built on the Go codecs,
+// created by its first request and closed when the job is released.
+type softwareJob struct {
+	w io.WriteCloser
+	r io.ReadCloser
+}
+
+func (sj *softwareJob) request(job *Job) (n int, err error) {
+	if job.params.JobType == COMPRESS {
+		if sj.w == nil {
+			w, err := newSoftwareWriter(job.params, job.w)
+			if err != nil {
+				return 0, err
+			}
+			sj.w = w
+		}
+		return sj.w.Write(job.p)
+	}
+	if sj.r == nil {
+		r, err := newSoftwareReader(job.params, job.r)
+		if err != nil {
+			return 0, err
+		}
+		sj.r = r
+	}
+	return sj.r.Read(job.p)
+}
+
+// flush writes out the data compressed so far.
+func (sj *softwareJob) flush() error {
+	if f, ok := sj.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// close completes the compressed stream, if any, and frees the codec.
+func (sj *softwareJob) close() (err error) {
+	if sj.w != nil {
+		err = sj.w.Close()
+	}
+	if sj.r != nil {
+		sj.r.Close()
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package dcl
+
+import (
+	"io"
+	"time"
+)
+
+// Stats describes the requests made by a Reader or Writer since it was
+// created or last reset. BytesIn is the data consumed and BytesOut the data
+// produced, so a Writer counts uncompressed bytes in and compressed bytes out,
+// and a Reader the reverse.
+type Stats struct {
+	Direction  Direction
+	BytesIn    int64
+	BytesOut   int64
+	Jobs       int // Jobs started, each evaluating the policy once
+	Requests   int
+	Fallbacks  int // Strategies skipped before one took a job
+	Errors     int // Requests that failed, including ones no strategy took
+	Time       time.Duration
+	Strategies map[StrategyType]StrategyStats
+}
+
+// StrategyStats is the share of a Stats served by one strategy.
+type StrategyStats struct {
+	Requests int
+	BytesIn  int64
+	BytesOut int64
+	Time     time.Duration
+}
+
+// Ratio returns the compression ratio, uncompressed size over compressed
+// size, or zero before any compressed data has been seen.
+func (s Stats) Ratio() float64 {
+	plain, packed := s.BytesIn, s.BytesOut
+	if s.Direction == DECOMPRESS {
+		plain, packed = packed, plain
+	}
+	if packed == 0 {
+		return 0
+	}
+	return float64(plain) / float64(packed)
+}
+
+func (s Stats) clone() Stats {
+	strategies := make(map[StrategyType]StrategyStats, len(s.Strategies))
+	for k, v := range s.Strategies {
+		strategies[k] = v
+	}
+	s.Strategies = strategies
+	return s
+}
+
+// stats accumulates the outcome of the requests of a Reader or Writer.
+type stats struct {
+	s      Stats
+	last   StrategyType
+	served bool
+}
+
+func (st *stats) add(res *jobResult) {
+	st.s.Requests++
+	if !res.continued {
+		st.s.Jobs++
+	}
+	st.s.Fallbacks += res.fallbacks
+	if !res.served || (res.err != nil && res.err != io.EOF) {
+		st.s.Errors++
+	}
+	if !res.served {
+		return
+	}
+	if res.err == nil || res.err == io.EOF {
+		st.last, st.served = res.strategy, true
+	}
+	st.s.BytesIn += res.bytesIn
+	st.s.BytesOut += res.bytesOut
+	st.s.Time += res.latency
+	if st.s.Strategies == nil {
+		st.s.Strategies = make(map[StrategyType]StrategyStats)
+	}
+	ss := st.s.Strategies[res.strategy]
+	ss.Requests++
+	ss.BytesIn += res.bytesIn
+	ss.BytesOut += res.bytesOut
+	ss.Time += res.latency
+	st.s.Strategies[res.strategy] = ss
+}
+
+// output counts data a handler wrote outside of a request, when a stream was
+// flushed or closed.
+func (st *stats) output(n int64) {
+	if n == 0 || !st.served {
+		return
+	}
+	st.s.BytesOut += n
+	ss := st.s.Strategies[st.last]
+	ss.BytesOut += n
+	st.s.Strategies[st.last] = ss
+}
+
+func (st *stats) get(dir Direction) Stats {
+	s := st.s.clone()
+	s.Direction = dir
+	return s
+}
@@ -0,0 +1,91 @@
+package dcl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriterStats(t *testing.T) {
+	m := newSimulatedManager(t, IAA)
+	m.SetPolicy(fixed(IAA, DEFAULT))
+	input := []byte(strings.Repeat("Hello World\n", 1000))
+	b := new(bytes.Buffer)
+	z := NewWriter(b)
+	z.m = m
+	if _, ok := z.LastStrategy(); ok {
+		t.Errorf("TestFail: last strategy reported before any write")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := z.Write(input); err != nil {
+			t.Fatalf("TestFail: write failed with '%v'", err)
+		}
+	}
+
+	s := z.Stats()
+	if s.Direction != COMPRESS || s.Jobs != 2 || s.Requests != 2 || s.Fallbacks != 2 || s.Errors != 0 {
+		t.Errorf("TestFail: unexpected counts %+v", s)
+	}
+	if s.BytesIn != int64(2*len(input)) || s.BytesOut != int64(b.Len()) || s.Ratio() <= 1 {
+		t.Errorf("TestFail: unexpected sizes in=%d out=%d ratio=%f", s.BytesIn, s.BytesOut, s.Ratio())
+	}
+	if d := s.Strategies[DEFAULT]; d.Requests != 2 || d.BytesOut != s.BytesOut || len(s.Strategies) != 1 {
+		t.Errorf("TestFail: unexpected breakdown %+v", s.Strategies)
+	}
+	if st, ok := z.LastStrategy(); !ok || st != DEFAULT {
+		t.Errorf("TestFail: last strategy %v, %v", st, ok)
+	}
+
+	s.Strategies[QAT] = StrategyStats{}
+	if _, ok := z.Stats().Strategies[QAT]; ok {
+		t.Errorf("TestFail: Stats returned shared state")
+	}
+	z.Reset(b)
+	if s := z.Stats(); s.Requests != 0 || len(s.Strategies) != 0 {
+		t.Errorf("TestFail: stats not reset: %+v", s)
+	}
+}
+
+func TestReaderStats(t *testing.T) {
+	m := newTestManager()
+	m.SetPolicy(fixed(DEFAULT))
+	compressed := new(bytes.Buffer)
+	gw := gzip.NewWriter(compressed)
+	gw.Write([]byte("Hello World"))
+	gw.Close()
+	size := compressed.Len()
+
+	r := NewReader(compressed)
+	r.m = m
+	p := make([]byte, 100)
+	n, err := r.Read(p)
+	for err == nil {
+		_, err = r.Read(p)
+	}
+	if err != io.EOF || n != 11 {
+		t.Fatalf("TestFail: read %d bytes, ended with '%v'", n, err)
+	}
+
+	s := r.Stats()
+	if s.Direction != DECOMPRESS || s.Jobs != 1 || s.Requests < 1 || s.BytesIn != int64(size) || s.BytesOut != 11 {
+		t.Errorf("TestFail: unexpected stats %+v", s)
+	}
+	if s.Ratio() != 11/float64(size) {
+		t.Errorf("TestFail: unexpected ratio %f", s.Ratio())
+	}
+
+	// A failed request is counted but not reported as the last strategy.
+	r = NewReader(strings.NewReader("not compressed"))
+	r.m = m
+	if _, err := r.Read(p); err == nil {
+		t.Fatalf("TestFail: garbage read without an error")
+	}
+	if s := r.Stats(); s.Errors != 1 {
+		t.Errorf("TestFail: unexpected stats %+v", s)
+	}
+	if st, ok := r.LastStrategy(); ok {
+		t.Errorf("TestFail: failed request reported as last strategy %v", st)
+	}
+}
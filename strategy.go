@@ -1,15 +1,13 @@
 package dcl
 
 import (
-	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 
 	"github.com/intel/qatgo/qatzip"
-	"github.com/klauspost/compress/zstd"
-	"github.com/pierrec/lz4/v4"
 
 	isal "github.com/intel/ISALgo"
 	ixl "github.com/intel/ixl-go/compress"
@@ -71,6 +69,25 @@ func (a Algorithm) String() (str string) {
 	}
 	return str
 }
+
+func ParseAlgorithm(str string) (Algorithm, error) {
+	for _, a := range DEFAULT_ALGORITHMS {
+		if strings.EqualFold(a.String(), str) {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown algorithm %q", str)
+}
+
+func (a Algorithm) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Algorithm) UnmarshalText(text []byte) (err error) {
+	*a, err = ParseAlgorithm(string(text))
+	return err
+}
+
 func (a Algorithm) GetQATSymbol() (qatzip.Algorithm, error) {
 	switch a {
 	case DEFLATE:
@@ -108,6 +125,24 @@ func (s StrategyType) String() string {
 	return "default"
 }
 
+func ParseStrategyType(str string) (StrategyType, error) {
+	for _, s := range strategyBank {
+		if strings.EqualFold(s.String(), str) {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown strategy %q", str)
+}
+
+func (s StrategyType) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *StrategyType) UnmarshalText(text []byte) (err error) {
+	*s, err = ParseStrategyType(string(text))
+	return err
+}
+
 func (s StrategyType) IsValid() bool {
 	switch s {
 	case QAT, ISAL, IAA, DEFAULT:
@@ -122,18 +157,71 @@ func GetStrategies() []StrategyType {
 	return strategyBank
 }
 
+// Handler runs jobs for one strategy. The Manager calls Request once or more
+// for a job and then Release; a handler keeps per-job state, such as a
+// hardware session or a codec, keyed by the job ID in between.
+//
+// For a compress job, Request compresses job.Buffer() to job.Writer() and
+// returns the number of input bytes consumed, which is len(job.Buffer())
+// unless err is set. Several requests form a single stream, and output may be
+// held back until Release, after which the stream is complete.
+//
+// For a decompress job, Request reads from job.Reader() and fills
+// job.Buffer() like io.Reader.Read: it returns the bytes placed in the buffer
+// and io.EOF, possibly with n > 0, once the stream has ended.
+//
+// Before a job starts, Request returns ErrNotInstalled if the strategy is
+// missing, ErrUnsupported for algorithms, levels or codec options such as
+// DictionaryOption it cannot handle, and ErrNotAvailable when it has no free
+// session; the Manager then tries the next strategy. Any other error fails
+// the job. Release frees the job; the Manager calls it after the last request
+// of every job that started, including ones that failed. It returns
+// ErrJobNotFound for IDs the handler does not hold, such as jobs already
+// released.
+//
+// The dcltest package checks a Handler against this contract.
 type Handler interface {
 	Request(job *Job) (n int, err error)
 	Release(id JobID) (err error)
 }
 
+// Flusher is implemented by handlers that can write out the compressed data
+// of a job without ending its stream, as needed by Writer.Flush.
+type Flusher interface {
+	Flush(id JobID) error
+}
+
+// HandlerLoad is a point-in-time view of how busy a handler is. A Capacity of
+// zero means the handler has no fixed limit on concurrent jobs.
+type HandlerLoad struct {
+	InFlight int
+	Capacity int
+}
+
+// LoadReporter is implemented by handlers that can report their load.
+type LoadReporter interface {
+	Load() HandlerLoad
+}
+
+// CapabilityReporter is implemented by handlers that can tell, without
+// running a job, whether they are installed and which algorithms they support.
+type CapabilityReporter interface {
+	Installed() bool
+	Supports(a Algorithm) bool
+}
+
+// DefaultHandler compresses with the Go codecs. It is always installed and
+// has no limit on concurrent jobs.
 type DefaultHandler struct {
-	algs []Algorithm
+	algs     []Algorithm
+	jobs     map[JobID]*softwareJob
+	jobsLock sync.Mutex
 }
 
 func NewDefaultHandler() (h *DefaultHandler) {
 	h = &DefaultHandler{
 		algs: DEFAULT_ALGORITHMS,
+		jobs: make(map[JobID]*softwareJob),
 	}
 	return h
 }
@@ -142,113 +230,57 @@ func (h *DefaultHandler) Request(job *Job) (n int, err error) {
 	if !contains(h.algs, job.params.a) {
 		return 0, ErrUnsupported
 	}
-
-	if job.params.JobType == COMPRESS {
-		switch job.params.a {
-		case GZIP:
-			gw, err := gzip.NewWriterLevel(job.w, job.params.level)
-			if err != nil {
-				return 0, ErrUnsupported
-			}
-			defer gw.Close()
-
-			n, err = gw.Write(job.p)
-			if err != nil {
-				return n, err
-			}
-			if err := gw.Close(); err != nil {
-				return n, err
-			}
-
-		case LZ4:
-			lz4w := lz4.NewWriter(job.w)
-			if err := lz4w.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(job.params.level))); err != nil {
-				return 0, ErrUnsupported
-			}
-			defer lz4w.Close()
-
-			n, err = lz4w.Write(job.p)
-			if err != nil {
-				return n, err
-			}
-			if err := lz4w.Close(); err != nil {
-				return n, err
-			}
-
-		case ZSTD:
-			zstdw, err := zstd.NewWriter(job.w, zstd.WithEncoderLevel(zstd.EncoderLevel(job.params.level)))
-			if err != nil {
-				return 0, ErrUnsupported
-			}
-			defer zstdw.Close()
-
-			n, err = zstdw.Write(job.p)
-			if err != nil {
-				return n, err
-			}
-			if err := zstdw.Close(); err != nil {
-				return n, err
-			}
-
-		default:
-			return 0, fmt.Errorf("unsupported compression algorithm")
-		}
+	h.jobsLock.Lock()
+	sj, present := h.jobs[job.id]
+	if !present {
+		sj = &softwareJob{}
+		h.jobs[job.id] = sj
+	}
+	h.jobsLock.Unlock()
+
+	n, err = sj.request(job)
+	if !present && err == ErrUnsupported {
+		// The codec rejected the parameters, the job never started.
+		h.jobsLock.Lock()
+		delete(h.jobs, job.id)
+		h.jobsLock.Unlock()
 	}
+	return n, err
+}
 
-	if job.params.JobType == DECOMPRESS {
-		switch job.params.a {
-		case GZIP:
-			gr, err := gzip.NewReader(job.r)
-			if err != nil {
-				return 0, err
-			}
-			defer gr.Close()
-
-			n, err = gr.Read(job.p)
-			if err != nil {
-				return n, err
-			}
-			if err := gr.Close(); err != nil {
-				return n, err
-			}
-
-		case LZ4:
-			lz4r := lz4.NewReader(job.r)
-			// defer lz4r.Close()
-
-			n, err = lz4r.Read(job.p)
-			if err != nil {
-				return n, err
-			}
-			// if err := lz4r.Close(); err != nil {
-			// 	return n, err
-			// }
-
-		case ZSTD:
-			zstdr, err := zstd.NewReader(job.r)
-			if err != nil {
-				return 0, err
-			}
-			defer zstdr.Close()
-
-			n, err = zstdr.Read(job.p)
-			if err != nil {
-				return n, err
-			}
-			// if err := zstdr.Close(); err != nil {
-			// 	return n, err
-			// }
+func (h *DefaultHandler) Release(id JobID) (err error) {
+	h.jobsLock.Lock()
+	sj, present := h.jobs[id]
+	delete(h.jobs, id)
+	h.jobsLock.Unlock()
+	if !present {
+		return ErrJobNotFound
+	}
+	return sj.close()
+}
 
-		default:
-			return 0, fmt.Errorf("unsupported decompression algorithm")
-		}
+func (h *DefaultHandler) Flush(id JobID) error {
+	h.jobsLock.Lock()
+	sj, present := h.jobs[id]
+	h.jobsLock.Unlock()
+	if !present {
+		return ErrJobNotFound
 	}
+	return sj.flush()
+}
 
-	return n, nil
+func (h *DefaultHandler) Load() HandlerLoad {
+	h.jobsLock.Lock()
+	defer h.jobsLock.Unlock()
+	return HandlerLoad{InFlight: len(h.jobs)}
 }
 
-func (h *DefaultHandler) Release(id JobID) (err error) {
-	return nil
+func (h *DefaultHandler) Installed() bool {
+	return true
+}
+
+func (h *DefaultHandler) Supports(a Algorithm) bool {
+	return contains(h.algs, a)
 }
 
 type IAAHandler struct {
@@ -272,41 +304,41 @@ func (h *IAAHandler) Request(job *Job) (n int, err error) {
 	if !ixl.Ready() {
 		return 0, ErrNotInstalled
 	}
-	if !contains(h.algs, job.params.a) {
+	if !contains(h.algs, job.params.a) || job.params.softwareOnly() {
 		return 0, ErrUnsupported
 	}
 	h.jobsLock.Lock()
-	if len(h.jobs) >= MAX_IAA_BINDINGS {
+	nw, writing := h.jobs[job.id]
+	iar, reading := h.readjobs[job.id]
+	if !writing && !reading && len(h.jobs)+len(h.readjobs) >= MAX_IAA_BINDINGS {
 		h.jobsLock.Unlock()
 		return 0, ErrNotAvailable
 	}
 
 	if job.params.JobType == COMPRESS {
-		var nw *ixl.BufWriter
-		if job.params.a == DEFLATE {
-			if nw, err = ixl.NewDeflateWriter(job.w); err != nil {
-				return 0, err
+		if !writing {
+			if job.params.a == DEFLATE {
+				if nw, err = ixl.NewDeflateWriter(job.w); err != nil {
+					h.jobsLock.Unlock()
+					return 0, err
+				}
+			} else {
+				nw = ixl.NewGzipWriter(job.w)
 			}
-		} else if job.params.a == GZIP {
-			nw = ixl.NewGzipWriter(job.w)
-		} else {
-			return 0, errors.New("does not support algorithm")
+			h.jobs[job.id] = nw
 		}
-		h.jobs[job.id] = nw
 		h.jobsLock.Unlock()
 		n, err = nw.Write(job.p)
 	}
 
 	if job.params.JobType == DECOMPRESS {
-		var iar *ixl.Inflate
-		if job.params.a == DEFLATE || job.params.a == GZIP {
+		if !reading {
 			if iar, err = ixl.NewInflate(job.r); err != nil {
+				h.jobsLock.Unlock()
 				return 0, err
 			}
-		} else {
-			return 0, errors.New("does not support algorithm")
+			h.readjobs[job.id] = iar
 		}
-		h.readjobs[job.id] = iar
 		h.jobsLock.Unlock()
 		n, err = iar.Read(job.p)
 	}
@@ -314,20 +346,39 @@ func (h *IAAHandler) Request(job *Job) (n int, err error) {
 }
 
 func (h *IAAHandler) Release(id JobID) (err error) {
-	w, exists := h.jobs[id]
-	if !exists {
-		return errors.New("could not find the job")
-	}
-	err = w.Close()
-	h.jobsLock.Lock() // This may be an unnecessary lock since ids are unique
-	defer h.jobsLock.Unlock()
+	h.jobsLock.Lock()
+	w, writing := h.jobs[id]
+	_, reading := h.readjobs[id]
 	delete(h.jobs, id)
+	delete(h.readjobs, id)
+	h.jobsLock.Unlock()
+	if !writing && !reading {
+		return ErrJobNotFound
+	}
+	if writing {
+		err = w.Close()
+	}
 	return err
 }
 
+func (h *IAAHandler) Load() HandlerLoad {
+	h.jobsLock.Lock()
+	defer h.jobsLock.Unlock()
+	return HandlerLoad{InFlight: len(h.jobs) + len(h.readjobs), Capacity: MAX_IAA_BINDINGS}
+}
+
+func (h *IAAHandler) Installed() bool {
+	return ixl.Ready()
+}
+
+func (h *IAAHandler) Supports(a Algorithm) bool {
+	return contains(h.algs, a)
+}
+
 type ISALHandler struct {
 	jobs     map[JobID]*isal.Writer
 	readjobs map[JobID]*isal.Reader
+	jobsLock sync.Mutex
 	algs     []Algorithm
 }
 
@@ -345,7 +396,7 @@ func (h *ISALHandler) Request(job *Job) (n int, err error) {
 	if !isal.Ready() {
 		return 0, ErrNotInstalled
 	}
-	if !contains(h.algs, job.params.a) {
+	if !contains(h.algs, job.params.a) || job.params.softwareOnly() {
 		return 0, ErrUnsupported
 	}
 
@@ -354,29 +405,35 @@ func (h *ISALHandler) Request(job *Job) (n int, err error) {
 
 	switch job.params.JobType {
 	case COMPRESS:
+		h.jobsLock.Lock()
 		if val, ok := h.jobs[job.id]; ok {
 			isaw = val
 		} else {
 			isaw, err = isal.NewWriterLevel(job.w, job.params.level)
 			if err != nil {
+				h.jobsLock.Unlock()
 				return 0, ErrUnsupported
 			}
 		}
 		h.jobs[job.id] = isaw
+		h.jobsLock.Unlock()
 		n, err = isaw.Write(job.p)
 		if err != nil {
 			return n, err
 		}
 	case DECOMPRESS:
+		h.jobsLock.Lock()
 		if val, ok := h.readjobs[job.id]; ok {
 			isar = val
 		} else {
 			isar, err = isal.NewReader(job.r)
 			if err != nil {
+				h.jobsLock.Unlock()
 				return 0, err
 			}
 		}
 		h.readjobs[job.id] = isar
+		h.jobsLock.Unlock()
 		n, err = isar.Read(job.p)
 		if err != nil {
 			return n, err
@@ -387,26 +444,42 @@ func (h *ISALHandler) Request(job *Job) (n int, err error) {
 }
 
 func (h *ISALHandler) Release(id JobID) (err error) {
+	h.jobsLock.Lock()
 	w, writematch := h.jobs[id]
 	r, readmatch := h.readjobs[id]
+	delete(h.jobs, id)
+	delete(h.readjobs, id)
+	h.jobsLock.Unlock()
 
 	if !writematch && !readmatch {
-		return errors.New("could not find the job")
+		return ErrJobNotFound
 	}
 
 	if writematch {
 		err = w.Close()
-		delete(h.jobs, id)
 	}
 
 	if readmatch {
 		err = r.Close()
-		delete(h.readjobs, id)
 	}
 
 	return err
 }
 
+func (h *ISALHandler) Load() HandlerLoad {
+	h.jobsLock.Lock()
+	defer h.jobsLock.Unlock()
+	return HandlerLoad{InFlight: len(h.jobs) + len(h.readjobs)}
+}
+
+func (h *ISALHandler) Installed() bool {
+	return isal.Ready()
+}
+
+func (h *ISALHandler) Supports(a Algorithm) bool {
+	return contains(h.algs, a)
+}
+
 type QatHandler struct {
 	jobs     map[JobID]*QATJob
 	jobsLock sync.Mutex
@@ -440,16 +513,22 @@ func (h *QatHandler) Request(job *Job) (n int, err error) {
 	if !h.ready() {
 		return 0, ErrNotInstalled
 	}
-	if !contains(h.algs, job.params.a) {
+	if !contains(h.algs, job.params.a) || job.params.softwareOnly() {
 		return 0, ErrUnsupported
 	}
 	var qat *QATJob
 	sym, _ := job.params.a.GetQATSymbol()
 
-	if val, ok := h.jobs[job.id]; ok {
+	h.jobsLock.Lock()
+	val, ok := h.jobs[job.id]
+	h.jobsLock.Unlock()
+
+	if ok {
 		qat = val
 	} else {
-		qat, err = h.newQatJob(job, DIRECT)
+		if qat, err = h.newQatJob(job, DIRECT); err != nil {
+			return 0, err
+		}
 		if job.params.JobType == COMPRESS {
 			if err := qat.w.Apply(
 				qatzip.AlgorithmOption(qatzip.Algorithm(sym)),
@@ -610,7 +689,23 @@ func (h *QatHandler) newQatJob(job *Job, mode QatMode) (qat *QATJob, err error)
 	return qat, nil
 }
 
+func (h *QatHandler) Load() HandlerLoad {
+	h.jobsLock.Lock()
+	defer h.jobsLock.Unlock()
+	return HandlerLoad{InFlight: len(h.jobs), Capacity: MAX_QAT_BINDINGS}
+}
+
+func (h *QatHandler) Installed() bool {
+	return h.ready()
+}
+
+func (h *QatHandler) Supports(a Algorithm) bool {
+	return contains(h.algs, a)
+}
+
 func (h *QatHandler) findJob(id JobID) (*QATJob, error) {
+	h.jobsLock.Lock()
+	defer h.jobsLock.Unlock()
 	for _, qat := range h.jobs {
 		if qat.job.id == id {
 			return qat, nil
@@ -0,0 +1,98 @@
+package dcl
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStreamOption(t *testing.T) {
+	m, err := NewManager(SimulatedHandlersOption())
+	if err != nil {
+		t.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	m.SetPolicy(fixed(QAT, DEFAULT))
+	qat := m.getHandler(QAT).(*SimulatedHandler)
+
+	input := strings.Repeat("Hello World\n", 1000)
+	b := new(bytes.Buffer)
+	w := NewWriter(b)
+	w.Apply(ManagerOption(m), AlgorithmOption(DEFLATE), StreamOption(true))
+	for i := 0; i < 10; i++ {
+		if _, err := w.Write([]byte(input[i*1200 : (i+1)*1200])); err != nil {
+			t.Fatalf("TestFail: write %d failed with '%v'", i, err)
+		}
+	}
+	if s := w.Stats(); s.Jobs != 1 || s.Requests != 10 || qat.Load().InFlight != 1 {
+		t.Errorf("TestFail: expected one open job for the stream, stats %+v, load %+v", s, qat.Load())
+	}
+
+	// Everything written before Flush can be decoded.
+	if err := w.Flush(); err != nil {
+		t.Fatalf("TestFail: flush failed with '%v'", err)
+	}
+	partial, _ := io.ReadAll(flate.NewReader(bytes.NewReader(b.Bytes())))
+	if string(partial) != input {
+		t.Errorf("TestFail: %d of %d bytes decodable after flush", len(partial), len(input))
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("TestFail: close failed with '%v'", err)
+	}
+	v[DEFLATE].Validate(input, b.Bytes(), t)
+	if s := w.Stats(); s.BytesOut != int64(b.Len()) || qat.Load().InFlight != 0 {
+		t.Errorf("TestFail: stream not completed, %d of %d bytes counted, load %+v", s.BytesOut, b.Len(), qat.Load())
+	}
+
+	// An empty stream is still a valid one.
+	b.Reset()
+	w.Reset(b)
+	if err := w.Close(); err != nil || b.Len() == 0 {
+		t.Fatalf("TestFail: empty stream close returned '%v' with %d bytes", err, b.Len())
+	}
+	v[DEFLATE].Validate("", b.Bytes(), t)
+
+	// Writes after a failure do not start a second stream.
+	b.Reset()
+	w.Reset(b)
+	qat.SetFault(FailAfter(ErrNotAvailable, 1))
+	w.Write([]byte(input))
+	if _, err := w.Write([]byte(input)); err != ErrNotAvailable {
+		t.Errorf("TestFail: expected the handler error, received '%v'", err)
+	}
+	if _, err := w.Write([]byte(input)); err != ErrNotAvailable {
+		t.Errorf("TestFail: expected the stream to stay failed, received '%v'", err)
+	}
+	if qat.Load().InFlight != 0 {
+		t.Errorf("TestFail: failed stream not released: %+v", qat.Load())
+	}
+}
+
+func TestFlushOption(t *testing.T) {
+	m, err := NewManager(SimulatedHandlersOption())
+	if err != nil {
+		t.Fatalf("TestInit: could not create manager: '%v'", err)
+	}
+	m.SetPolicy(fixed(QAT, DEFAULT))
+	qat := m.getHandler(QAT).(*SimulatedHandler)
+	m.Apply(HandlerOption(QAT, HandlerFuncs{RequestFunc: qat.Request, ReleaseFunc: qat.Release}))
+
+	input := strings.Repeat("Hello World\n", 1000)
+	for _, flush := range []bool{false, true} {
+		b := new(bytes.Buffer)
+		w := NewWriter(b)
+		w.Apply(ManagerOption(m), AlgorithmOption(DEFLATE), StreamOption(true), FlushOption(flush))
+		w.Write([]byte(input))
+		err := w.Flush()
+		s, _ := w.LastStrategy()
+		if flush && (err != nil || s != DEFAULT) {
+			t.Errorf("TestFail: flushed stream ran on %v, flush returned '%v'", s, err)
+		}
+		if !flush && (err != ErrUnsupported || s != QAT) {
+			t.Errorf("TestFail: stream ran on %v, flush returned '%v'", s, err)
+		}
+		w.Close()
+	}
+}
@@ -0,0 +1,159 @@
+package dcl
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrParamTag   = errors.New("tag parameter invalid")
+	ErrParamQuota = errors.New("quota parameter invalid")
+	ErrTagQuota   = errors.New("tag reached its quota for the strategy")
+)
+
+// Longest tag accepted by TagOption.
+const MAX_TAG_LEN = 256
+
+// TagOption labels the jobs of the Reader/Writer with the tenant or subsystem
+// they belong to. The tag selects the policy registered with
+// Manager.SetTagPolicy and counts the jobs against the tag's quotas. It is
+// passed to policies as PolicyParameters.Tag.
+func TagOption(tag string) Option {
+	return func(a applier) error {
+		if len(tag) > MAX_TAG_LEN {
+			return ErrParamTag
+		}
+
+		switch z := a.(type) {
+		case *Reader:
+			z.p.tag = tag
+		case *Writer:
+			z.p.tag = tag
+		default:
+			return ErrApplyInvalidType
+		}
+
+		return nil
+	}
+}
+
+// SetTagPolicy registers the policy used by SubmitJob for jobs with the given
+// tag. A nil policy removes it, so the tag uses the global policy again.
+func (m *Manager) SetTagPolicy(tag string, policy PolicyFunc) {
+	m.policyLock.Lock()
+	defer m.policyLock.Unlock()
+	if policy == nil {
+		delete(m.tagPolicies, tag)
+		return
+	}
+	if m.tagPolicies == nil {
+		m.tagPolicies = make(map[string]PolicyFunc)
+	}
+	m.tagPolicies[tag] = policy
+}
+
+// TagPolicy returns the policy used by SubmitJob for jobs with the given tag.
+func (m *Manager) TagPolicy(tag string) PolicyFunc {
+	m.policyLock.RLock()
+	defer m.policyLock.RUnlock()
+	if p, ok := m.tagPolicies[tag]; ok {
+		return p
+	}
+	return m.policy
+}
+
+// SetTagQuota limits the jobs with the given tag that strategy s may hold at
+// once. Jobs that would exceed it skip the strategy as if it were busy, so one
+// tenant cannot take every accelerator session. A limit of zero removes the
+// quota.
+func (m *Manager) SetTagQuota(tag string, s StrategyType, limit int) error {
+	if !s.IsValid() || limit < 0 {
+		return ErrParamQuota
+	}
+	m.quotas.setLimit(tag, s, limit)
+	return nil
+}
+
+// TagQuota returns the number of jobs with the given tag that strategy s holds
+// and the tag's limit for it, zero if there is none.
+func (m *Manager) TagQuota(tag string, s StrategyType) (inFlight, limit int) {
+	if !s.IsValid() {
+		return 0, 0
+	}
+	return m.quotas.usage(tag, s)
+}
+
+type tagCounts [DEFAULT + 1]int
+
+// tagQuotas counts the jobs each tag holds on every strategy, from the request
+// that starts them until they are released.
+type tagQuotas struct {
+	lock   sync.Mutex
+	limits map[string]*tagCounts
+	used   map[string]*tagCounts
+}
+
+func (q *tagQuotas) setLimit(tag string, s StrategyType, limit int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.limits == nil {
+		q.limits = make(map[string]*tagCounts)
+	}
+	l := q.limits[tag]
+	if l == nil {
+		if limit == 0 {
+			return
+		}
+		l = new(tagCounts)
+		q.limits[tag] = l
+	}
+	l[s] = limit
+	if *l == (tagCounts{}) {
+		delete(q.limits, tag)
+	}
+}
+
+func (q *tagQuotas) usage(tag string, s StrategyType) (inFlight, limit int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if u := q.used[tag]; u != nil {
+		inFlight = u[s]
+	}
+	if l := q.limits[tag]; l != nil {
+		limit = l[s]
+	}
+	return inFlight, limit
+}
+
+// acquire takes a slot of strategy s for a job with the given tag. It returns
+// false if the tag has reached its quota.
+func (q *tagQuotas) acquire(tag string, s StrategyType) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	u := q.used[tag]
+	if l := q.limits[tag]; l != nil && l[s] > 0 && u != nil && u[s] >= l[s] {
+		return false
+	}
+	if u == nil {
+		if q.used == nil {
+			q.used = make(map[string]*tagCounts)
+		}
+		u = new(tagCounts)
+		q.used[tag] = u
+	}
+	u[s]++
+	return true
+}
+
+func (q *tagQuotas) release(tag string, s StrategyType) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	u := q.used[tag]
+	if u == nil || u[s] == 0 {
+		return
+	}
+	u[s]--
+	if *u == (tagCounts{}) {
+		delete(q.used, tag)
+	}
+}
@@ -0,0 +1,72 @@
+package dcl
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTagPolicy(t *testing.T) {
+	m := &Manager{policy: fixed(QAT)}
+	m.SetTagPolicy("batch", fixed(IAA))
+	params := &PolicyParameters{}
+	if got := m.TagPolicy("batch")(params); !reflect.DeepEqual(got, []StrategyType{IAA}) {
+		t.Errorf("TestFail: tag policy returned %v", got)
+	}
+	if got := m.TagPolicy("web")(params); !reflect.DeepEqual(got, []StrategyType{QAT}) {
+		t.Errorf("TestFail: untagged policy returned %v", got)
+	}
+	m.SetTagPolicy("batch", nil)
+	if got := m.TagPolicy("batch")(params); !reflect.DeepEqual(got, []StrategyType{QAT}) {
+		t.Errorf("TestFail: removed tag policy returned %v", got)
+	}
+
+	w := NewWriter(new(bytes.Buffer))
+	if err := w.Apply(TagOption("batch")); err != nil || w.p.tag != "batch" {
+		t.Errorf("TestFail: tag not applied: '%v'", err)
+	}
+	if err := w.Apply(TagOption(strings.Repeat("x", MAX_TAG_LEN+1))); err != ErrParamTag {
+		t.Errorf("TestFail: long tag accepted: '%v'", err)
+	}
+}
+
+func TestTagQuota(t *testing.T) {
+	m := newTestManager()
+	m.SetPolicy(fixed(DEFAULT))
+	if err := m.SetTagQuota("a", DEFAULT, 1); err != nil {
+		t.Fatalf("TestInit: could not set quota: '%v'", err)
+	}
+	if err := m.SetTagQuota("a", StrategyType(42), 1); err != ErrParamQuota {
+		t.Errorf("TestFail: invalid strategy accepted: '%v'", err)
+	}
+
+	// Hold the only slot of tag "a" as a long running job would.
+	m.quotas.acquire("a", DEFAULT)
+	write := func(tag string) error {
+		z := NewWriter(new(bytes.Buffer))
+		z.m = m
+		z.Apply(TagOption(tag))
+		_, err := z.Write([]byte("Hello World"))
+		return err
+	}
+	if err := write("a"); err != ErrNoWorkingStrategies {
+		t.Errorf("TestFail: job over quota returned '%v'", err)
+	}
+	if err := write("b"); err != nil {
+		t.Errorf("TestFail: job of another tag returned '%v'", err)
+	}
+
+	m.quotas.release("a", DEFAULT)
+	if err := write("a"); err != nil {
+		t.Errorf("TestFail: job within quota returned '%v'", err)
+	}
+	if inFlight, limit := m.TagQuota("a", DEFAULT); inFlight != 0 || limit != 1 {
+		t.Errorf("TestFail: expected 0/1 slots in use, received %d/%d", inFlight, limit)
+	}
+
+	m.SetTagQuota("a", DEFAULT, 0)
+	if _, limit := m.TagQuota("a", DEFAULT); limit != 0 {
+		t.Errorf("TestFail: quota not removed, limit %d", limit)
+	}
+}
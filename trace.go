@@ -0,0 +1,311 @@
+package dcl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTraceFormat  = errors.New("not a dcl trace")
+	ErrTraceVersion = errors.New("unsupported trace version")
+	ErrTraceActive  = errors.New("a trace is already being recorded")
+	ErrTraceClosed  = errors.New("trace writer closed")
+)
+
+const (
+	TRACE_MAGIC   = "DCLTRACE"
+	TRACE_VERSION = 1
+	noStrategy    = 0xff
+	flagContinued = 1 << 0
+)
+
+type TraceResult uint8
+
+const (
+	TRACE_OK          TraceResult = iota // Request handled, more data may follow
+	TRACE_EOF                            // Request handled and the job finished
+	TRACE_ERROR                          // A strategy returned an error
+	TRACE_NO_STRATEGY                    // No strategy could take the job
+)
+
+func (r TraceResult) String() string {
+	switch r {
+	case TRACE_OK:
+		return "ok"
+	case TRACE_EOF:
+		return "eof"
+	case TRACE_ERROR:
+		return "error"
+	case TRACE_NO_STRATEGY:
+		return "no strategy"
+	}
+	return "unknown"
+}
+
+// TraceRecord describes one call to SubmitWithPolicy. Strategy is only set
+// when Result is not TRACE_NO_STRATEGY. Continued is set for calls that went
+// to a job started by an earlier call, which do not evaluate a policy.
+type TraceRecord struct {
+	Time      time.Time
+	Job       JobID
+	Size      int
+	Algorithm Algorithm
+	Level     int
+	Direction Direction
+	Tag       string
+	Strategy  StrategyType
+	Result    TraceResult
+	Fallbacks int
+	Latency   time.Duration
+	Continued bool
+}
+
+// TraceWriter encodes trace records. The format is a header holding
+// TRACE_MAGIC, the version and the start time, followed by varint encoded
+// records with times stored as the delta from the previous record.
+type TraceWriter struct {
+	lock sync.Mutex
+	w    *bufio.Writer
+	last time.Time
+	buf  []byte
+	err  error
+}
+
+func NewTraceWriter(w io.Writer) (*TraceWriter, error) {
+	t := &TraceWriter{
+		w:    bufio.NewWriter(w),
+		last: time.Now().Round(0), // Wall clock only, as stored in the trace
+	}
+	t.buf = append(t.buf, TRACE_MAGIC...)
+	t.buf = append(t.buf, TRACE_VERSION)
+	t.buf = binary.AppendVarint(t.buf, t.last.UnixNano())
+	if _, err := t.w.Write(t.buf); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *TraceWriter) Write(rec TraceRecord) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+
+	strategy := byte(noStrategy)
+	if rec.Result != TRACE_NO_STRATEGY {
+		strategy = byte(rec.Strategy)
+	}
+	var flags byte
+	if rec.Continued {
+		flags |= flagContinued
+	}
+
+	now := rec.Time.Round(0)
+	b := t.buf[:0]
+	b = binary.AppendVarint(b, int64(now.Sub(t.last)))
+	b = binary.AppendUvarint(b, uint64(rec.Job))
+	b = binary.AppendUvarint(b, uint64(rec.Size))
+	b = append(b, byte(rec.Algorithm))
+	b = binary.AppendVarint(b, int64(rec.Level))
+	b = append(b, byte(rec.Direction), strategy, byte(rec.Result), flags)
+	b = binary.AppendUvarint(b, uint64(rec.Fallbacks))
+	b = binary.AppendUvarint(b, uint64(rec.Latency))
+	b = binary.AppendUvarint(b, uint64(len(rec.Tag)))
+	b = append(b, rec.Tag...)
+	t.buf = b
+	t.last = now
+
+	_, t.err = t.w.Write(b)
+	return t.err
+}
+
+func (t *TraceWriter) Flush() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	t.err = t.w.Flush()
+	return t.err
+}
+
+// Close flushes the trace. Records written afterwards are dropped.
+func (t *TraceWriter) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err == ErrTraceClosed {
+		return nil
+	}
+	if t.err != nil {
+		return t.err
+	}
+	if err := t.w.Flush(); err != nil {
+		t.err = err
+		return err
+	}
+	t.err = ErrTraceClosed
+	return nil
+}
+
+func (t *TraceWriter) record(p []byte, jp JobParams, start time.Time, res *jobResult) {
+	rec := TraceRecord{
+		Time:      start,
+		Job:       res.id,
+		Size:      len(p),
+		Algorithm: jp.a,
+		Level:     jp.level,
+		Direction: jp.JobType,
+		Tag:       jp.tag,
+		Strategy:  res.strategy,
+		Fallbacks: res.fallbacks,
+		Latency:   time.Since(start),
+		Continued: res.continued,
+	}
+	switch {
+	case !res.served:
+		rec.Result = TRACE_NO_STRATEGY
+	case res.err == io.EOF:
+		rec.Result = TRACE_EOF
+	case res.err != nil:
+		rec.Result = TRACE_ERROR
+	}
+	t.Write(rec)
+}
+
+type TraceReader struct {
+	r    *bufio.Reader
+	last time.Time
+}
+
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	t := &TraceReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(TRACE_MAGIC)+1)
+	if _, err := io.ReadFull(t.r, magic); err != nil || string(magic[:len(TRACE_MAGIC)]) != TRACE_MAGIC {
+		return nil, ErrTraceFormat
+	}
+	if magic[len(TRACE_MAGIC)] != TRACE_VERSION {
+		return nil, ErrTraceVersion
+	}
+	start, err := binary.ReadVarint(t.r)
+	if err != nil {
+		return nil, ErrTraceFormat
+	}
+	t.last = time.Unix(0, start)
+	return t, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the trace.
+func (t *TraceReader) Next() (rec TraceRecord, err error) {
+	delta, err := binary.ReadVarint(t.r)
+	if err != nil {
+		return rec, err
+	}
+
+	var fixed [5]byte
+	var job, size, fallbacks, latency, tagLen uint64
+	var level int64
+	fail := func(err error) (TraceRecord, error) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return TraceRecord{}, fmt.Errorf("corrupt trace record: %w", err)
+	}
+	if job, err = binary.ReadUvarint(t.r); err != nil {
+		return fail(err)
+	}
+	if size, err = binary.ReadUvarint(t.r); err != nil {
+		return fail(err)
+	}
+	if fixed[0], err = t.r.ReadByte(); err != nil {
+		return fail(err)
+	}
+	if level, err = binary.ReadVarint(t.r); err != nil {
+		return fail(err)
+	}
+	if _, err = io.ReadFull(t.r, fixed[1:]); err != nil {
+		return fail(err)
+	}
+	if fallbacks, err = binary.ReadUvarint(t.r); err != nil {
+		return fail(err)
+	}
+	if latency, err = binary.ReadUvarint(t.r); err != nil {
+		return fail(err)
+	}
+	if tagLen, err = binary.ReadUvarint(t.r); err != nil {
+		return fail(err)
+	}
+	if tagLen > 1<<16 {
+		return fail(errors.New("tag too long"))
+	}
+	tag := make([]byte, tagLen)
+	if _, err = io.ReadFull(t.r, tag); err != nil {
+		return fail(err)
+	}
+
+	t.last = t.last.Add(time.Duration(delta))
+	rec = TraceRecord{
+		Time:      t.last,
+		Job:       JobID(job),
+		Size:      int(size),
+		Algorithm: Algorithm(fixed[0]),
+		Level:     int(level),
+		Direction: Direction(fixed[1]),
+		Tag:       string(tag),
+		Result:    TraceResult(fixed[3]),
+		Fallbacks: int(fallbacks),
+		Latency:   time.Duration(latency),
+		Continued: fixed[4]&flagContinued != 0,
+	}
+	if fixed[2] != noStrategy {
+		rec.Strategy = StrategyType(fixed[2])
+	}
+	return rec, nil
+}
+
+// ReadTrace reads every record of a trace.
+func ReadTrace(r io.Reader) ([]TraceRecord, error) {
+	t, err := NewTraceReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var records []TraceRecord
+	for {
+		rec, err := t.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// StartTrace records every request submitted to the Manager to w until
+// StopTrace is called.
+func (m *Manager) StartTrace(w io.Writer) error {
+	t, err := NewTraceWriter(w)
+	if err != nil {
+		return err
+	}
+	if !m.trace.CompareAndSwap(nil, t) {
+		return ErrTraceActive
+	}
+	return nil
+}
+
+// StopTrace stops recording and flushes the trace. It returns the first error
+// met while writing it.
+func (m *Manager) StopTrace() error {
+	t := m.trace.Swap(nil)
+	if t == nil {
+		return nil
+	}
+	return t.Close()
+}
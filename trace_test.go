@@ -0,0 +1,133 @@
+package dcl
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceRecording(t *testing.T) {
+	m := newTestManager()
+	m.SetPolicy(fixed(ISAL, DEFAULT))
+	trace := new(bytes.Buffer)
+	if err := m.StartTrace(trace); err != nil {
+		t.Fatalf("TestInit: could not start trace: '%v'", err)
+	}
+	if err := m.StartTrace(io.Discard); err != ErrTraceActive {
+		t.Errorf("TestFail: second trace started: '%v'", err)
+	}
+
+	input := strings.Repeat("Hello World\n", 100)
+	b := new(bytes.Buffer)
+	z := NewWriter(b)
+	z.m = m
+	z.p.tag = "tenant"
+	if _, err := z.Write([]byte(input)); err != nil {
+		t.Fatalf("TestFail: write failed with '%v'", err)
+	}
+
+	m.SubmitWithPolicy([]byte(input), JobParams{a: LZ4, JobType: COMPRESS, w: io.Discard}, fixed(IAA))
+	if err := m.StopTrace(); err != nil {
+		t.Fatalf("TestFail: could not stop trace: '%v'", err)
+	}
+
+	records, err := ReadTrace(trace)
+	if err != nil {
+		t.Fatalf("TestFail: could not read trace: '%v'", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("TestFail: expected 2 records, received %d", len(records))
+	}
+	w := records[0]
+	if w.Size != len(input) || w.Algorithm != GZIP || w.Level != DEFAULT_LEVEL || w.Direction != COMPRESS ||
+		w.Tag != "tenant" || w.Strategy != DEFAULT || w.Fallbacks != 1 || w.Result != TRACE_OK || w.Continued {
+		t.Errorf("TestFail: unexpected compress record %+v", w)
+	}
+	if u := records[1]; u.Result != TRACE_NO_STRATEGY || u.Algorithm != LZ4 || u.Fallbacks != 1 {
+		t.Errorf("TestFail: unexpected unserved record %+v", u)
+	}
+
+	if _, err := ReadTrace(strings.NewReader("not a trace")); err != ErrTraceFormat {
+		t.Errorf("TestFail: invalid trace accepted: '%v'", err)
+	}
+}
+
+func TestTraceEncoding(t *testing.T) {
+	start := time.Now()
+	records := []TraceRecord{
+		{Time: start, Job: 1, Size: 100, Algorithm: ZSTD, Level: 3, Direction: COMPRESS, Strategy: QAT, Latency: time.Millisecond},
+		{Time: start.Add(time.Second), Job: 2, Size: 50, Algorithm: GZIP, Direction: DECOMPRESS, Tag: "db", Strategy: ISAL, Result: TRACE_EOF, Continued: true},
+		{Time: start.Add(-time.Second), Job: 3, Level: -1, Result: TRACE_NO_STRATEGY, Fallbacks: 4},
+	}
+	b := new(bytes.Buffer)
+	w, err := NewTraceWriter(b)
+	if err != nil {
+		t.Fatalf("TestInit: could not create trace: '%v'", err)
+	}
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("TestFail: write failed with '%v'", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("TestFail: close failed with '%v'", err)
+	}
+	if err := w.Write(records[0]); err != ErrTraceClosed {
+		t.Errorf("TestFail: write after close returned '%v'", err)
+	}
+
+	decoded, err := ReadTrace(b)
+	if err != nil {
+		t.Fatalf("TestFail: could not read trace: '%v'", err)
+	}
+	if len(decoded) != len(records) {
+		t.Fatalf("TestFail: expected %d records, received %d", len(records), len(decoded))
+	}
+	for i := range records {
+		if !decoded[i].Time.Equal(records[i].Time) {
+			t.Errorf("TestFail: record %d time %v, expected %v", i, decoded[i].Time, records[i].Time)
+		}
+		decoded[i].Time = records[i].Time
+		if decoded[i] != records[i] {
+			t.Errorf("TestFail: record %d decoded as %+v, expected %+v", i, decoded[i], records[i])
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Now()
+	var records []TraceRecord
+	for i := 0; i < 4; i++ {
+		records = append(records, TraceRecord{Time: start, Job: JobID(i), Size: 1000, Algorithm: GZIP, Strategy: DEFAULT})
+	}
+	records = append(records, TraceRecord{Time: start.Add(time.Second), Job: 9, Size: 1000, Algorithm: LZ4, Strategy: DEFAULT})
+
+	cost := CostModel{
+		QAT:     {Setup: time.Millisecond, Capacity: 2, Algorithms: []Algorithm{GZIP}},
+		DEFAULT: {Setup: 10 * time.Microsecond, NsPerByte: 1},
+	}
+	r := Replay(records, "qat-first", fixed(QAT, IAA, DEFAULT), cost)
+	if r.Requests != 5 || r.Jobs != 5 || r.Unserved != 0 {
+		t.Errorf("TestFail: unexpected totals %+v", r)
+	}
+	if r.Strategies[QAT] != 2 || r.Strategies[DEFAULT] != 3 {
+		t.Errorf("TestFail: unexpected distribution %v", r.Strategies)
+	}
+	// IAA is not installed; it is tried after QAT turned away two gzip jobs
+	// for capacity and the lz4 job as unsupported.
+	if r.Fallbacks != 6 {
+		t.Errorf("TestFail: expected 6 fallbacks, received %d", r.Fallbacks)
+	}
+	if r.P99 != time.Millisecond || r.MeanLatency() != (2*time.Millisecond+3*11*time.Microsecond)/5 {
+		t.Errorf("TestFail: unexpected latency p99 %v mean %v", r.P99, r.MeanLatency())
+	}
+
+	if r := Replay(records, "qat-only", fixed(QAT), cost); r.Unserved != 3 {
+		t.Errorf("TestFail: expected 3 unserved requests, received %d", r.Unserved)
+	}
+	if s := SummarizeTrace(records); s.Strategies[DEFAULT] != 5 {
+		t.Errorf("TestFail: unexpected recorded distribution %v", s.Strategies)
+	}
+}
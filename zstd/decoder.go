@@ -0,0 +1,250 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"runtime"
+
+	"dcl"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type decoderOptions struct {
+	concurrent int
+	maxWindow  uint64
+	maxMemory  uint64
+	dicts      [][]byte
+	options    []dcl.Option
+}
+
+// DOption is an option for NewReader.
+type DOption func(*decoderOptions) error
+
+// WithDecoderConcurrency sets how many DecodeAll calls may run at once, or
+// GOMAXPROCS for zero. Each stream is a single dcl job whatever the value. It
+// defaults to 4 or GOMAXPROCS, whichever is lower.
+func WithDecoderConcurrency(n int) DOption {
+	return func(o *decoderOptions) error {
+		if n < 0 {
+			return errors.New("concurrency must be at least 1")
+		}
+		if n == 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		o.concurrent = n
+		return nil
+	}
+}
+
+// WithDecoderMaxWindow rejects frames with a larger window than size. The
+// work then stays in software.
+func WithDecoderMaxWindow(size uint64) DOption {
+	return func(o *decoderOptions) error {
+		if size < MinWindowSize {
+			return errors.New("WithMaxWindowSize must be at least 1KB, 1024 bytes")
+		}
+		if size > MaxWindowSize {
+			return errors.New("WithMaxWindowSize must be at most MaxWindowSize")
+		}
+		o.maxWindow = size
+		return nil
+	}
+}
+
+// WithDecoderMaxMemory limits the output of DecodeAll to n bytes. Larger
+// results fail with ErrDecoderSizeExceeded. It defaults to 64GiB.
+func WithDecoderMaxMemory(n uint64) DOption {
+	return func(o *decoderOptions) error {
+		if n == 0 {
+			return errors.New("WithDecoderMaxMemory must be at least 1")
+		}
+		if n > 1<<63-1 {
+			return errors.New("WithDecoderMaxMemory must be less than 1 << 63")
+		}
+		o.maxMemory = n
+		return nil
+	}
+}
+
+// WithDecoderDicts registers dictionaries in the zstd dictionary format; each
+// frame selects one by ID. The work then stays in software.
+func WithDecoderDicts(dicts ...[]byte) DOption {
+	return func(o *decoderOptions) error {
+		d, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dicts...))
+		if err != nil {
+			return err
+		}
+		d.Close()
+		o.dicts = append(o.dicts, dicts...)
+		return nil
+	}
+}
+
+// WithDecoderOptions passes options, such as dcl.ManagerOption or
+// dcl.PolicyOption, to the dcl Readers doing the decompression.
+func WithDecoderOptions(options ...dcl.Option) DOption {
+	return func(o *decoderOptions) error {
+		o.options = append(o.options, options...)
+		return nil
+	}
+}
+
+// dclOptions returns the options of the dcl Readers of a Decoder.
+func (o *decoderOptions) dclOptions() []dcl.Option {
+	options := []dcl.Option{
+		dcl.AlgorithmOption(dcl.ZSTD),
+		dcl.PolicyOption(qatFirst),
+	}
+	if o.maxWindow != 0 {
+		options = append(options, dcl.WindowSizeOption(int(o.maxWindow)))
+	}
+	if len(o.dicts) > 0 {
+		options = append(options, dcl.DictionaryOption(o.dicts...))
+	}
+	return append(options, o.options...)
+}
+
+// Decoder decompresses a stream read from it, or whole buffers with
+// DecodeAll. A stream may hold several frames.
+type Decoder struct {
+	options   []dcl.Option
+	fallback  []dcl.Option
+	z         *dcl.Reader
+	r         io.Reader
+	sem       chan struct{}
+	maxMemory uint64
+	closed    bool
+}
+
+// NewReader returns a Decoder reading a stream from r. r may be nil if only
+// DecodeAll is used.
+func NewReader(r io.Reader, opts ...DOption) (*Decoder, error) {
+	o := decoderOptions{
+		concurrent: 4,
+		maxMemory:  64 << 30,
+	}
+	if n := runtime.GOMAXPROCS(0); n < o.concurrent {
+		o.concurrent = n
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	options := o.dclOptions()
+	d := &Decoder{
+		options:   options,
+		fallback:  append(options[:len(options):len(options)], dcl.PolicyOption(softwareOnly)),
+		r:         r,
+		sem:       make(chan struct{}, o.concurrent),
+		maxMemory: o.maxMemory,
+	}
+	d.z = dcl.NewReader(r)
+	if err := d.z.Apply(d.options...); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Read decompresses the stream into p.
+func (d *Decoder) Read(p []byte) (int, error) {
+	if d.closed {
+		return 0, ErrDecoderClosed
+	}
+	if d.r == nil {
+		return 0, ErrDecoderNilInput
+	}
+	return d.z.Read(p)
+}
+
+// Reset discards the current stream and starts reading a new one from r.
+func (d *Decoder) Reset(r io.Reader) error {
+	if d.closed {
+		return ErrDecoderClosed
+	}
+	d.r = r
+	d.z.Reset(r)
+	return nil
+}
+
+// Stats reports the requests made for the current stream.
+func (d *Decoder) Stats() dcl.Stats {
+	return d.z.Stats()
+}
+
+// Close releases the current stream. The Decoder cannot be used afterwards.
+func (d *Decoder) Close() {
+	if d.closed {
+		return
+	}
+	d.closed = true
+	d.z.Close()
+}
+
+// IOReadCloser returns the Decoder as an io.ReadCloser whose Close calls
+// Close on the Decoder.
+func (d *Decoder) IOReadCloser() io.ReadCloser {
+	return closeWrapper{d}
+}
+
+type closeWrapper struct {
+	d *Decoder
+}
+
+func (c closeWrapper) Read(p []byte) (int, error) {
+	return c.d.Read(p)
+}
+
+func (c closeWrapper) Close() error {
+	c.d.Close()
+	return nil
+}
+
+// DecodeAll decompresses all the frames of input and appends the result to
+// dst. Empty input appends nothing. If the strategy that took the job fails,
+// it is decompressed again in software, which reports the klauspost errors
+// for invalid input. It is safe for concurrent use.
+func (d *Decoder) DecodeAll(input, dst []byte) ([]byte, error) {
+	if d.closed {
+		return dst, ErrDecoderClosed
+	}
+	if len(input) == 0 {
+		return dst, nil
+	}
+	d.sem <- struct{}{}
+	defer func() { <-d.sem }()
+
+	out, software, err := d.decode(input, dst, d.options)
+	if err != nil && err != ErrDecoderSizeExceeded && !software {
+		out, _, err = d.decode(input, dst, d.fallback)
+	}
+	return out, err
+}
+
+// decode decompresses input as one job appended to dst. software reports
+// whether the job ran in software.
+func (d *Decoder) decode(input, dst []byte, options []dcl.Option) (out []byte, software bool, err error) {
+	z := dcl.NewReader(bytes.NewReader(input))
+	if err = z.Apply(options...); err != nil {
+		return dst, true, err
+	}
+	defer z.Close()
+	b := bytes.NewBuffer(dst)
+	limit := int64(d.maxMemory)
+	if limit < math.MaxInt64 {
+		limit++ // one more byte tells that the limit is exceeded
+	}
+	n, err := b.ReadFrom(io.LimitReader(z, limit))
+	s, served := z.LastStrategy()
+	software = served && s == dcl.DEFAULT
+	if err != nil {
+		return dst, software, err
+	}
+	if uint64(n) > d.maxMemory {
+		return dst, software, ErrDecoderSizeExceeded
+	}
+	return b.Bytes(), software, nil
+}
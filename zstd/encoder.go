@@ -0,0 +1,230 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+
+	"dcl"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type encoderOptions struct {
+	level      EncoderLevel
+	concurrent int
+	window     int
+	dict       []byte
+	options    []dcl.Option
+}
+
+// EOption is an option for NewWriter.
+type EOption func(*encoderOptions) error
+
+// WithEncoderLevel sets the compression level.
+func WithEncoderLevel(l EncoderLevel) EOption {
+	return func(o *encoderOptions) error {
+		if l < SpeedFastest || l > SpeedBestCompression {
+			return errors.New("unknown encoder level")
+		}
+		o.level = l
+		return nil
+	}
+}
+
+// WithEncoderConcurrency sets how many EncodeAll calls may run at once. Each
+// stream is a single dcl job whatever the value. It defaults to GOMAXPROCS.
+func WithEncoderConcurrency(n int) EOption {
+	return func(o *encoderOptions) error {
+		if n <= 0 {
+			return errors.New("concurrency must be at least 1")
+		}
+		o.concurrent = n
+		return nil
+	}
+}
+
+// WithWindowSize sets the window, a power of two between MinWindowSize and
+// MaxWindowSize. The work then stays in software.
+func WithWindowSize(n int) EOption {
+	return func(o *encoderOptions) error {
+		switch {
+		case n < MinWindowSize:
+			return fmt.Errorf("window size must be at least %d", MinWindowSize)
+		case n > MaxWindowSize:
+			return fmt.Errorf("window size must be at most %d", MaxWindowSize)
+		case (n & (n - 1)) != 0:
+			return errors.New("window size must be a power of 2")
+		}
+		o.window = n
+		return nil
+	}
+}
+
+// WithEncoderDict sets a dictionary in the zstd dictionary format. The work
+// then stays in software.
+func WithEncoderDict(dict []byte) EOption {
+	return func(o *encoderOptions) error {
+		e, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dict))
+		if err != nil {
+			return err
+		}
+		e.Close()
+		o.dict = dict
+		return nil
+	}
+}
+
+// WithEncoderOptions passes options, such as dcl.ManagerOption or
+// dcl.PolicyOption, to the dcl Writers doing the compression.
+func WithEncoderOptions(options ...dcl.Option) EOption {
+	return func(o *encoderOptions) error {
+		o.options = append(o.options, options...)
+		return nil
+	}
+}
+
+// dclOptions returns the options of the dcl Writers of an Encoder.
+func (o *encoderOptions) dclOptions() []dcl.Option {
+	options := []dcl.Option{
+		dcl.AlgorithmOption(dcl.ZSTD),
+		dcl.CompressionLevelOption(dclLevel(o.level)),
+		dcl.PolicyOption(qatFirst),
+	}
+	if o.window != 0 {
+		options = append(options, dcl.WindowSizeOption(o.window))
+	}
+	if o.dict != nil {
+		options = append(options, dcl.DictionaryOption(o.dict))
+	}
+	return append(options, o.options...)
+}
+
+// zstdOptions returns the same settings for the klauspost encoder.
+func (o *encoderOptions) zstdOptions() []zstd.EOption {
+	options := []zstd.EOption{zstd.WithEncoderLevel(o.level), zstd.WithEncoderConcurrency(1)}
+	if o.window != 0 {
+		options = append(options, zstd.WithWindowSize(o.window))
+	}
+	if o.dict != nil {
+		options = append(options, zstd.WithEncoderDict(o.dict))
+	}
+	return options
+}
+
+// Encoder compresses a stream written to it, or whole buffers with EncodeAll.
+// A stream runs as one dcl job on the strategy chosen for its first write.
+type Encoder struct {
+	options  []dcl.Option
+	fallback []dcl.Option
+	plain    *zstd.Encoder
+	z        *dcl.Writer
+	out      *switchWriter
+	sem      chan struct{}
+	closed   bool
+}
+
+// NewWriter returns an Encoder writing a stream to w. w may be nil if only
+// EncodeAll is used.
+func NewWriter(w io.Writer, opts ...EOption) (*Encoder, error) {
+	o := encoderOptions{
+		level:      SpeedDefault,
+		concurrent: runtime.GOMAXPROCS(0),
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	plain, err := zstd.NewWriter(nil, o.zstdOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	options := o.dclOptions()
+	e := &Encoder{
+		options:  options,
+		fallback: append(options[:len(options):len(options)], dcl.PolicyOption(softwareOnly)),
+		plain:    plain,
+		out:      &switchWriter{w},
+		sem:      make(chan struct{}, o.concurrent),
+	}
+	e.z = dcl.NewWriter(e.out)
+	if err := e.z.Apply(append(options[:len(options):len(options)], dcl.StreamOption(true), dcl.FlushOption(true))...); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Write compresses p into the stream.
+func (e *Encoder) Write(p []byte) (int, error) {
+	return e.z.Write(p)
+}
+
+// Flush writes out the data compressed so far, so that a reader can decode
+// everything written before the call. Streams only run on handlers that can
+// flush, unless dcl.FlushOption(false) is passed with WithEncoderOptions, in
+// which case Flush may return dcl.ErrUnsupported.
+func (e *Encoder) Flush() error {
+	return e.z.Flush()
+}
+
+// Close completes the stream. It does not close the underlying writer.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.z.Close()
+}
+
+// Reset discards the open stream, without writing more to its writer, and
+// starts a new stream to w.
+func (e *Encoder) Reset(w io.Writer) {
+	if !e.closed {
+		e.out.w = io.Discard
+	}
+	e.out = &switchWriter{w}
+	e.z.Reset(e.out)
+	e.closed = false
+}
+
+// Stats reports the requests made for the current stream.
+func (e *Encoder) Stats() dcl.Stats {
+	return e.z.Stats()
+}
+
+// EncodeAll compresses src into a single frame appended to dst. Empty input
+// appends nothing. If the strategy that took the job fails, it is compressed
+// again in software, and with the klauspost encoder if dcl cannot run it at
+// all. It is safe for concurrent use.
+func (e *Encoder) EncodeAll(src, dst []byte) []byte {
+	if len(src) == 0 {
+		return dst
+	}
+	e.sem <- struct{}{}
+	defer func() { <-e.sem }()
+
+	out, software, err := encode(src, dst, e.options)
+	if err != nil && !software {
+		out, _, err = encode(src, dst, e.fallback)
+	}
+	if err != nil {
+		return e.plain.EncodeAll(src, dst)
+	}
+	return out
+}
+
+// encode compresses src as one job appended to dst. software reports whether
+// the job ran in software.
+func encode(src, dst []byte, options []dcl.Option) (out []byte, software bool, err error) {
+	b := bytes.NewBuffer(dst)
+	z := dcl.NewWriter(b)
+	if err = z.Apply(options...); err != nil {
+		return dst, true, err
+	}
+	_, err = z.Write(src)
+	s, served := z.LastStrategy()
+	return b.Bytes(), served && s == dcl.DEFAULT, err
+}
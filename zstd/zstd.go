@@ -0,0 +1,90 @@
+// Package zstd mirrors the Encoder and Decoder of
+// github.com/klauspost/compress/zstd on top of dcl, so that moving existing
+// code to the library is an import path change. Streams and EncodeAll and
+// DecodeAll calls run on QAT when it is available and on the Go codec of the
+// DefaultHandler otherwise.
+//
+// Window sizes and dictionaries are only implemented by the Go codec, so
+// setting them keeps the work in software.
+package zstd
+
+import (
+	"io"
+
+	"dcl"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	MinWindowSize = zstd.MinWindowSize
+	MaxWindowSize = zstd.MaxWindowSize
+)
+
+// EncoderLevel is the klauspost encoder level, so its constants and String
+// method can be used unchanged.
+type EncoderLevel = zstd.EncoderLevel
+
+const (
+	SpeedFastest           = zstd.SpeedFastest
+	SpeedDefault           = zstd.SpeedDefault
+	SpeedBetterCompression = zstd.SpeedBetterCompression
+	SpeedBestCompression   = zstd.SpeedBestCompression
+)
+
+var (
+	// The klauspost errors, returned unchanged by the software codec and by
+	// the Encoder and Decoder, so existing comparisons keep working.
+	ErrMagicMismatch       = zstd.ErrMagicMismatch
+	ErrWindowSizeExceeded  = zstd.ErrWindowSizeExceeded
+	ErrDecoderSizeExceeded = zstd.ErrDecoderSizeExceeded
+	ErrUnknownDictionary   = zstd.ErrUnknownDictionary
+	ErrCRCMismatch         = zstd.ErrCRCMismatch
+	ErrDecoderClosed       = zstd.ErrDecoderClosed
+	ErrDecoderNilInput     = zstd.ErrDecoderNilInput
+)
+
+// EncoderLevelFromString converts the name of a level, as returned by its
+// String method, back to the level.
+func EncoderLevelFromString(s string) (bool, EncoderLevel) {
+	return zstd.EncoderLevelFromString(s)
+}
+
+// EncoderLevelFromZstd returns the encoder level closest to a zstd level.
+func EncoderLevelFromZstd(level int) EncoderLevel {
+	return zstd.EncoderLevelFromZstd(level)
+}
+
+// dclLevel maps an encoder level onto the zstd level submitted to dcl.
+// EncoderLevelFromZstd maps it back, so the Go codec keeps the same level.
+func dclLevel(l EncoderLevel) int {
+	switch l {
+	case SpeedFastest:
+		return 1
+	case SpeedBetterCompression:
+		return 7
+	case SpeedBestCompression:
+		return 11
+	}
+	return 3
+}
+
+// qatFirst is the policy of the Encoder and Decoder: QAT, then software.
+func qatFirst(*dcl.PolicyParameters) []dcl.StrategyType {
+	return []dcl.StrategyType{dcl.QAT, dcl.DEFAULT}
+}
+
+// softwareOnly is the policy EncodeAll and DecodeAll retry with when another
+// strategy fails.
+func softwareOnly(*dcl.PolicyParameters) []dcl.StrategyType {
+	return []dcl.StrategyType{dcl.DEFAULT}
+}
+
+// switchWriter lets Reset redirect the output of a stream it abandons.
+type switchWriter struct {
+	w io.Writer
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
@@ -0,0 +1,315 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"dcl"
+	"dcl/dcltest"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var testInput = []byte(strings.Repeat("Hello World\n", 10000))
+
+func klauspostDecode(t *testing.T, src []byte, opts ...zstd.DOption) []byte {
+	d, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		t.Fatalf("TestInit: could not create klauspost decoder: '%v'", err)
+	}
+	defer d.Close()
+	out, err := d.DecodeAll(src, nil)
+	if err != nil {
+		t.Fatalf("TestFail: klauspost could not decode the output: '%v'", err)
+	}
+	return out
+}
+
+func TestEncoder(t *testing.T) {
+	m := dcltest.NewManager(t)
+	for _, level := range []EncoderLevel{SpeedFastest, SpeedDefault, SpeedBetterCompression, SpeedBestCompression} {
+		b := new(bytes.Buffer)
+		e, err := NewWriter(b, WithEncoderLevel(level), WithEncoderOptions(dcl.ManagerOption(m)))
+		if err != nil {
+			t.Fatalf("TestInit: level %v rejected with '%v'", level, err)
+		}
+		for i := 0; i < len(testInput); i += 7000 {
+			end := i + 7000
+			if end > len(testInput) {
+				end = len(testInput)
+			}
+			if _, err := e.Write(testInput[i:end]); err != nil {
+				t.Fatalf("TestFail: write failed with '%v'", err)
+			}
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatalf("TestFail: flush failed with '%v'", err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf("TestFail: close failed with '%v'", err)
+		}
+		if s := e.Stats(); s.Jobs != 1 || s.Strategies[dcl.QAT].Requests == 0 {
+			t.Errorf("TestFail: level %v stream not compressed as one QAT job: %+v", level, s)
+		}
+		if out := klauspostDecode(t, b.Bytes()); !bytes.Equal(out, testInput) {
+			t.Errorf("TestFail: level %v read back as %d bytes", level, len(out))
+		}
+	}
+	if _, err := NewWriter(nil, WithEncoderLevel(0)); err == nil {
+		t.Errorf("TestFail: invalid level accepted")
+	}
+	if _, err := NewWriter(nil, WithWindowSize(3000)); err == nil {
+		t.Errorf("TestFail: invalid window size accepted")
+	}
+	if _, err := NewWriter(nil, WithEncoderConcurrency(0)); err == nil {
+		t.Errorf("TestFail: invalid concurrency accepted")
+	}
+}
+
+func TestEncoderFlush(t *testing.T) {
+	m := dcltest.NewManager(t)
+	// QAT comes first but cannot flush.
+	m.Use(dcl.QAT, func(next dcl.Handler) dcl.Handler {
+		return dcl.HandlerFuncs{RequestFunc: next.Request, ReleaseFunc: next.Release}
+	})
+	b := new(bytes.Buffer)
+	e, err := NewWriter(b, WithEncoderOptions(dcl.ManagerOption(m)))
+	if err != nil {
+		t.Fatalf("TestInit: could not create encoder: '%v'", err)
+	}
+	e.Write(testInput)
+	if err := e.Flush(); err != nil {
+		t.Fatalf("TestFail: flush failed with '%v'", err)
+	}
+	d, _ := zstd.NewReader(bytes.NewReader(b.Bytes()))
+	defer d.Close()
+	out := make([]byte, len(testInput))
+	if _, err := io.ReadFull(d, out); err != nil || !bytes.Equal(out, testInput) {
+		t.Errorf("TestFail: flushed data not readable: '%v'", err)
+	}
+	e.Close()
+}
+
+func TestEncoderReset(t *testing.T) {
+	m := dcltest.NewManager(t)
+	first, second := new(bytes.Buffer), new(bytes.Buffer)
+	e, err := NewWriter(first, WithEncoderOptions(dcl.ManagerOption(m)))
+	if err != nil {
+		t.Fatalf("TestInit: could not create encoder: '%v'", err)
+	}
+	e.Write(testInput)
+	written := first.Len()
+	e.Reset(second)
+	if first.Len() != written {
+		t.Errorf("TestFail: reset wrote %d bytes to the abandoned stream", first.Len()-written)
+	}
+	e.Write(testInput)
+	if err := e.Close(); err != nil {
+		t.Fatalf("TestFail: close failed with '%v'", err)
+	}
+	if out := klauspostDecode(t, second.Bytes()); !bytes.Equal(out, testInput) {
+		t.Errorf("TestFail: stream after reset read back as %d bytes", len(out))
+	}
+}
+
+func TestEncodeAllDecodeAll(t *testing.T) {
+	m := dcltest.NewManager(t)
+	e, err := NewWriter(nil, WithEncoderOptions(dcl.ManagerOption(m)))
+	if err != nil {
+		t.Fatalf("TestInit: could not create encoder: '%v'", err)
+	}
+	d, err := NewReader(nil, WithDecoderOptions(dcl.ManagerOption(m)))
+	if err != nil {
+		t.Fatalf("TestInit: could not create decoder: '%v'", err)
+	}
+	defer d.Close()
+
+	prefix := []byte("prefix")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dst := e.EncodeAll(testInput, append([]byte{}, prefix...))
+			if !bytes.HasPrefix(dst, prefix) {
+				t.Errorf("TestFail: EncodeAll did not append to dst")
+				return
+			}
+			if out := klauspostDecode(t, dst[len(prefix):]); !bytes.Equal(out, testInput) {
+				t.Errorf("TestFail: EncodeAll output read back as %d bytes", len(out))
+			}
+			out, err := d.DecodeAll(dst[len(prefix):], append([]byte{}, prefix...))
+			if err != nil || !bytes.Equal(out, append(append([]byte{}, prefix...), testInput...)) {
+				t.Errorf("TestFail: DecodeAll returned %d bytes, '%v'", len(out), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if dst := e.EncodeAll(nil, prefix); !bytes.Equal(dst, prefix) {
+		t.Errorf("TestFail: EncodeAll of empty input appended %d bytes", len(dst)-len(prefix))
+	}
+	if out, err := d.DecodeAll(nil, prefix); err != nil || !bytes.Equal(out, prefix) {
+		t.Errorf("TestFail: DecodeAll of empty input returned %d bytes, '%v'", len(out), err)
+	}
+}
+
+func TestEncodeAllFallback(t *testing.T) {
+	failing := errors.New("device failure")
+	cfg := dcl.SimulatedConfigFor(dcl.QAT)
+	cfg.Fault = dcl.FailJobs(failing, -1)
+	m := dcltest.NewManager(t, dcl.HandlerOption(dcl.QAT, dcl.NewSimulatedHandler(cfg)))
+
+	e, err := NewWriter(nil, WithEncoderOptions(dcl.ManagerOption(m)))
+	if err != nil {
+		t.Fatalf("TestInit: could not create encoder: '%v'", err)
+	}
+	dst := e.EncodeAll(testInput, nil)
+	if out := klauspostDecode(t, dst); !bytes.Equal(out, testInput) {
+		t.Errorf("TestFail: EncodeAll output read back as %d bytes", len(out))
+	}
+	d, err := NewReader(nil, WithDecoderOptions(dcl.ManagerOption(m)))
+	if err != nil {
+		t.Fatalf("TestInit: could not create decoder: '%v'", err)
+	}
+	if out, err := d.DecodeAll(dst, nil); err != nil || !bytes.Equal(out, testInput) {
+		t.Errorf("TestFail: DecodeAll returned %d bytes, '%v'", len(out), err)
+	}
+
+	// Without any working strategy the klauspost encoder is used.
+	m.Apply(dcl.HandlerOption(dcl.DEFAULT, dcl.NewSimulatedHandler(cfg)))
+	e, err = NewWriter(nil, WithEncoderOptions(dcl.ManagerOption(m)))
+	if err != nil {
+		t.Fatalf("TestInit: could not create encoder: '%v'", err)
+	}
+	prefix := []byte("prefix")
+	dst = e.EncodeAll(testInput, append([]byte{}, prefix...))
+	if out := klauspostDecode(t, dst[len(prefix):]); !bytes.HasPrefix(dst, prefix) || !bytes.Equal(out, testInput) {
+		t.Errorf("TestFail: EncodeAll output read back as %d bytes", len(out))
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	m := dcltest.NewManager(t)
+	src := zstdEncode(t, testInput)
+	stream := append(append([]byte{}, src...), src...)
+	d, err := NewReader(bytes.NewReader(stream), WithDecoderOptions(dcl.ManagerOption(m)))
+	if err != nil {
+		t.Fatalf("TestInit: could not create decoder: '%v'", err)
+	}
+	out, err := io.ReadAll(d)
+	if err != nil || !bytes.Equal(out, append(append([]byte{}, testInput...), testInput...)) {
+		t.Errorf("TestFail: two frames read back as %d bytes, '%v'", len(out), err)
+	}
+	if s := d.Stats(); s.Strategies[dcl.QAT].Requests == 0 {
+		t.Errorf("TestFail: stream not decompressed on QAT: %+v", s)
+	}
+
+	if err := d.Reset(bytes.NewReader(src)); err != nil {
+		t.Fatalf("TestFail: reset failed with '%v'", err)
+	}
+	rc := d.IOReadCloser()
+	if out, err := io.ReadAll(rc); err != nil || !bytes.Equal(out, testInput) {
+		t.Errorf("TestFail: stream after reset read back as %d bytes, '%v'", len(out), err)
+	}
+	rc.Close()
+	if _, err := d.Read(make([]byte, 10)); err != ErrDecoderClosed {
+		t.Errorf("TestFail: read after close returned '%v'", err)
+	}
+	if _, err := d.DecodeAll(src, nil); err != ErrDecoderClosed {
+		t.Errorf("TestFail: DecodeAll after close returned '%v'", err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	m := dcltest.NewManager(t)
+	d, err := NewReader(nil, WithDecoderOptions(dcl.ManagerOption(m)), WithDecoderMaxMemory(1000))
+	if err != nil {
+		t.Fatalf("TestInit: could not create decoder: '%v'", err)
+	}
+	defer d.Close()
+	if _, err := d.Read(make([]byte, 10)); err != ErrDecoderNilInput {
+		t.Errorf("TestFail: read without input returned '%v'", err)
+	}
+	if _, err := d.DecodeAll(zstdEncode(t, testInput), nil); err != ErrDecoderSizeExceeded {
+		t.Errorf("TestFail: DecodeAll above the memory limit returned '%v'", err)
+	}
+	if _, err := d.DecodeAll([]byte("not a zstd frame"), nil); err == nil {
+		t.Errorf("TestFail: DecodeAll accepted invalid input")
+	}
+	if _, err := NewReader(nil, WithDecoderMaxWindow(10)); err == nil {
+		t.Errorf("TestFail: invalid max window accepted")
+	}
+	if _, err := NewReader(nil, WithDecoderDicts([]byte("not a dictionary"))); err == nil {
+		t.Errorf("TestFail: invalid dictionary accepted")
+	}
+}
+
+func TestSoftwareOptions(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 600; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("record %d: name=item-%d value=%d state=%s\n", i, i*7, i*i, strings.Repeat("ab", i%13))))
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       1234,
+		Contents: samples,
+		History:  bytes.Join(samples[:100], nil),
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedFastest,
+	})
+	if err != nil {
+		t.Fatalf("TestInit: could not build dictionary: '%v'", err)
+	}
+	m := dcltest.NewManager(t)
+
+	for name, opts := range map[string][]EOption{
+		"dictionary": {WithEncoderDict(dict)},
+		"window":     {WithWindowSize(1 << 16)},
+	} {
+		b := new(bytes.Buffer)
+		e, err := NewWriter(b, append(opts, WithEncoderOptions(dcl.ManagerOption(m)))...)
+		if err != nil {
+			t.Fatalf("TestInit: %s rejected with '%v'", name, err)
+		}
+		e.Write(testInput)
+		if err := e.Close(); err != nil {
+			t.Fatalf("TestFail: %s close failed with '%v'", name, err)
+		}
+		if s := e.Stats(); s.Strategies[dcl.DEFAULT].Requests == 0 || s.Strategies[dcl.QAT].Requests != 0 {
+			t.Errorf("TestFail: %s stream not kept in software: %+v", name, s)
+		}
+
+		d, err := NewReader(bytes.NewReader(b.Bytes()), WithDecoderDicts(dict), WithDecoderMaxWindow(1<<20),
+			WithDecoderOptions(dcl.ManagerOption(m)))
+		if err != nil {
+			t.Fatalf("TestInit: could not create decoder: '%v'", err)
+		}
+		if out, err := io.ReadAll(d); err != nil || !bytes.Equal(out, testInput) {
+			t.Errorf("TestFail: %s stream read back as %d bytes, '%v'", name, len(out), err)
+		}
+		d.Close()
+	}
+
+	e, _ := NewWriter(nil, WithEncoderDict(dict), WithEncoderOptions(dcl.ManagerOption(m)))
+	dst := e.EncodeAll(testInput, nil)
+	if out := klauspostDecode(t, dst, zstd.WithDecoderDicts(dict)); !bytes.Equal(out, testInput) {
+		t.Errorf("TestFail: EncodeAll with dictionary read back as %d bytes", len(out))
+	}
+	d, _ := NewReader(nil, WithDecoderOptions(dcl.ManagerOption(m)))
+	if _, err := d.DecodeAll(dst, nil); err != ErrUnknownDictionary {
+		t.Errorf("TestFail: DecodeAll without the dictionary returned '%v'", err)
+	}
+}
+
+func zstdEncode(t *testing.T, src []byte) []byte {
+	e, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("TestInit: could not create klauspost encoder: '%v'", err)
+	}
+	return e.EncodeAll(src, nil)
+}